@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/google/go-cmp/cmp"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// stackSetDescription is the description of a stackset printed by the
+// describe command.
+type stackSetDescription struct {
+	Name               string                       `json:"name"`
+	Namespace          string                       `json:"namespace"`
+	Version            string                       `json:"version"`
+	Status             zv1.StackSetStatus           `json:"status"`
+	Stacks             []traffic.StackTrafficWeight `json:"stacks"`
+	GeneratedResources []interface{}                `json:"generatedResources"`
+}
+
+// describeStackSet prints the status of the stackset and its stacks, as well
+// as the Ingress, RouteGroup and traffic segments the controller generates
+// for the current actual traffic.
+func describeStackSet(ctx context.Context, client clientset.Interface, trafficSwitcher *traffic.Switcher) error {
+	stackset, err := client.ZalandoV1().StackSets(config.Namespace).Get(ctx, config.Stackset, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	stacks, err := trafficSwitcher.TrafficWeights(ctx, config.Stackset, config.Namespace)
+	if err != nil {
+		return err
+	}
+
+	ssc, err := newStackSetContainer(ctx, client, stackset)
+	if err != nil {
+		return err
+	}
+
+	resources, err := generateResources(ssc)
+	if err != nil {
+		return err
+	}
+
+	description := &stackSetDescription{
+		Name:               stackset.Name,
+		Namespace:          stackset.Namespace,
+		Version:            stackset.Spec.StackTemplate.Spec.Version,
+		Status:             stackset.Status,
+		Stacks:             stacks,
+		GeneratedResources: resources,
+	}
+	if config.Output != outputTable {
+		return printObject(description)
+	}
+
+	fmt.Printf("Name:       %s\n", description.Name)
+	fmt.Printf("Namespace:  %s\n", description.Namespace)
+	fmt.Printf("Version:    %s\n", description.Version)
+	if rollout := stackset.Status.Rollout; rollout != nil {
+		fmt.Printf("Rollout:    %s of stack %s\n", rollout.Phase, rollout.StackName)
+	}
+	if blueGreen := stackset.Status.BlueGreen; blueGreen != nil {
+		fmt.Printf("Blue/green: live stack %s, candidate stack %s\n", blueGreen.LiveStack, blueGreen.CandidateStack)
+	}
+	for _, scheduled := range stackset.Status.ScheduledTraffic {
+		fmt.Printf("Scheduled:  %.1f%% to stack %s at %s\n", scheduled.Weight, scheduled.StackName, scheduled.NotBefore)
+	}
+
+	fmt.Println("\nStacks:")
+	err = printStacksTable(stacks)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nGenerated resources:")
+	config.Output = outputYAML
+	for _, resource := range resources {
+		err := printObject(resource)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffStack prints the differences between the spec of the stack and the
+// spec of a stack created from the current stack template of its stackset.
+// It exits with code 1 if there are any.
+func diffStack(ctx context.Context, client clientset.Interface) error {
+	stack, err := client.ZalandoV1().Stacks(config.Namespace).Get(ctx, config.Stack, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	stacksetName, ok := stack.Labels[core.StacksetHeritageLabelKey]
+	if !ok {
+		return fmt.Errorf("stack %s doesn't belong to a stackset", stack.Name)
+	}
+
+	stackset, err := client.ZalandoV1().StackSets(config.Namespace).Get(ctx, stacksetName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	template := core.NewContainer(stackset, &core.SimpleTrafficReconciler{}, traffic.DefaultBackendWeightsAnnotationKey, nil, nil).GenerateStackSpec()
+
+	// Compare the JSON representation, which ignores the internal
+	// representation of e.g. resource quantities.
+	running, err := toUnstructured(stack.Spec)
+	if err != nil {
+		return err
+	}
+	expected, err := toUnstructured(template)
+	if err != nil {
+		return err
+	}
+
+	diff := cmp.Diff(running, expected)
+	if diff == "" {
+		fmt.Printf("Stack %s matches the stack template of stackset %s.\n", stack.Name, stackset.Name)
+		return nil
+	}
+
+	fmt.Printf("--- stack %s\n+++ stack template of stackset %s (version %s)\n%s", stack.Name, stackset.Name, stackset.Spec.StackTemplate.Spec.Version, diff)
+	os.Exit(1)
+	return nil
+}
+
+// newStackSetContainer collects the stacks and traffic segments of the
+// stackset like the controller does, and computes the traffic segments for
+// the actual traffic.
+func newStackSetContainer(ctx context.Context, client clientset.Interface, stackset *zv1.StackSet) (*core.StackSetContainer, error) {
+	stackset.APIVersion = core.APIVersion
+	stackset.Kind = core.KindStackSet
+
+	ssc := core.NewContainer(
+		stackset,
+		&core.SimpleTrafficReconciler{},
+		traffic.DefaultBackendWeightsAnnotationKey,
+		config.ClusterDomains,
+		nil,
+	)
+
+	heritageLabels := map[string]string{
+		core.StacksetHeritageLabelKey: stackset.Name,
+	}
+	stacks, err := client.ZalandoV1().Stacks(stackset.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(heritageLabels).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stacks of stackset %s/%s: %v", stackset.Namespace, stackset.Name, err)
+	}
+
+	for _, stack := range stacks.Items {
+		stack := stack
+		stack.APIVersion = core.APIVersion
+		stack.Kind = core.KindStack
+
+		sc := &core.StackContainer{
+			Stack: &stack,
+		}
+		segmentName := stack.Name + core.SegmentSuffix
+
+		if stack.Spec.Ingress != nil {
+			segment, err := client.NetworkingV1().Ingresses(stack.Namespace).Get(ctx, segmentName, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			if err == nil {
+				sc.Resources.IngressSegment = segment
+			}
+		}
+
+		if stack.Spec.RouteGroup != nil {
+			segment, err := client.RouteGroupV1().RouteGroups(stack.Namespace).Get(ctx, segmentName, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			if err == nil {
+				sc.Resources.RouteGroupSegment = segment
+			}
+		}
+
+		ssc.StackContainers[stack.UID] = sc
+	}
+
+	err = ssc.UpdateFromResources()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ssc.ComputeTrafficSegments()
+	if err != nil {
+		return nil, err
+	}
+	return ssc, nil
+}
+
+// generateResources returns the Ingress and RouteGroup of the stackset and
+// the traffic segments of its stacks, as generated by the controller.
+func generateResources(ssc *core.StackSetContainer) ([]interface{}, error) {
+	var resources []interface{}
+
+	if ssc.StackSet.Spec.Ingress != nil {
+		ingress, err := ssc.GenerateIngress()
+		if err != nil {
+			return nil, err
+		}
+		if ingress != nil {
+			ingress.APIVersion = "networking.k8s.io/v1"
+			ingress.Kind = "Ingress"
+			resources = append(resources, ingress)
+		}
+	}
+
+	if ssc.StackSet.Spec.RouteGroup != nil {
+		rg, err := ssc.GenerateRouteGroup()
+		if err != nil {
+			return nil, err
+		}
+		if rg != nil {
+			rg.APIVersion = core.APIVersion
+			rg.Kind = "RouteGroup"
+			resources = append(resources, rg)
+		}
+	}
+
+	for _, sc := range sortedStacks(ssc) {
+		ingress, err := sc.GenerateIngressSegment()
+		if err != nil {
+			return nil, err
+		}
+		if ingress != nil {
+			ingress.APIVersion = "networking.k8s.io/v1"
+			ingress.Kind = "Ingress"
+			resources = append(resources, ingress)
+		}
+
+		rg, err := sc.GenerateRouteGroupSegment()
+		if err != nil {
+			return nil, err
+		}
+		if rg != nil {
+			rg.APIVersion = core.APIVersion
+			rg.Kind = "RouteGroup"
+			resources = append(resources, rg)
+		}
+	}
+
+	return resources, nil
+}
+
+// sortedStacks returns the stacks of the stackset sorted by name.
+func sortedStacks(ssc *core.StackSetContainer) []*core.StackContainer {
+	stacks := make([]*core.StackContainer, 0, len(ssc.StackContainers))
+	for _, sc := range ssc.StackContainers {
+		stacks = append(stacks, sc)
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		return stacks[i].Name() < stacks[j].Name()
+	})
+	return stacks
+}
+
+// toUnstructured returns the JSON representation of the object as generic
+// maps and slices.
+func toUnstructured(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
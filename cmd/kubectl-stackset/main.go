@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
+	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	defaultWatchInterval = 2 * time.Second
+	defaultTimeout       = "10m"
+
+	// exitCodeNotReady is the exit code if the traffic wasn't switched
+	// within the timeout.
+	exitCodeNotReady = 2
+
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var (
+	config struct {
+		Stackset                    string
+		Stack                       string
+		Version                     string
+		Traffic                     []string
+		Namespace                   string
+		BackendWeightsAnnotationKey string
+		ClusterDomains              []string
+		Timeout                     time.Duration
+		Output                      string
+		Watch                       bool
+		Wait                        bool
+		Replicas                    int32
+		MinReplicas                 int32
+		MaxReplicas                 int32
+		Filename                    string
+		Prescale                    []string
+	}
+)
+
+func main() {
+	trafficCmd := kingpin.Command("traffic", "Show or switch the traffic of a stackset.").Default()
+	trafficCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	trafficCmd.Arg("traffic", "Either a stack and its traffic weight, or the traffic weights of all stacks as <stack>=<weight>.").StringsVar(&config.Traffic)
+	trafficCmd.Flag("watch", "Watch the traffic until the actual traffic matches the desired traffic. Exits with code 2 if it doesn't within the timeout.").BoolVar(&config.Watch)
+	trafficCmd.Flag("wait", "Wait until the actual traffic matches the desired traffic. Exits with code 2 if it doesn't within the timeout.").BoolVar(&config.Wait)
+	trafficCmd.Flag("timeout", "How long to watch or wait for the traffic to be switched.").Default(defaultTimeout).DurationVar(&config.Timeout)
+	rollbackCmd := kingpin.Command("rollback", "Switch all traffic back to the stack which most recently had traffic.")
+	rollbackCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	rollbackCmd.Flag("timeout", "How long to wait for the traffic to be switched, 0 to not wait. Exits with code 2 if it isn't switched in time.").Default(defaultTimeout).DurationVar(&config.Timeout)
+	listCmd := kingpin.Command("list", "List the stacks of a stackset with their status.")
+	listCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	describeCmd := kingpin.Command("describe", "Describe a stackset, including the Ingress, RouteGroup and traffic segments generated for it.")
+	describeCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	describeCmd.Flag("cluster-domain", "Main domain of the cluster, as configured in the controller. Can be repeated.").StringsVar(&config.ClusterDomains)
+	diffCmd := kingpin.Command("diff", "Show the differences between a stack and the stack template of its stackset. Exits with code 1 if there are any.")
+	diffCmd.Arg("stack", "Name of the stack.").Required().StringVar(&config.Stack)
+	scaleCmd := kingpin.Command("scale", "Scale a stack.")
+	scaleCmd.Arg("stack", "Name of the stack.").Required().StringVar(&config.Stack)
+	scaleCmd.Flag("replicas", "Number of replicas of a stack without autoscaler.").Default("-1").Int32Var(&config.Replicas)
+	scaleCmd.Flag("min-replicas", "Minimum number of replicas of an autoscaled stack.").Default("-1").Int32Var(&config.MinReplicas)
+	scaleCmd.Flag("max-replicas", "Maximum number of replicas of an autoscaled stack.").Default("-1").Int32Var(&config.MaxReplicas)
+	pinCmd := kingpin.Command("pin", "Pin a stack, so that it's never deleted and kept at a minimum number of replicas.")
+	pinCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	pinCmd.Arg("stack", "Name or version of the stack.").Required().StringVar(&config.Stack)
+	pinCmd.Flag("min-replicas", "Number of replicas the stack is kept at while it doesn't get traffic.").Default("-1").Int32Var(&config.MinReplicas)
+	unpinCmd := kingpin.Command("unpin", "Unpin a stack.")
+	unpinCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	unpinCmd.Arg("stack", "Name or version of the stack.").Required().StringVar(&config.Stack)
+	newVersionCmd := kingpin.Command("new-version", "Trigger a new version of a stackset, creating a new stack from its stack template.")
+	newVersionCmd.Arg("stackset", "Name of the stackset.").Required().StringVar(&config.Stackset)
+	newVersionCmd.Arg("version", "Version of the new stack.").Required().StringVar(&config.Version)
+	renderCmd := kingpin.Command("render", "Render the resources generated for a local stackset manifest, without accessing the cluster.")
+	renderCmd.Flag("filename", "File with the stackset and optionally its stacks, read from stdin by default.").Short('f').Default("-").StringVar(&config.Filename)
+	renderCmd.Flag("traffic", "Simulated traffic weight of a stack as <stack>=<weight>. Can be repeated.").StringsVar(&config.Traffic)
+	renderCmd.Flag("prescale", "Simulate prescaling a stack as <stack>=<replicas>. Can be repeated.").StringsVar(&config.Prescale)
+	renderCmd.Flag("cluster-domain", "Main domain of the cluster, as configured in the controller. Can be repeated.").StringsVar(&config.ClusterDomains)
+	kingpin.Flag("namespace", "Namespace of the stackset resource, defaults to the namespace of the current context.").Short('n').StringVar(&config.Namespace)
+	kingpin.Flag("output", "Output format, one of table, json or yaml.").Short('o').Default(outputTable).EnumVar(&config.Output, outputTable, outputJSON, outputYAML)
+	kingpin.Flag("backend-weights-key", "Deprecated, the traffic is read from the stackset resource.").Hidden().StringVar(&config.BackendWeightsAnnotationKey)
+	command := kingpin.Parse()
+
+	// Rendering works offline, so it doesn't need a Kubernetes client
+	if command == renderCmd.FullCommand() {
+		err := renderStackSet()
+		if err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	kubeConfig := newKubeConfig()
+	restConfig, err := kubeConfig.ClientConfig()
+	if err != nil {
+		log.Fatalf("Failed to setup Kubernetes client: %v.", err)
+	}
+
+	if config.Namespace == "" {
+		config.Namespace, _, err = kubeConfig.Namespace()
+		if err != nil {
+			log.Fatalf("Failed to get the namespace of the current context: %v.", err)
+		}
+	}
+
+	client, err := clientset.NewForConfig(restConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialize Kubernetes client: %v.", err)
+	}
+
+	trafficSwitcher := traffic.NewSwitcher(client)
+
+	ctx := context.Background()
+
+	switch command {
+	case trafficCmd.FullCommand():
+		err = switchTraffic(ctx, trafficSwitcher)
+	case rollbackCmd.FullCommand():
+		err = rollback(ctx, trafficSwitcher)
+	case listCmd.FullCommand():
+		err = listStacks(ctx, trafficSwitcher)
+	case describeCmd.FullCommand():
+		err = describeStackSet(ctx, client, trafficSwitcher)
+	case diffCmd.FullCommand():
+		err = diffStack(ctx, client)
+	case scaleCmd.FullCommand():
+		err = scaleStack(ctx, client)
+	case pinCmd.FullCommand():
+		err = pinStack(ctx, client, true)
+	case unpinCmd.FullCommand():
+		err = pinStack(ctx, client, false)
+	case newVersionCmd.FullCommand():
+		err = newVersion(ctx, client)
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+// exit logs the error and exits with exitCodeNotReady if the traffic wasn't
+// switched in time, or with 1 for all other errors.
+func exit(err error) {
+	var notReady *traffic.StacksNotReadyError
+	if errors.As(err, &notReady) {
+		log.Error(err)
+		os.Exit(exitCodeNotReady)
+	}
+	log.Fatal(err)
+}
+
+func newKubeConfig() clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	configOverrides := &clientcmd.ConfigOverrides{}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+}
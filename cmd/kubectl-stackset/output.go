@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// out is where the traffic and other objects are printed to.
+var out io.Writer = os.Stdout
+
+// printObject prints the object as JSON or YAML, depending on the configured
+// output format. JSON is printed as one document per line and YAML documents
+// are separated by "---", so that the output of watching can be streamed.
+func printObject(obj interface{}) error {
+	if config.Output == outputJSON {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+		return nil
+	}
+
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "---\n%s", data)
+	return nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const defaultRenderNamespace = "default"
+
+// renderStackSet prints the resources the controller generates for a local
+// stackset manifest, without accessing the cluster. The manifest may contain
+// stacks of the stackset, the stack of the current stack template is added
+// if it's missing. The actual traffic and the prescaling state of the stacks
+// can be simulated.
+func renderStackSet() error {
+	stackset, stacks, err := readManifests(config.Filename)
+	if err != nil {
+		return err
+	}
+
+	ssc, newStack, err := newRenderContainer(stackset, stacks)
+	if err != nil {
+		return err
+	}
+
+	var resources []interface{}
+	if newStack != nil {
+		resources = append(resources, newStack)
+	}
+
+	for _, sc := range sortedStacks(ssc) {
+		stackResources, err := generateStackResources(sc)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, stackResources...)
+	}
+
+	stacksetResources, err := generateResources(ssc)
+	if err != nil {
+		return err
+	}
+	resources = append(resources, stacksetResources...)
+
+	if config.Output == outputTable {
+		config.Output = outputYAML
+	}
+	for _, resource := range resources {
+		err := printObject(resource)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readManifests reads the stackset and its stacks from a YAML or JSON file
+// with one or more documents, or from stdin if the filename is "-".
+func readManifests(filename string) (*zv1.StackSet, []zv1.Stack, error) {
+	var reader io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var (
+		stackset *zv1.StackSet
+		stacks   []zv1.Stack
+	)
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		var document json.RawMessage
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+		if len(document) == 0 || string(document) == "null" {
+			continue
+		}
+
+		var typeMeta metav1.TypeMeta
+		err = json.Unmarshal(document, &typeMeta)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+
+		switch typeMeta.Kind {
+		case core.KindStackSet:
+			if stackset != nil {
+				return nil, nil, fmt.Errorf("%s contains more than one stackset", filename)
+			}
+			stackset = &zv1.StackSet{}
+			err = json.Unmarshal(document, stackset)
+		case core.KindStack:
+			var stack zv1.Stack
+			err = json.Unmarshal(document, &stack)
+			stacks = append(stacks, stack)
+		default:
+			return nil, nil, fmt.Errorf("unsupported kind %q in %s, expected %s or %s", typeMeta.Kind, filename, core.KindStackSet, core.KindStack)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s %s: %w", typeMeta.Kind, filename, err)
+		}
+	}
+
+	if stackset == nil {
+		return nil, nil, fmt.Errorf("%s doesn't contain a stackset", filename)
+	}
+	return stackset, stacks, nil
+}
+
+// newRenderContainer sets up the stackset container like the controller
+// does, with the stacks from the manifests, the stack of the current stack
+// template, and the simulated traffic and prescaling state. It returns the
+// new stack if it had to be created.
+func newRenderContainer(stackset *zv1.StackSet, stacks []zv1.Stack) (*core.StackSetContainer, *zv1.Stack, error) {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = defaultRenderNamespace
+	}
+	if stackset.Namespace == "" {
+		stackset.Namespace = namespace
+	}
+
+	// Resources which were never created don't have a UID, so their name is
+	// used instead to tell them apart.
+	if stackset.UID == "" {
+		stackset.UID = types.UID(stackset.Name)
+	}
+
+	// The stack of the current stack template is always rendered, even if
+	// the stackset observed its version before.
+	stackset.Status.ObservedStackVersion = ""
+
+	ssc := core.NewContainer(
+		stackset,
+		&core.SimpleTrafficReconciler{},
+		traffic.DefaultBackendWeightsAnnotationKey,
+		config.ClusterDomains,
+		nil,
+	)
+
+	for _, stack := range stacks {
+		stack := stack
+		if stack.Namespace == "" {
+			stack.Namespace = stackset.Namespace
+		}
+		if stack.UID == "" {
+			stack.UID = types.UID(stack.Name)
+		}
+		stack.APIVersion = core.APIVersion
+		stack.Kind = core.KindStack
+
+		ssc.StackContainers[stack.UID] = &core.StackContainer{
+			Stack: &stack,
+		}
+	}
+
+	var newStack *zv1.Stack
+	if sc, _ := ssc.NewStack(); sc != nil {
+		sc.Stack.APIVersion = core.APIVersion
+		sc.Stack.Kind = core.KindStack
+		newStack = sc.Stack.DeepCopy()
+
+		sc.Stack.UID = types.UID(sc.Stack.Name)
+		ssc.StackContainers[sc.Stack.UID] = sc
+	}
+
+	err := simulateTraffic(ssc, config.Traffic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = simulatePrescaling(ssc, config.Prescale)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = ssc.UpdateFromResources()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = ssc.ComputeTrafficSegments()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ssc, newStack, nil
+}
+
+// simulateTraffic sets the desired and actual traffic of the stackset to the
+// weights specified as <stack>=<weight>. The traffic of the manifest is kept
+// if no weights are specified.
+func simulateTraffic(ssc *core.StackSetContainer, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	weights, err := parseWeights(args)
+	if err != nil {
+		return err
+	}
+
+	for stack := range weights {
+		if stackContainer(ssc, stack) == nil {
+			return fmt.Errorf("stack %s not found", stack)
+		}
+	}
+
+	ssc.StackSet.Spec.Traffic = nil
+	ssc.StackSet.Status.Traffic = nil
+	for _, sc := range sortedStacks(ssc) {
+		weight, ok := weights[sc.Name()]
+		if !ok {
+			continue
+		}
+
+		ssc.StackSet.Spec.Traffic = append(ssc.StackSet.Spec.Traffic, &zv1.DesiredTraffic{
+			StackName: sc.Name(),
+			Weight:    weight,
+		})
+		ssc.StackSet.Status.Traffic = append(ssc.StackSet.Status.Traffic, &zv1.ActualTraffic{
+			StackName:   sc.Name(),
+			ServiceName: sc.Name(),
+			Weight:      weight,
+		})
+	}
+	return nil
+}
+
+// simulatePrescaling marks the stacks specified as <stack>=<replicas> as
+// prescaled to the number of replicas.
+func simulatePrescaling(ssc *core.StackSetContainer, args []string) error {
+	for _, arg := range args {
+		stack, value, ok := strings.Cut(arg, "=")
+		if !ok || stack == "" {
+			return fmt.Errorf("invalid prescaling %q, expected <stack>=<replicas>", arg)
+		}
+
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid prescaling %q: %v", arg, err)
+		}
+
+		sc := stackContainer(ssc, stack)
+		if sc == nil {
+			return fmt.Errorf("stack %s not found", stack)
+		}
+		sc.Stack.Status.Prescaling = zv1.PrescalingStatus{
+			Active:   true,
+			Replicas: int32(replicas),
+		}
+	}
+	return nil
+}
+
+// stackContainer returns the container of the stack with the name, or nil
+// if the stackset doesn't have such a stack.
+func stackContainer(ssc *core.StackSetContainer, name string) *core.StackContainer {
+	for _, sc := range ssc.StackContainers {
+		if sc.Name() == name {
+			return sc
+		}
+	}
+	return nil
+}
+
+// generateStackResources returns the Deployment, Service, HPA, Ingress,
+// RouteGroup and PlatformCredentialsSets of the stack, as generated by the
+// controller. The traffic segments are generated by generateResources.
+func generateStackResources(sc *core.StackContainer) ([]interface{}, error) {
+	var resources []interface{}
+
+	deployment := sc.GenerateDeployment()
+	deployment.APIVersion = "apps/v1"
+	deployment.Kind = "Deployment"
+	resources = append(resources, deployment)
+
+	service, err := sc.GenerateService()
+	if err != nil {
+		return nil, err
+	}
+	service.APIVersion = "v1"
+	service.Kind = "Service"
+	resources = append(resources, service)
+
+	hpa, err := sc.GenerateHPA()
+	if err != nil {
+		return nil, err
+	}
+	if hpa != nil {
+		hpa.APIVersion = "autoscaling/v2"
+		hpa.Kind = "HorizontalPodAutoscaler"
+		resources = append(resources, hpa)
+	}
+
+	ingress, err := sc.GenerateIngress()
+	if err != nil {
+		return nil, err
+	}
+	if ingress != nil {
+		ingress.APIVersion = "networking.k8s.io/v1"
+		ingress.Kind = "Ingress"
+		resources = append(resources, ingress)
+	}
+
+	rg, err := sc.GenerateRouteGroup()
+	if err != nil {
+		return nil, err
+	}
+	if rg != nil {
+		rg.APIVersion = core.APIVersion
+		rg.Kind = "RouteGroup"
+		resources = append(resources, rg)
+	}
+
+	for _, rsc := range sc.Stack.Spec.ConfigurationResources {
+		if !rsc.IsPlatformCredentialsSet() {
+			continue
+		}
+
+		pcs, err := sc.GeneratePlatformCredentialsSet(rsc.PlatformCredentialsSet)
+		if err != nil {
+			return nil, err
+		}
+		pcs.APIVersion = core.APIVersion
+		pcs.Kind = "PlatformCredentialsSet"
+		resources = append(resources, pcs)
+	}
+
+	return resources, nil
+}
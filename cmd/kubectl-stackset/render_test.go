@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden files of the tests.")
+
+func TestRenderStackSet(t *testing.T) {
+	buf := setTestConfig(t, outputTable, 0)
+	config.Namespace = ""
+	config.Filename = "testdata/render.yaml"
+	config.Traffic = []string{"my-app-v1=70", "my-app-v2=30"}
+	config.Prescale = []string{"my-app-v2=5"}
+	config.ClusterDomains = []string{"example.org"}
+
+	require.NoError(t, renderStackSet())
+
+	golden := "testdata/render.golden.yaml"
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(golden, buf.Bytes(), 0644))
+	}
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), buf.String())
+}
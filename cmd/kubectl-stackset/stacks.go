@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/util/retry"
+)
+
+// listStacks prints the stacks of the stackset with their traffic and
+// status.
+func listStacks(ctx context.Context, trafficSwitcher *traffic.Switcher) error {
+	stacks, err := trafficSwitcher.TrafficWeights(ctx, config.Stackset, config.Namespace)
+	if err != nil {
+		return err
+	}
+	if config.Output != outputTable {
+		return printObject(stacks)
+	}
+	return printStacksTable(stacks)
+}
+
+func printStacksTable(stacks []traffic.StackTrafficWeight) error {
+	w := tabwriter.NewWriter(os.Stdout, 8, 8, 4, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "STACK", "DESIRED TRAFFIC", "ACTUAL TRAFFIC", "READY", "PRESCALING", "PINNED", "NO TRAFFIC SINCE")
+
+	for _, stack := range stacks {
+		prescaling := "-"
+		if stack.Prescaling.Active {
+			prescaling = fmt.Sprintf("%d replicas", stack.Prescaling.Replicas)
+		}
+		noTrafficSince := "-"
+		if stack.NoTrafficSince != nil {
+			noTrafficSince = duration.HumanDuration(time.Since(stack.NoTrafficSince.Time))
+		}
+		fmt.Fprintf(w,
+			"%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+			stack.Name,
+			fmt.Sprintf("%.1f%%", stack.Weight),
+			fmt.Sprintf("%.1f%%", stack.ActualWeight),
+			fmt.Sprintf("%d/%d", stack.ReadyReplicas, stack.Replicas),
+			prescaling,
+			stack.Pinned,
+			noTrafficSince,
+		)
+	}
+
+	return w.Flush()
+}
+
+// scaleStack sets the replicas of a stack, or the minimum and maximum
+// replicas of its autoscaler.
+func scaleStack(ctx context.Context, client clientset.Interface) error {
+	if config.Replicas < 0 && config.MinReplicas < 0 && config.MaxReplicas < 0 {
+		return errors.New("either --replicas, --min-replicas or --max-replicas is required")
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		stack, err := client.ZalandoV1().Stacks(config.Namespace).Get(ctx, config.Stack, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		autoscaler := stack.Spec.StackSpec.Autoscaler
+		switch {
+		case autoscaler == nil && config.Replicas < 0:
+			return fmt.Errorf("stack %s isn't autoscaled, use --replicas", stack.Name)
+		case autoscaler == nil:
+			stack.Spec.StackSpec.Replicas = &config.Replicas
+		case config.Replicas >= 0:
+			return fmt.Errorf("stack %s is autoscaled, use --min-replicas and --max-replicas", stack.Name)
+		default:
+			if config.MinReplicas >= 0 {
+				autoscaler.MinReplicas = &config.MinReplicas
+			}
+			if config.MaxReplicas >= 0 {
+				autoscaler.MaxReplicas = config.MaxReplicas
+			}
+			if autoscaler.MinReplicas != nil && *autoscaler.MinReplicas > autoscaler.MaxReplicas {
+				return fmt.Errorf("min replicas %d of stack %s exceed its max replicas %d", *autoscaler.MinReplicas, stack.Name, autoscaler.MaxReplicas)
+			}
+		}
+
+		_, err = client.ZalandoV1().Stacks(config.Namespace).Update(ctx, stack, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		log.Infof("Scaled stack %s.", stack.Name)
+		return nil
+	})
+}
+
+// pinStack pins or unpins a stack in the lifecycle of its stackset.
+func pinStack(ctx context.Context, client clientset.Interface, pin bool) error {
+	stackName, err := findStack(ctx, client, config.Stackset, config.Stack)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		stackset, err := client.ZalandoV1().StackSets(config.Namespace).Get(ctx, config.Stackset, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		pinned := make([]zv1.PinnedStack, 0, len(stackset.Spec.StackLifecycle.Pinned)+1)
+		for _, p := range stackset.Spec.StackLifecycle.Pinned {
+			if p.StackName != stackName {
+				pinned = append(pinned, p)
+			}
+		}
+		if pin {
+			p := zv1.PinnedStack{StackName: stackName}
+			if config.MinReplicas >= 0 {
+				p.MinReplicas = &config.MinReplicas
+			}
+			pinned = append(pinned, p)
+		}
+		stackset.Spec.StackLifecycle.Pinned = pinned
+
+		_, err = client.ZalandoV1().StackSets(config.Namespace).Update(ctx, stackset, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+
+		if pin {
+			log.Infof("Pinned stack %s.", stackName)
+		} else {
+			log.Infof("Unpinned stack %s.", stackName)
+		}
+		return nil
+	})
+}
+
+// newVersion sets the version of the stack template of the stackset, which
+// makes the controller create a new stack.
+func newVersion(ctx context.Context, client clientset.Interface) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		stackset, err := client.ZalandoV1().StackSets(config.Namespace).Get(ctx, config.Stackset, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if stackset.Spec.StackTemplate.Spec.Version == config.Version {
+			return fmt.Errorf("stackset %s is already at version %s", stackset.Name, config.Version)
+		}
+
+		stackName := stackset.Name + "-" + config.Version
+		_, err = client.ZalandoV1().Stacks(config.Namespace).Get(ctx, stackName, metav1.GetOptions{})
+		if err == nil {
+			return fmt.Errorf("stack %s already exists", stackName)
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		stackset.Spec.StackTemplate.Spec.Version = config.Version
+		delete(stackset.Annotations, traffic.RollbackAnnotationKey)
+		_, err = client.ZalandoV1().StackSets(config.Namespace).Update(ctx, stackset, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		log.Infof("Triggered version %s of stackset %s, the controller creates stack %s.", config.Version, stackset.Name, stackName)
+		return nil
+	})
+}
+
+// findStack returns the name of the stack of the stackset referred to by its
+// name or its version.
+func findStack(ctx context.Context, client clientset.Interface, stackset, name string) (string, error) {
+	for _, stackName := range []string{name, stackset + "-" + name} {
+		stack, err := client.ZalandoV1().Stacks(config.Namespace).Get(ctx, stackName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if stack.Labels[core.StacksetHeritageLabelKey] != stackset {
+			return "", fmt.Errorf("stack %s doesn't belong to stackset %s", stack.Name, stackset)
+		}
+		return stack.Name, nil
+	}
+	return "", fmt.Errorf("stack %s not found in stackset %s/%s", name, config.Namespace, stackset)
+}
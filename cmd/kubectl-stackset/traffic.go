@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// switchTraffic prints the traffic of the stackset, after switching it if
+// traffic weights are specified. When watching or waiting for the traffic,
+// it first waits for the controller to pick up the switch.
+func switchTraffic(ctx context.Context, trafficSwitcher *traffic.Switcher) error {
+	stacks, err := updateTraffic(ctx, trafficSwitcher, config.Traffic)
+	if err != nil {
+		return err
+	}
+
+	if !config.Watch && !config.Wait {
+		return printTraffic(stacks)
+	}
+
+	// Waiting for the controller and for the traffic share the timeout
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	// Print the traffic once the controller picked up the switch, so that
+	// the actual traffic reflects it.
+	if len(config.Traffic) > 0 {
+		err = trafficSwitcher.WaitForObservedGeneration(ctx, config.Stackset, config.Namespace, config.Timeout)
+		if err != nil {
+			return err
+		}
+		stacks, err = trafficSwitcher.TrafficWeights(ctx, config.Stackset, config.Namespace)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = printTraffic(stacks)
+	if err != nil {
+		return err
+	}
+
+	if config.Watch {
+		err := watchTraffic(ctx, trafficSwitcher)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Wait {
+		return trafficSwitcher.WaitForTraffic(ctx, config.Stackset, config.Namespace, config.Timeout)
+	}
+	return nil
+}
+
+// updateTraffic switches the traffic of the stackset as specified by the
+// arguments, either a stack and its traffic weight or the traffic weights of
+// all stacks as <stack>=<weight>, and returns the traffic of its stacks.
+// Without any arguments the traffic is returned unchanged.
+func updateTraffic(ctx context.Context, trafficSwitcher *traffic.Switcher, args []string) ([]traffic.StackTrafficWeight, error) {
+	switch {
+	case len(args) == 0:
+		return trafficSwitcher.TrafficWeights(ctx, config.Stackset, config.Namespace)
+	case strings.Contains(args[0], "="):
+		weights, err := parseWeights(args)
+		if err != nil {
+			return nil, err
+		}
+		return trafficSwitcher.SwitchWeights(ctx, config.Stackset, config.Namespace, weights)
+	case len(args) == 2:
+		weight, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || weight < 0 || weight > 100 {
+			return nil, errors.New("traffic weight must be between 0 and 100")
+		}
+		return trafficSwitcher.Switch(ctx, config.Stackset, args[0], config.Namespace, weight)
+	default:
+		return nil, errors.New("expected either a stack and its traffic weight or <stack>=<weight> pairs")
+	}
+}
+
+// rollback switches all traffic of the stackset back to the stack which most
+// recently had traffic.
+func rollback(ctx context.Context, trafficSwitcher *traffic.Switcher) error {
+	stack, err := trafficSwitcher.Rollback(ctx, config.Stackset, config.Namespace, config.Timeout)
+	if err != nil {
+		return err
+	}
+	log.Infof("Switched traffic of stackset %s back to stack %s.", config.Stackset, stack)
+	return nil
+}
+
+// parseWeights parses traffic weights specified as <stack>=<weight>.
+func parseWeights(args []string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(args))
+	for _, arg := range args {
+		stack, value, ok := strings.Cut(arg, "=")
+		if !ok || stack == "" {
+			return nil, fmt.Errorf("invalid traffic weight %q, expected <stack>=<weight>", arg)
+		}
+		if _, ok := weights[stack]; ok {
+			return nil, fmt.Errorf("duplicate traffic weight for stack %s", stack)
+		}
+
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid traffic weight %q: %v", arg, err)
+		}
+		weights[stack] = weight
+	}
+	return weights, nil
+}
+
+// watchTraffic prints the traffic of the stackset whenever it changes, until
+// the actual traffic matches the desired traffic or the timeout expires.
+func watchTraffic(ctx context.Context, trafficSwitcher *traffic.Switcher) error {
+	var last []traffic.StackTrafficWeight
+	err := wait.PollUntilContextTimeout(ctx, defaultWatchInterval, config.Timeout, true, func(ctx context.Context) (bool, error) {
+		stacks, err := trafficSwitcher.TrafficWeights(ctx, config.Stackset, config.Namespace)
+		if err != nil {
+			return false, err
+		}
+
+		if last != nil && !reflect.DeepEqual(stacks, last) {
+			err := printTraffic(stacks)
+			if err != nil {
+				return false, err
+			}
+		}
+		last = stacks
+
+		return traffic.Converged(stacks), nil
+	})
+	if err != nil && wait.Interrupted(err) && !errors.Is(ctx.Err(), context.Canceled) {
+		if notConverged := traffic.NotConverged(last); len(notConverged) > 0 {
+			return &traffic.StacksNotReadyError{Stacks: notConverged}
+		}
+	}
+	return err
+}
+
+// printTraffic prints the traffic of the stacks in the configured output
+// format.
+func printTraffic(stacks []traffic.StackTrafficWeight) error {
+	if config.Output != outputTable {
+		return printObject(stacks)
+	}
+
+	w := tabwriter.NewWriter(out, 8, 8, 4, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\n", "STACK", "DESIRED TRAFFIC", "ACTUAL TRAFFIC")
+
+	for _, stack := range stacks {
+		fmt.Fprintf(w,
+			"%s\t%s\t%s\n",
+			stack.Name,
+			fmt.Sprintf("%.1f%%", stack.Weight),
+			fmt.Sprintf("%.1f%%", stack.ActualWeight),
+		)
+	}
+
+	return w.Flush()
+}
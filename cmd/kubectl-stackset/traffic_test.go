@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	rginterface "github.com/szuecs/routegroup-client/client/clientset/versioned"
+	rgfake "github.com/szuecs/routegroup-client/client/clientset/versioned/fake"
+	rgi "github.com/szuecs/routegroup-client/client/clientset/versioned/typed/zalando.org/v1"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	ssfake "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned/fake"
+	zi "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned/typed/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testClient struct {
+	kubernetes.Interface
+	ssClient *ssfake.Clientset
+	rgClient rginterface.Interface
+}
+
+func (c *testClient) ZalandoV1() zi.ZalandoV1Interface {
+	return c.ssClient.ZalandoV1()
+}
+
+func (c *testClient) RouteGroupV1() rgi.ZalandoV1Interface {
+	return c.rgClient.ZalandoV1()
+}
+
+func newTestClient(objects ...runtime.Object) *testClient {
+	return &testClient{
+		Interface: fake.NewSimpleClientset(),
+		ssClient:  ssfake.NewSimpleClientset(objects...),
+		rgClient:  rgfake.NewSimpleClientset(),
+	}
+}
+
+// testStackSet returns the stackset foo with the desired and actual traffic
+// weights of its stacks foo-v1 and foo-v2.
+func testStackSet(desired, actual float64) []runtime.Object {
+	stackset := &zv1.StackSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+		},
+		Spec: zv1.StackSetSpec{
+			Traffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 100 - desired},
+				{StackName: "foo-v2", Weight: desired},
+			},
+		},
+		Status: zv1.StackSetStatus{
+			Traffic: []*zv1.ActualTraffic{
+				{StackName: "foo-v1", Weight: 100 - actual},
+				{StackName: "foo-v2", Weight: actual},
+			},
+		},
+	}
+
+	objects := []runtime.Object{stackset}
+	for _, name := range []string{"foo-v1", "foo-v2"} {
+		objects = append(objects, &zv1.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{"stackset": "foo"},
+			},
+		})
+	}
+	return objects
+}
+
+// setTestConfig sets the configuration of the plugin and captures its output
+// for the duration of the test.
+func setTestConfig(t *testing.T, output string, timeout time.Duration) *bytes.Buffer {
+	previous, previousOut := config, out
+	t.Cleanup(func() {
+		config, out = previous, previousOut
+	})
+
+	var buf bytes.Buffer
+	out = &buf
+	config.Stackset = "foo"
+	config.Namespace = "default"
+	config.Output = output
+	config.Timeout = timeout
+	return &buf
+}
+
+func TestPrintTraffic(t *testing.T) {
+	noTrafficSince := metav1.NewTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	stacks := []traffic.StackTrafficWeight{
+		{
+			Name:            "foo-v1",
+			Weight:          0,
+			ActualWeight:    25,
+			Replicas:        3,
+			ReadyReplicas:   3,
+			UpdatedReplicas: 3,
+			DesiredReplicas: 3,
+			NoTrafficSince:  &noTrafficSince,
+			Pinned:          true,
+		},
+		{
+			Name:            "foo-v2",
+			Weight:          100,
+			ActualWeight:    75,
+			Replicas:        4,
+			ReadyReplicas:   2,
+			UpdatedReplicas: 4,
+			DesiredReplicas: 4,
+			Prescaling:      zv1.PrescalingStatus{Active: true, Replicas: 4, DesiredTrafficWeight: 100},
+		},
+	}
+
+	for _, tc := range []struct {
+		output   string
+		expected string
+	}{
+		{
+			output: outputTable,
+			expected: `STACK     DESIRED TRAFFIC    ACTUAL TRAFFIC
+foo-v1    0.0%               25.0%
+foo-v2    100.0%             75.0%
+`,
+		},
+		{
+			output: outputJSON,
+			expected: `[{"name":"foo-v1","desiredWeight":0,"actualWeight":25,"replicas":3,"readyReplicas":3,"updatedReplicas":3,"desiredReplicas":3,"prescalingStatus":{"active":false},"noTrafficSince":"2024-05-01T12:00:00Z","pinned":true},` +
+				`{"name":"foo-v2","desiredWeight":100,"actualWeight":75,"replicas":4,"readyReplicas":2,"updatedReplicas":4,"desiredReplicas":4,"prescalingStatus":{"active":true,"replicas":4,"desiredTrafficWeight":100}}]
+`,
+		},
+		{
+			output: outputYAML,
+			expected: `---
+- actualWeight: 25
+  desiredReplicas: 3
+  desiredWeight: 0
+  name: foo-v1
+  noTrafficSince: "2024-05-01T12:00:00Z"
+  pinned: true
+  prescalingStatus:
+    active: false
+  readyReplicas: 3
+  replicas: 3
+  updatedReplicas: 3
+- actualWeight: 75
+  desiredReplicas: 4
+  desiredWeight: 100
+  name: foo-v2
+  prescalingStatus:
+    active: true
+    desiredTrafficWeight: 100
+    replicas: 4
+  readyReplicas: 2
+  replicas: 4
+  updatedReplicas: 4
+`,
+		},
+	} {
+		t.Run(tc.output, func(t *testing.T) {
+			buf := setTestConfig(t, tc.output, 0)
+			require.NoError(t, printTraffic(stacks))
+			require.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+func TestWatchTraffic(t *testing.T) {
+	t.Run("converged traffic", func(t *testing.T) {
+		buf := setTestConfig(t, outputTable, time.Minute)
+		switcher := traffic.NewSwitcher(newTestClient(testStackSet(100, 100)...))
+
+		require.NoError(t, watchTraffic(context.Background(), switcher))
+		require.Empty(t, buf.String())
+	})
+
+	t.Run("traffic not converging within the timeout", func(t *testing.T) {
+		setTestConfig(t, outputTable, 10*time.Millisecond)
+		switcher := traffic.NewSwitcher(newTestClient(testStackSet(100, 20)...))
+
+		err := watchTraffic(context.Background(), switcher)
+		var notReady *traffic.StacksNotReadyError
+		require.ErrorAs(t, err, &notReady)
+		require.Equal(t, []string{"foo-v1", "foo-v2"}, notReady.Stacks)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		setTestConfig(t, outputTable, time.Minute)
+		switcher := traffic.NewSwitcher(newTestClient(testStackSet(100, 20)...))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, watchTraffic(ctx, switcher), context.Canceled)
+	})
+}
+
+func TestParseWeights(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		args          []string
+		expected      map[string]float64
+		expectedError string
+	}{
+		{
+			name:     "stacks by name and version",
+			args:     []string{"foo-v1=20", "v2=30.5", "v3=49.5"},
+			expected: map[string]float64{"foo-v1": 20, "v2": 30.5, "v3": 49.5},
+		},
+		{
+			name:          "missing weight",
+			args:          []string{"foo-v1=50", "foo-v2"},
+			expectedError: `invalid traffic weight "foo-v2", expected <stack>=<weight>`,
+		},
+		{
+			name:          "missing stack",
+			args:          []string{"=100"},
+			expectedError: `invalid traffic weight "=100", expected <stack>=<weight>`,
+		},
+		{
+			name:          "duplicate stack",
+			args:          []string{"foo-v1=50", "foo-v1=50"},
+			expectedError: "duplicate traffic weight for stack foo-v1",
+		},
+		{
+			name:          "invalid weight",
+			args:          []string{"foo-v1=half"},
+			expectedError: `invalid traffic weight "foo-v1=half"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			weights, err := parseWeights(tc.args)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, weights)
+		})
+	}
+}
+
+func TestUpdateTraffic(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		args          []string
+		expected      map[string]float64
+		expectedError string
+	}{
+		{
+			name:     "no arguments show the traffic",
+			expected: map[string]float64{"foo-v1": 100, "foo-v2": 0},
+		},
+		{
+			name:     "stack and its traffic weight",
+			args:     []string{"foo-v2", "30"},
+			expected: map[string]float64{"foo-v1": 70, "foo-v2": 30},
+		},
+		{
+			name:     "traffic weights of all stacks",
+			args:     []string{"v1=40", "foo-v2=60"},
+			expected: map[string]float64{"foo-v1": 40, "foo-v2": 60},
+		},
+		{
+			name:          "invalid traffic weight of a stack",
+			args:          []string{"foo-v2", "all"},
+			expectedError: "traffic weight must be between 0 and 100",
+		},
+		{
+			name:          "traffic weight of a stack out of range",
+			args:          []string{"foo-v2", "120"},
+			expectedError: "traffic weight must be between 0 and 100",
+		},
+		{
+			name:          "invalid traffic weights of all stacks",
+			args:          []string{"v1=40", "v2"},
+			expectedError: `invalid traffic weight "v2", expected <stack>=<weight>`,
+		},
+		{
+			name:          "too many arguments",
+			args:          []string{"foo-v1", "foo-v2", "50"},
+			expectedError: "expected either a stack and its traffic weight or <stack>=<weight> pairs",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setTestConfig(t, outputTable, 0)
+			switcher := traffic.NewSwitcher(newTestClient(testStackSet(0, 0)...))
+
+			stacks, err := updateTraffic(context.Background(), switcher, tc.args)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			weights := make(map[string]float64, len(stacks))
+			for _, stack := range stacks {
+				weights[stack.Name] = stack.Weight
+			}
+			require.Equal(t, tc.expected, weights)
+		})
+	}
+}
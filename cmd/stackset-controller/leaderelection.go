@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/heptiolabs/healthcheck"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leasePrefix = "stackset-controller"
+
+	// maxTolerableExpiredLease is how long the leader may fail to renew its
+	// lease past the lease duration before it's considered unhealthy.
+	maxTolerableExpiredLease = 10 * time.Second
+)
+
+// leaderElectionConfig configures the Lease based leader election.
+type leaderElectionConfig struct {
+	Namespace     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// leaseName returns the name of the Leases used by the controllers with the
+// controller ID.
+func leaseName(controllerID string) string {
+	if controllerID == "" {
+		return leasePrefix
+	}
+	return leasePrefix + "-" + controllerID
+}
+
+// runWithLeaderElection runs the controller only while this replica holds
+// the Lease of the controller ID. The replica is only ready while it's the
+// leader. When the context is cancelled, e.g. on SIGTERM, the controller is
+// stopped first and the Lease is released afterwards, so that another replica
+// takes over right away instead of waiting for the Lease to expire.
+func runWithLeaderElection(ctx context.Context, client kubernetes.Interface, config leaderElectionConfig, controllerID string, health healthcheck.Handler, run func(ctx context.Context) error) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the leader election identity: %w", err)
+	}
+
+	name := leaseName(controllerID)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: config.Namespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	// The elector isn't stopped by the context directly, otherwise it
+	// would release the Lease while the controller is still running.
+	electorCtx, stopElector := context.WithCancel(context.Background())
+	defer stopElector()
+
+	done := make(chan error, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            name,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				defer stopElector()
+
+				log.Infof("Acquired Lease %s/%s, starting the controller.", config.Namespace, name)
+				runCtx, cancel := context.WithCancel(leaderCtx)
+				defer cancel()
+				stop := context.AfterFunc(ctx, cancel)
+				defer stop()
+
+				done <- run(runCtx)
+			},
+			OnStoppedLeading: func() {
+				log.Infof("Stopped leading Lease %s/%s.", config.Namespace, name)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Infof("Current leader is %s.", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up leader election: %w", err)
+	}
+
+	health.AddReadinessCheck("leader", func() error {
+		if !elector.IsLeader() {
+			return errors.New("not the leader")
+		}
+		return nil
+	})
+	health.AddLivenessCheck("leaderElection", func() error {
+		return elector.Check(maxTolerableExpiredLease)
+	})
+
+	// Stop waiting for the Lease when terminating before becoming the
+	// leader, otherwise the controller is stopped first.
+	stop := context.AfterFunc(ctx, func() {
+		if !elector.IsLeader() {
+			stopElector()
+		}
+	})
+	defer stop()
+
+	elector.Run(electorCtx)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	default:
+	}
+
+	if ctx.Err() == nil {
+		return fmt.Errorf("lost Lease %s/%s", config.Namespace, name)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heptiolabs/healthcheck"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testLeaderElectionConfig = leaderElectionConfig{
+	Namespace:     "kube-system",
+	LeaseDuration: 600 * time.Millisecond,
+	RenewDeadline: 400 * time.Millisecond,
+	RetryPeriod:   50 * time.Millisecond,
+}
+
+func TestLeaseName(t *testing.T) {
+	for _, tc := range []struct {
+		controllerID string
+		expected     string
+	}{
+		{
+			expected: "stackset-controller",
+		},
+		{
+			controllerID: "canary",
+			expected:     "stackset-controller-canary",
+		},
+	} {
+		t.Run(tc.expected, func(t *testing.T) {
+			require.Equal(t, tc.expected, leaseName(tc.controllerID))
+		})
+	}
+}
+
+// ready returns true if the readiness endpoint of the health handler reports
+// the replica as ready.
+func ready(health healthcheck.Handler) bool {
+	recorder := httptest.NewRecorder()
+	health.ReadyEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	return recorder.Code == http.StatusOK
+}
+
+// runTestController runs the controller with leader election in the
+// background. The returned channels receive the context of the controller
+// once it's started and the result of runWithLeaderElection.
+func runTestController(ctx context.Context, client *fake.Clientset, health healthcheck.Handler) (<-chan context.Context, <-chan error) {
+	started := make(chan context.Context, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- runWithLeaderElection(ctx, client, testLeaderElectionConfig, "", health, func(ctx context.Context) error {
+			started <- ctx
+			<-ctx.Done()
+			return nil
+		})
+	}()
+	return started, result
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	t.Run("lease is released when terminating", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := fake.NewSimpleClientset()
+		health := healthcheck.NewHandler()
+		started, result := runTestController(ctx, client, health)
+
+		var runCtx context.Context
+		select {
+		case runCtx = <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("controller wasn't started")
+		}
+		require.True(t, ready(health))
+
+		cancel()
+		require.NoError(t, <-result)
+		require.Error(t, runCtx.Err())
+
+		lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), "stackset-controller", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, *lease.Spec.HolderIdentity)
+	})
+
+	t.Run("losing the lease stops the controller", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := fake.NewSimpleClientset()
+		health := healthcheck.NewHandler()
+		started, result := runTestController(ctx, client, health)
+
+		var runCtx context.Context
+		select {
+		case runCtx = <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("controller wasn't started")
+		}
+		require.True(t, ready(health))
+
+		// Another replica takes over the lease
+		lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), "stackset-controller", metav1.GetOptions{})
+		require.NoError(t, err)
+		lease.Spec = coordinationv1.LeaseSpec{
+			HolderIdentity:       stringPtr("other"),
+			LeaseDurationSeconds: int32Ptr(60),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		}
+		_, err = client.CoordinationV1().Leases("kube-system").Update(context.Background(), lease, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		select {
+		case err = <-result:
+		case <-time.After(5 * time.Second):
+			t.Fatal("controller wasn't stopped")
+		}
+		require.EqualError(t, err, "lost Lease kube-system/stackset-controller")
+		require.Error(t, runCtx.Err())
+		require.False(t, ready(health))
+	})
+
+	t.Run("terminating before becoming the leader", func(t *testing.T) {
+		client := fake.NewSimpleClientset(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "stackset-controller",
+				Namespace: "kube-system",
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       stringPtr("other"),
+				LeaseDurationSeconds: int32Ptr(60),
+				AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+				RenewTime:            &metav1.MicroTime{Time: time.Now()},
+			},
+		})
+		health := healthcheck.NewHandler()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		started, result := runTestController(ctx, client, health)
+
+		select {
+		case err := <-result:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("leader election wasn't stopped")
+		}
+		require.Empty(t, started)
+		require.False(t, ready(health))
+	})
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
@@ -15,8 +15,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/zalando-incubator/stackset-controller/controller"
+	"github.com/zalando-incubator/stackset-controller/pkg/analysis"
 	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
 	"github.com/zalando-incubator/stackset-controller/pkg/traffic"
+	"github.com/zalando-incubator/stackset-controller/pkg/webhook"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
@@ -28,6 +30,12 @@ const (
 	defaultMetricsAddress         = ":7979"
 	defaultClientGOTimeout        = 30 * time.Second
 	defaultReconcileWorkers       = "10"
+	defaultAnalysisTimeout        = "10s"
+	defaultLeaderElectionNS       = "kube-system"
+	defaultLeaseDuration          = "15s"
+	defaultRenewDeadline          = "10s"
+	defaultRetryPeriod            = "2s"
+	defaultShardRenewInterval     = "5s"
 )
 
 var (
@@ -47,12 +55,22 @@ var (
 		ConfigMapSupportEnabled     bool
 		SecretSupportEnabled        bool
 		PCSSupportEnabled           bool
+		AnalysisPrometheusURL       *url.URL
+		AnalysisTimeout             time.Duration
+		WebhookAddress              string
+		WebhookTLSCertFile          string
+		WebhookTLSKeyFile           string
+		LeaderElectionEnabled       bool
+		LeaderElection              leaderElectionConfig
+		ShardingEnabled             bool
+		Sharding                    controller.ShardingConfig
+		DryRun                      bool
 	}
 )
 
 func main() {
 	kingpin.Flag("debug", "Enable debug logging.").BoolVar(&config.Debug)
-	kingpin.Flag("interval", "Interval between syncing stacksets.").
+	kingpin.Flag("interval", "Interval between periodic resyncs of all stacksets. Stacksets are also reconciled whenever they or their resources change.").
 		Default(defaultInterval).DurationVar(&config.Interval)
 	kingpin.Flag("apiserver", "API server url.").URLVar(&config.APIServer)
 	kingpin.Flag("namespace", "Limit scope to a particular namespace.").Default(corev1.NamespaceAll).StringVar(&config.Namespace)
@@ -70,6 +88,21 @@ func main() {
 	kingpin.Flag("enable-configmap-support", "Enable support for ConfigMaps on StackSets.").Default("false").BoolVar(&config.ConfigMapSupportEnabled)
 	kingpin.Flag("enable-secret-support", "Enable support for Secrets on StackSets.").Default("false").BoolVar(&config.SecretSupportEnabled)
 	kingpin.Flag("enable-pcs-support", "Enable support for PlatformCredentialsSet on StackSets.").Default("false").BoolVar(&config.PCSSupportEnabled)
+	kingpin.Flag("analysis-prometheus-url", "URL of the Prometheus compatible API used to analyse the metrics of StackSets. Analysis is disabled if not set.").URLVar(&config.AnalysisPrometheusURL)
+	kingpin.Flag("analysis-timeout", "Timeout for analysis queries.").Default(defaultAnalysisTimeout).DurationVar(&config.AnalysisTimeout)
+	kingpin.Flag("webhook-address", "Address to serve the admission webhook on. The webhook is disabled if not set.").StringVar(&config.WebhookAddress)
+	kingpin.Flag("webhook-tls-cert-file", "TLS certificate file of the admission webhook.").StringVar(&config.WebhookTLSCertFile)
+	kingpin.Flag("webhook-tls-key-file", "TLS private key file of the admission webhook.").StringVar(&config.WebhookTLSKeyFile)
+	kingpin.Flag("enable-leader-election", "Enable Lease based leader election, so that multiple replicas of the controller can be run. Only the leader reconciles StackSets.").Default("false").BoolVar(&config.LeaderElectionEnabled)
+	kingpin.Flag("leader-election-namespace", "Namespace of the Lease used for leader election.").Default(defaultLeaderElectionNS).StringVar(&config.LeaderElection.Namespace)
+	kingpin.Flag("leader-election-lease-duration", "Duration non-leader replicas wait before trying to acquire an unrenewed Lease.").Default(defaultLeaseDuration).DurationVar(&config.LeaderElection.LeaseDuration)
+	kingpin.Flag("leader-election-renew-deadline", "Duration the leader retries renewing the Lease before giving up leadership.").Default(defaultRenewDeadline).DurationVar(&config.LeaderElection.RenewDeadline)
+	kingpin.Flag("leader-election-retry-period", "Duration between attempts to acquire or renew the Lease.").Default(defaultRetryPeriod).DurationVar(&config.LeaderElection.RetryPeriod)
+	kingpin.Flag("enable-sharding", "Enable splitting the StackSets between all replicas of the controller. Replicas announce themselves with Leases.").Default("false").BoolVar(&config.ShardingEnabled)
+	kingpin.Flag("sharding-namespace", "Namespace of the Leases used for sharding.").Default(defaultLeaderElectionNS).StringVar(&config.Sharding.Namespace)
+	kingpin.Flag("sharding-lease-duration", "Duration after which a replica which didn't renew its Lease is considered gone. StackSets are handed over to another replica after the same duration.").Default(defaultLeaseDuration).DurationVar(&config.Sharding.LeaseDuration)
+	kingpin.Flag("sharding-renew-interval", "Interval between renewing the Lease and discovering the other replicas.").Default(defaultShardRenewInterval).DurationVar(&config.Sharding.RenewInterval)
+	kingpin.Flag("dry-run", "Only log the changes the controller would make to StackSets and their resources instead of applying them.").Default("false").BoolVar(&config.DryRun)
 	kingpin.Parse()
 
 	if config.Debug {
@@ -91,6 +124,37 @@ func main() {
 		ConfigMapSupportEnabled:  config.ConfigMapSupportEnabled,
 		SecretSupportEnabled:     config.SecretSupportEnabled,
 		PcsSupportEnabled:        config.PCSSupportEnabled,
+
+		DryRun: config.DryRun,
+	}
+
+	// A replica in dry-run mode runs next to the controller applying the
+	// changes, so it must not take over its Lease or its StackSets.
+	if config.DryRun && (config.LeaderElectionEnabled || config.ShardingEnabled) {
+		log.Fatal("Dry-run can't be enabled together with leader election or sharding")
+	}
+
+	if config.ShardingEnabled {
+		if config.LeaderElectionEnabled {
+			log.Fatal("Leader election and sharding can't be enabled at the same time")
+		}
+
+		if config.Sharding.RenewInterval >= config.Sharding.LeaseDuration {
+			log.Fatal("The sharding renew interval must be shorter than the lease duration")
+		}
+
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Failed to get the sharding identity: %v", err)
+		}
+
+		config.Sharding.Group = leaseName(config.ControllerID)
+		config.Sharding.Identity = identity
+		stackSetConfig.Sharding = &config.Sharding
+	}
+
+	if config.AnalysisPrometheusURL != nil {
+		stackSetConfig.AnalysisProvider = analysis.NewPrometheusProvider(config.AnalysisPrometheusURL, config.AnalysisTimeout)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -114,8 +178,18 @@ func main() {
 	}
 
 	go handleSigterm(cancel)
+	http.HandleFunc("/healthz", controller.HealthReporter.LiveEndpoint)
+	http.HandleFunc("/readyz", controller.HealthReporter.ReadyEndpoint)
 	go serveMetrics(config.MetricsAddress)
-	err = controller.Run(ctx)
+	if config.WebhookAddress != "" {
+		go serveWebhook(config.WebhookAddress, config.WebhookTLSCertFile, config.WebhookTLSKeyFile, webhook.New(client))
+	}
+
+	if config.LeaderElectionEnabled {
+		err = runWithLeaderElection(ctx, client, config.LeaderElection, config.ControllerID, controller.HealthReporter, controller.Run)
+	} else {
+		err = controller.Run(ctx)
+	}
 	if err != nil {
 		cancel()
 		log.Fatalf("Failed to run controller: %v", err)
@@ -200,3 +274,13 @@ func serveMetrics(address string) {
 	http.Handle("/metrics", promhttp.Handler())
 	log.Fatal(http.ListenAndServe(address, nil))
 }
+
+// serveWebhook serves the admission webhook via HTTPS.
+func serveWebhook(address, certFile, keyFile string, admission *webhook.Webhook) {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           admission.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Fatal(server.ListenAndServeTLS(certFile, keyFile))
+}
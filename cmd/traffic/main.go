@@ -1,3 +1,9 @@
+// Command traffic shows and switches the traffic of a stackset.
+//
+// Deprecated: use the kubectl-stackset plugin instead, e.g.
+// `kubectl stackset traffic <stackset> <stack> <traffic>`. This command is
+// kept for compatibility with existing scripts and will be removed in a
+// future release.
 package main
 
 import (
@@ -33,9 +39,11 @@ func main() {
 	kingpin.Arg("stack", "help").StringVar(&config.Stack)
 	kingpin.Arg("traffic", "help").Default("-1").Float64Var(&config.Traffic)
 	kingpin.Flag("namespace", "Namespace of the stackset resource.").Default(defaultNamespace).StringVar(&config.Namespace)
-	kingpin.Flag("backend-weights-key", "Backend weights annotation key the controller will use to set current traffic values").Default(traffic.DefaultBackendWeightsAnnotationKey).StringVar(&config.BackendWeightsAnnotationKey)
+	kingpin.Flag("backend-weights-key", "Deprecated, the traffic is read from the stackset resource.").Hidden().StringVar(&config.BackendWeightsAnnotationKey)
 	kingpin.Parse()
 
+	log.Warn("The traffic command is deprecated and will be removed in a future release, use the kubectl-stackset plugin instead.")
+
 	kubeconfig, err := newKubeConfig()
 	if err != nil {
 		log.Fatalf("Failed to setup Kubernetes client: %v.", err)
@@ -46,7 +54,7 @@ func main() {
 		log.Fatalf("Failed to initialize Kubernetes client: %v.", err)
 	}
 
-	trafficSwitcher := traffic.NewSwitcher(client, config.BackendWeightsAnnotationKey)
+	trafficSwitcher := traffic.NewSwitcher(client)
 
 	ctx := context.Background()
 
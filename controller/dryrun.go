@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	verbCreate       = "create"
+	verbUpdate       = "update"
+	verbUpdateStatus = "update status of"
+	verbDelete       = "delete"
+)
+
+// dryRunPlan remembers the last change planned for every resource in dry-run
+// mode, so that a change is only logged again once it differs from the one
+// planned by the previous reconciliation.
+type dryRunPlan struct {
+	changes map[string]string
+	sync.Mutex
+}
+
+// planChange reports whether a change to a resource must be skipped because
+// the controller runs in dry-run mode. Instead of being applied, the change
+// is logged as a diff between the live and the updated resource, or as the
+// full manifest of a resource being created. existing is nil for resources
+// being created and updated is nil for deleted ones.
+func (c *StackSetController) planChange(verb, kind string, existing, updated metav1.Object) bool {
+	if !c.config.DryRun {
+		return false
+	}
+
+	object := updated
+	if object == nil {
+		object = existing
+	}
+
+	var diff string
+	switch {
+	case existing == nil:
+		manifest, err := yaml.Marshal(updated)
+		if err != nil {
+			diff = err.Error()
+		} else {
+			diff = string(manifest)
+		}
+	case updated != nil:
+		diff = cmp.Diff(existing, updated, cmpopts.IgnoreUnexported(resource.Quantity{}))
+	}
+
+	key := verb + " " + kind + " " + object.GetNamespace() + "/" + object.GetName()
+
+	c.plan.Lock()
+	defer c.plan.Unlock()
+
+	if planned, ok := c.plan.changes[key]; ok && planned == diff {
+		return true
+	}
+	c.plan.changes[key] = diff
+
+	message := fmt.Sprintf("Dry-run: would %s %s %s/%s", verb, kind, object.GetNamespace(), object.GetName())
+	if diff != "" {
+		message += ":\n" + diff
+	}
+	c.logger.Info(message)
+	return true
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPlanChange(t *testing.T) {
+	env := NewTestEnvironment()
+	c := env.controller
+
+	deployment := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "foo-v1", Namespace: "default"}}
+	require.False(t, c.planChange(verbCreate, "Deployment", nil, deployment))
+	require.Empty(t, c.plan.changes)
+
+	c.config.DryRun = true
+	require.True(t, c.planChange(verbCreate, "Deployment", nil, deployment))
+	require.Contains(t, c.plan.changes, "create Deployment default/foo-v1")
+
+	replicas := int32(3)
+	updated := deployment.DeepCopy()
+	updated.Spec.Replicas = &replicas
+	require.True(t, c.planChange(verbUpdate, "Deployment", deployment, updated))
+	require.Contains(t, c.plan.changes["update Deployment default/foo-v1"], "Replicas")
+
+	require.True(t, c.planChange(verbDelete, "Deployment", deployment, nil))
+	require.Empty(t, c.plan.changes["delete Deployment default/foo-v1"])
+}
+
+func TestReconcileStackSetDryRun(t *testing.T) {
+	env := NewTestEnvironment()
+	env.controller.config.DryRun = true
+
+	replicas := int32(1)
+	stackset := testStackset("foo", "default", "123")
+	stackset.Spec.StackTemplate.Spec = zv1.StackSpecTemplate{
+		Version: "v1",
+		StackSpec: zv1.StackSpec{
+			Replicas: &replicas,
+			PodTemplate: zv1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Name:  "foo",
+							Image: "ghcr.io/zalando/skipper:latest",
+						},
+					},
+				},
+			},
+		},
+	}
+	oldStack := testStack("foo-v0", stackset.Namespace, "abc", stackset)
+
+	err := env.CreateStacksets(context.Background(), []zv1.StackSet{stackset})
+	require.NoError(t, err)
+	err = env.CreateStacks(context.Background(), []zv1.Stack{oldStack})
+	require.NoError(t, err)
+
+	container := &core.StackSetContainer{
+		StackSet: &stackset,
+		StackContainers: map[types.UID]*core.StackContainer{
+			oldStack.UID: {
+				Stack:          &oldStack,
+				PendingRemoval: true,
+			},
+		},
+		TrafficReconciler: &core.SimpleTrafficReconciler{},
+	}
+
+	err = env.controller.ReconcileStackSet(context.Background(), container)
+	require.NoError(t, err)
+
+	// Nothing is changed in the cluster
+	stacks, err := env.client.ZalandoV1().Stacks(stackset.Namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []zv1.Stack{oldStack}, stacks.Items)
+
+	deployments, err := env.client.AppsV1().Deployments(stackset.Namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, deployments.Items)
+
+	live, err := env.client.ZalandoV1().StackSets(stackset.Namespace).Get(context.Background(), stackset.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, stackset.Status, live.Status)
+
+	// The changes are planned instead, including the resources of the new stack
+	for _, change := range []string{
+		"create Stack default/foo-v1",
+		"update status of StackSet default/foo",
+		"create Deployment default/foo-v1",
+		"create Service default/foo-v1",
+		"delete Stack default/foo-v0",
+	} {
+		require.Contains(t, env.controller.plan.changes, change)
+	}
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// heritageMigration lists and patches the resources of a kind managed for
+// the stacksets.
+type heritageMigration struct {
+	kind string
+	// owner is set for kinds owning other resources, e.g. stacks, whose
+	// resources must be listed even if they already have the label.
+	owner bool
+	list  func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error)
+	patch func(ctx context.Context, namespace, name string, data []byte) error
+}
+
+// migrateHeritageLabels adds the heritage label to the resources owned by the
+// stacksets or their stacks which don't have it yet, e.g. because they were
+// created before the label was set by the controller. The informers only
+// watch the resources with the label, so the controller wouldn't find them
+// otherwise and would fail to create them again. It must be called after the
+// StackSet watcher synced and before the informers are started.
+func (c *StackSetController) migrateHeritageLabels(ctx context.Context) error {
+	// owners maps the UIDs of the stacksets and the resources owning other
+	// resources to the name of the stackset they belong to.
+	owners := make(map[types.UID]string)
+	for _, obj := range c.stacksetCache.List() {
+		if stackset, ok := obj.(*zv1.StackSet); ok {
+			owners[stackset.UID] = stackset.Name
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	migrations := []heritageMigration{
+		{
+			kind:  "Stack",
+			owner: true,
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.ZalandoV1().Stacks(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.ZalandoV1().Stacks(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		},
+		{
+			kind:  "Deployment",
+			owner: true,
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.AppsV1().Deployments(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		},
+		{
+			kind: "Service",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.CoreV1().Services(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.CoreV1().Services(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		},
+		{
+			kind: "HorizontalPodAutoscaler",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.AutoscalingV2().HorizontalPodAutoscalers(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.AutoscalingV2().HorizontalPodAutoscalers(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		},
+		{
+			kind: "Ingress",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.NetworkingV1().Ingresses(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.NetworkingV1().Ingresses(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		},
+	}
+
+	if c.config.RouteGroupSupportEnabled {
+		migrations = append(migrations, heritageMigration{
+			kind: "RouteGroup",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.RouteGroupV1().RouteGroups(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.RouteGroupV1().RouteGroups(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	if c.config.ConfigMapSupportEnabled {
+		migrations = append(migrations, heritageMigration{
+			kind: "ConfigMap",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.CoreV1().ConfigMaps(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.CoreV1().ConfigMaps(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	if c.config.SecretSupportEnabled {
+		migrations = append(migrations, heritageMigration{
+			kind: "Secret",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.CoreV1().Secrets(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.CoreV1().Secrets(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	if c.config.PcsSupportEnabled {
+		migrations = append(migrations, heritageMigration{
+			kind: "PlatformCredentialsSet",
+			list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				return c.client.ZalandoV1().PlatformCredentialsSets(c.config.Namespace).List(ctx, options)
+			},
+			patch: func(ctx context.Context, namespace, name string, data []byte) error {
+				_, err := c.client.ZalandoV1().PlatformCredentialsSets(namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	migrated := 0
+	for _, migration := range migrations {
+		n, err := c.migrateHeritageLabel(ctx, migration, owners)
+		if err != nil {
+			return err
+		}
+		migrated += n
+	}
+
+	if migrated > 0 {
+		c.logger.Infof("Added the heritage label to %d resources", migrated)
+	}
+	return nil
+}
+
+// migrateHeritageLabel adds the heritage label to the resources of a kind
+// which belong to a stackset, and returns how many were patched.
+func (c *StackSetController) migrateHeritageLabel(ctx context.Context, migration heritageMigration, owners map[types.UID]string) (int, error) {
+	options := metav1.ListOptions{}
+	if !migration.owner {
+		options.LabelSelector = "!" + core.StacksetHeritageLabelKey
+	}
+
+	list, err := migration.list(ctx, options)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %ss: %w", migration.kind, err)
+	}
+
+	objects, err := meta.ExtractList(list)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %ss: %w", migration.kind, err)
+	}
+
+	migrated := 0
+	for _, obj := range objects {
+		object, err := meta.Accessor(obj)
+		if err != nil {
+			return migrated, err
+		}
+
+		stackset, ok := heritageOwner(object, owners)
+		if !ok {
+			continue
+		}
+		if migration.owner {
+			owners[object.GetUID()] = stackset
+		}
+
+		if _, ok := object.GetLabels()[core.StacksetHeritageLabelKey]; ok {
+			continue
+		}
+
+		existing := &metav1.ObjectMeta{
+			Namespace: object.GetNamespace(),
+			Name:      object.GetName(),
+			Labels:    object.GetLabels(),
+		}
+		updated := existing.DeepCopy()
+		if updated.Labels == nil {
+			updated.Labels = make(map[string]string)
+		}
+		updated.Labels[core.StacksetHeritageLabelKey] = stackset
+
+		if c.planChange(verbUpdate, migration.kind, existing, updated) {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]string{core.StacksetHeritageLabelKey: stackset},
+			},
+		})
+		if err != nil {
+			return migrated, err
+		}
+
+		err = migration.patch(ctx, object.GetNamespace(), object.GetName(), patch)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to add the heritage label to %s %s/%s: %w", migration.kind, object.GetNamespace(), object.GetName(), err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// heritageOwner returns the name of the stackset owning the resource, directly
+// or through one of its owners.
+func heritageOwner(object metav1.Object, owners map[types.UID]string) (string, bool) {
+	for _, ownerRef := range object.GetOwnerReferences() {
+		if stackset, ok := owners[ownerRef.UID]; ok {
+			return stackset, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// unlabeled returns the metadata without any labels.
+func unlabeled(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	meta.Labels = nil
+	return meta
+}
+
+func TestMigrateHeritageLabels(t *testing.T) {
+	stackset := testStackset("foo", "default", "123")
+	stack := testStack("foo-v1", "default", "abc1", stackset)
+	stack.Labels = nil
+	deployment := apps.Deployment{ObjectMeta: unlabeled(stackOwned(stack))}
+	deployment.UID = "def1"
+	other := testStack("bar-v1", "default", "abc2", testStackset("bar", "default", "456"))
+	other.Labels = nil
+
+	for _, tc := range []struct {
+		name   string
+		dryRun bool
+	}{
+		{
+			name: "resources are labeled",
+		},
+		{
+			name:   "resources aren't labeled in dry-run mode",
+			dryRun: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := NewTestEnvironment()
+			env.controller.config.DryRun = tc.dryRun
+			env.controller.stacksetCache = cache.NewStore(cache.MetaNamespaceKeyFunc)
+			require.NoError(t, env.controller.stacksetCache.Add(&stackset))
+
+			require.NoError(t, env.CreateStacksets(ctx, []zv1.StackSet{stackset}))
+			require.NoError(t, env.CreateStacks(ctx, []zv1.Stack{stack, other}))
+			require.NoError(t, env.CreateDeployments(ctx, []apps.Deployment{deployment}))
+			require.NoError(t, env.CreateServices(ctx, []v1.Service{
+				{ObjectMeta: unlabeled(deploymentOwned(deployment))},
+			}))
+			require.NoError(t, env.CreateIngresses(ctx, []networking.Ingress{
+				{ObjectMeta: unlabeled(stackOwned(stack))},
+				{ObjectMeta: unlabeled(stacksetOwned(stackset))},
+				{ObjectMeta: unlabeled(stackOwned(other))},
+			}))
+
+			err := env.controller.migrateHeritageLabels(ctx)
+			require.NoError(t, err)
+
+			expected := map[string]string{core.StacksetHeritageLabelKey: stackset.Name}
+			if tc.dryRun {
+				expected = nil
+			}
+
+			migratedStack, err := env.client.ZalandoV1().Stacks("default").Get(ctx, stack.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, expected, migratedStack.Labels)
+
+			migratedDeployment, err := env.client.AppsV1().Deployments("default").Get(ctx, deployment.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, expected, migratedDeployment.Labels)
+
+			migratedService, err := env.client.CoreV1().Services("default").Get(ctx, deployment.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, expected, migratedService.Labels)
+
+			for _, name := range []string{stack.Name, stackset.Name} {
+				migratedIngress, err := env.client.NetworkingV1().Ingresses("default").Get(ctx, name, metav1.GetOptions{})
+				require.NoError(t, err)
+				require.Equal(t, expected, migratedIngress.Labels)
+			}
+
+			// The resources of unknown stacksets are left alone.
+			otherStack, err := env.client.ZalandoV1().Stacks("default").Get(ctx, other.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Nil(t, otherStack.Labels)
+
+			otherIngress, err := env.client.NetworkingV1().Ingresses("default").Get(ctx, other.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Nil(t, otherIngress.Labels)
+
+			if tc.dryRun {
+				return
+			}
+
+			// The migrated resources are collected from the informers.
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			err = env.controller.startInformers(runCtx)
+			require.NoError(t, err)
+
+			resources, err := env.controller.collectResources(env.controller.config.Namespace, env.controller.stacksetStore)
+			require.NoError(t, err)
+			container := resources[stackset.UID].StackContainers[stack.UID]
+			require.NotNil(t, container)
+			require.NotNil(t, container.Resources.Deployment)
+			require.NotNil(t, container.Resources.Service)
+			require.NotNil(t, container.Resources.Ingress)
+			require.NotNil(t, resources[stackset.UID].Ingress)
+		})
+	}
+}
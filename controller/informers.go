@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
+	rglisters "github.com/szuecs/routegroup-client/client/listers/zalando.org/v1"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	zinformers "github.com/zalando-incubator/stackset-controller/pkg/client/informers/externalversions"
+	zlisters "github.com/zalando-incubator/stackset-controller/pkg/client/listers/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v2"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resourceListers list the resources managed for the stacksets from the
+// caches of the informers.
+type resourceListers struct {
+	stacks                  zlisters.StackLister
+	deployments             appslisters.DeploymentLister
+	services                corelisters.ServiceLister
+	hpas                    autoscalinglisters.HorizontalPodAutoscalerLister
+	ingresses               networkinglisters.IngressLister
+	routeGroups             rglisters.RouteGroupLister
+	configMaps              corelisters.ConfigMapLister
+	secrets                 corelisters.SecretLister
+	platformCredentialsSets zlisters.PlatformCredentialsSetLister
+}
+
+// heritageLabelSelector only watches the resources with the stackset
+// heritage label, which is set on all the resources managed for stacksets.
+func heritageLabelSelector(options *metav1.ListOptions) {
+	options.LabelSelector = core.StacksetHeritageLabelKey
+}
+
+// startInformers starts watching the resources managed for the stacksets and
+// sets up the listers used to collect them. Any change to one of them queues
+// the stackset it belongs to for reconciliation.
+func (c *StackSetController) startInformers(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		c.client,
+		0, // skip resync
+		informers.WithNamespace(c.config.Namespace),
+		informers.WithTweakListOptions(heritageLabelSelector),
+	)
+	zFactory := zinformers.NewSharedInformerFactoryWithOptions(
+		c.client,
+		0, // skip resync
+		zinformers.WithNamespace(c.config.Namespace),
+		zinformers.WithTweakListOptions(heritageLabelSelector),
+	)
+
+	stackInformer := zFactory.Zalando().V1().Stacks()
+	_, err := stackInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.updateStack,
+		UpdateFunc: func(_, newObj interface{}) {
+			c.updateStack(newObj)
+		},
+		DeleteFunc: c.deleteStack,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add event handler: %w", err)
+	}
+
+	c.listers = resourceListers{
+		stacks:      stackInformer.Lister(),
+		deployments: factory.Apps().V1().Deployments().Lister(),
+		services:    factory.Core().V1().Services().Lister(),
+		hpas:        factory.Autoscaling().V2().HorizontalPodAutoscalers().Lister(),
+		ingresses:   factory.Networking().V1().Ingresses().Lister(),
+	}
+
+	resourceInformers := []cache.SharedIndexInformer{
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().Services().Informer(),
+		factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer(),
+		factory.Networking().V1().Ingresses().Informer(),
+	}
+
+	var routeGroupInformer cache.SharedIndexInformer
+	if c.config.RouteGroupSupportEnabled {
+		routeGroupInformer = cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+					heritageLabelSelector(&options)
+					return c.client.RouteGroupV1().RouteGroups(c.config.Namespace).List(ctx, options)
+				},
+				WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+					heritageLabelSelector(&options)
+					return c.client.RouteGroupV1().RouteGroups(c.config.Namespace).Watch(ctx, options)
+				},
+			},
+			&rgv1.RouteGroup{},
+			0, // skip resync
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		)
+		c.listers.routeGroups = rglisters.NewRouteGroupLister(routeGroupInformer.GetIndexer())
+		resourceInformers = append(resourceInformers, routeGroupInformer)
+	}
+
+	if c.config.ConfigMapSupportEnabled {
+		c.listers.configMaps = factory.Core().V1().ConfigMaps().Lister()
+		resourceInformers = append(resourceInformers, factory.Core().V1().ConfigMaps().Informer())
+	}
+
+	if c.config.SecretSupportEnabled {
+		c.listers.secrets = factory.Core().V1().Secrets().Lister()
+		resourceInformers = append(resourceInformers, factory.Core().V1().Secrets().Informer())
+	}
+
+	if c.config.PcsSupportEnabled {
+		c.listers.platformCredentialsSets = zFactory.Zalando().V1().PlatformCredentialsSets().Lister()
+		resourceInformers = append(resourceInformers, zFactory.Zalando().V1().PlatformCredentialsSets().Informer())
+	}
+
+	for _, informer := range resourceInformers {
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueueOwner,
+			UpdateFunc: func(_, newObj interface{}) {
+				c.enqueueOwner(newObj)
+			},
+			DeleteFunc: c.enqueueOwner,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add event handler: %w", err)
+		}
+	}
+
+	factory.Start(ctx.Done())
+	zFactory.Start(ctx.Done())
+	if routeGroupInformer != nil {
+		go routeGroupInformer.Run(ctx.Done())
+	}
+
+	synced := []cache.InformerSynced{stackInformer.Informer().HasSynced}
+	for _, informer := range resourceInformers {
+		synced = append(synced, informer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("timed out waiting for caches to sync")
+	}
+	c.logger.Info("Synced resource watchers")
+
+	return nil
+}
+
+// updateStack remembers the stackset owning the stack, so that changes to
+// the resources owned by the stack can be mapped to the stackset, and queues
+// the stackset for reconciliation.
+func (c *StackSetController) updateStack(obj interface{}) {
+	stack, ok := obj.(*zv1.Stack)
+	if !ok {
+		return
+	}
+
+	if ownerUID, ok := getOwnerUID(stack.ObjectMeta); ok {
+		c.Lock()
+		c.stackOwners[stack.UID] = ownerUID
+		c.Unlock()
+	}
+
+	c.enqueueOwner(stack)
+}
+
+// deleteStack forgets the owner of a deleted stack and queues the stackset
+// for reconciliation.
+func (c *StackSetController) deleteStack(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	stack, ok := obj.(*zv1.Stack)
+	if !ok {
+		return
+	}
+
+	c.enqueueOwner(stack)
+
+	c.Lock()
+	delete(c.stackOwners, stack.UID)
+	c.Unlock()
+}
+
+// enqueueOwner queues the stackset a resource belongs to for reconciliation.
+// Resources are either owned by the stackset itself or by one of its stacks.
+// Resources without a known owner are mapped to the stackset named in their
+// heritage label.
+func (c *StackSetController) enqueueOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	uid, ok := c.stacksetOwner(object)
+	if ok {
+		c.queue.Add(uid)
+	}
+}
+
+// stacksetOwner returns the UID of the managed stackset a resource belongs
+// to. It must be called with the controller locked.
+func (c *StackSetController) stacksetOwner(object metav1.Object) (types.UID, bool) {
+	for _, ownerRef := range object.GetOwnerReferences() {
+		if _, ok := c.stacksetStore[ownerRef.UID]; ok {
+			return ownerRef.UID, true
+		}
+
+		if stacksetUID, ok := c.stackOwners[ownerRef.UID]; ok {
+			if _, ok := c.stacksetStore[stacksetUID]; ok {
+				return stacksetUID, true
+			}
+		}
+	}
+
+	name, ok := object.GetLabels()[core.StacksetHeritageLabelKey]
+	if !ok {
+		return "", false
+	}
+
+	for uid, stackset := range c.stacksetStore {
+		if stackset.Namespace == object.GetNamespace() && stackset.Name == name {
+			return uid, true
+		}
+	}
+	return "", false
+}
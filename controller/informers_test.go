@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// queuedStackSets drains the queue of the controller and returns the queued
+// stacksets.
+func queuedStackSets(c *StackSetController) []types.UID {
+	var queued []types.UID
+	for c.queue.Len() > 0 {
+		uid, _ := c.queue.Get()
+		c.queue.Done(uid)
+		queued = append(queued, uid)
+	}
+	return queued
+}
+
+func TestStoreStackSet(t *testing.T) {
+	env := NewTestEnvironment()
+	c := env.controller
+
+	stackset := testStackset("foo", "default", "123")
+	c.add(&stackset)
+	require.Contains(t, c.stacksetStore, stackset.UID)
+	require.Equal(t, []types.UID{"123"}, queuedStackSets(c))
+
+	owned := stackset.DeepCopy()
+	owned.Annotations = map[string]string{StacksetControllerControllerAnnotationKey: "other"}
+	c.update(&stackset, owned)
+	require.NotContains(t, c.stacksetStore, stackset.UID)
+	require.Equal(t, []types.UID{"123"}, queuedStackSets(c))
+
+	c.update(owned, owned)
+	require.NotContains(t, c.stacksetStore, stackset.UID)
+	require.Empty(t, queuedStackSets(c))
+
+	c.add(&stackset)
+	require.Equal(t, []types.UID{"123"}, queuedStackSets(c))
+	c.del(cache.DeletedFinalStateUnknown{Key: "default/foo", Obj: &stackset})
+	require.NotContains(t, c.stacksetStore, stackset.UID)
+	require.Equal(t, []types.UID{"123"}, queuedStackSets(c))
+}
+
+func TestEnqueueOwner(t *testing.T) {
+	stackset := testStackset("foo", "default", "123")
+	stack := testStack("foo-v1", "default", "abc", stackset)
+
+	for _, tc := range []struct {
+		name     string
+		object   metav1.ObjectMeta
+		expected []types.UID
+	}{
+		{
+			name:     "owned by the stackset",
+			object:   metav1.ObjectMeta{Name: "foo", Namespace: "default", OwnerReferences: stack.OwnerReferences},
+			expected: []types.UID{"123"},
+		},
+		{
+			name:     "owned by a stack",
+			object:   metav1.ObjectMeta{Name: "foo-v1", Namespace: "default", OwnerReferences: stackOwned(stack).OwnerReferences},
+			expected: []types.UID{"123"},
+		},
+		{
+			name: "heritage label",
+			object: metav1.ObjectMeta{
+				Name:      "foo-v1",
+				Namespace: "default",
+				Labels:    map[string]string{core.StacksetHeritageLabelKey: "foo"},
+			},
+			expected: []types.UID{"123"},
+		},
+		{
+			name: "heritage label in another namespace",
+			object: metav1.ObjectMeta{
+				Name:      "foo-v1",
+				Namespace: "other",
+				Labels:    map[string]string{core.StacksetHeritageLabelKey: "foo"},
+			},
+		},
+		{
+			name: "unrelated resource",
+			object: metav1.ObjectMeta{
+				Name:      "bar",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "zalando.org/v1", Kind: "Stack", Name: "bar-v1", UID: "456"},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := NewTestEnvironment()
+			c := env.controller
+			c.stacksetStore[stackset.UID] = stackset
+			c.updateStack(&stack)
+			require.Equal(t, []types.UID{"123"}, queuedStackSets(c))
+
+			c.enqueueOwner(&apps.Deployment{ObjectMeta: tc.object})
+			require.Equal(t, tc.expected, queuedStackSets(c))
+		})
+	}
+}
+
+func TestDeleteStack(t *testing.T) {
+	env := NewTestEnvironment()
+	c := env.controller
+
+	stackset := testStackset("foo", "default", "123")
+	stack := testStack("foo-v1", "default", "abc", stackset)
+	c.stacksetStore[stackset.UID] = stackset
+
+	c.updateStack(&stack)
+	require.Equal(t, map[types.UID]types.UID{"abc": "123"}, c.stackOwners)
+
+	c.deleteStack(cache.DeletedFinalStateUnknown{Key: "default/foo-v1", Obj: &stack})
+	require.Empty(t, c.stackOwners)
+	require.Equal(t, []types.UID{"123"}, queuedStackSets(c))
+
+	c.enqueueOwner(&apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "foo-v1", Namespace: "default", OwnerReferences: stackOwned(stack).OwnerReferences}})
+	require.Empty(t, queuedStackSets(c))
+}
@@ -0,0 +1,313 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	coordination "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ShardGroupLabelKey is the label of the Leases announcing the
+	// replicas sharing the StackSets of a shard group.
+	ShardGroupLabelKey = "stackset-controller.zalando.org/shard-group"
+)
+
+// ShardingConfig configures splitting the StackSets between multiple
+// replicas of the controller. Every replica announces itself with a Lease,
+// and the StackSets are assigned to the live replicas by consistent hashing
+// of their UID.
+type ShardingConfig struct {
+	// Group is the name of the group of replicas sharing the StackSets,
+	// replicas with different controller IDs must use different groups.
+	Group string
+	// Identity is the unique name of this replica.
+	Identity string
+	// Namespace is the namespace of the Leases.
+	Namespace string
+	// LeaseDuration is the time after which a replica which didn't renew
+	// its Lease isn't considered live anymore. StackSets handed over to
+	// another replica are only reconciled by it once the ownership didn't
+	// change for a LeaseDuration, so that the previous owner is guaranteed
+	// to have stopped reconciling them.
+	LeaseDuration time.Duration
+	// RenewInterval is the interval between renewing the Lease and
+	// discovering the other replicas.
+	RenewInterval time.Duration
+}
+
+// shards tracks the live members of the shard group and decides which
+// StackSets are owned by this replica.
+type shards struct {
+	identity      string
+	handoverDelay time.Duration
+	now           func() time.Time
+
+	// members are the currently live members, while stable are the members
+	// from before the last changes. Ownership moving to this replica is
+	// only taken once the members didn't change for the handover delay.
+	members []string
+	stable  []string
+	changed time.Time
+
+	// renewed is the last time the Lease of this replica was renewed.
+	// Once it would be considered expired by the other replicas, this
+	// replica doesn't own any stacksets until it's renewed again.
+	renewed time.Time
+	sync.Mutex
+}
+
+// shardsState is the state of the shards affecting which stacksets are owned.
+type shardsState struct {
+	live    bool
+	settled bool
+}
+
+func newShards(identity string, handoverDelay time.Duration, now func() time.Time) *shards {
+	return &shards{
+		identity:      identity,
+		handoverDelay: handoverDelay,
+		now:           now,
+		changed:       now(),
+	}
+}
+
+// update sets the live members and reports whether they changed.
+func (s *shards) update(members []string) bool {
+	members = slices.Clone(members)
+	slices.Sort(members)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if slices.Equal(members, s.members) {
+		return false
+	}
+
+	// Only move on to the current members if they were stable, otherwise
+	// stacksets could be taken over before all the replicas have seen
+	// the previous change.
+	now := s.now()
+	if now.Sub(s.changed) >= s.handoverDelay {
+		s.stable = s.members
+	}
+	s.members = members
+	s.changed = now
+	return true
+}
+
+// renew records a successful renewal of the Lease of this replica.
+func (s *shards) renew(renewed time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.renewed = renewed
+}
+
+// owns returns true if the stackset is assigned to this replica. It's false
+// while the stackset is handed over from another replica.
+func (s *shards) owns(uid types.UID) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	state := s.currentState()
+	if !state.live || shardOwner(s.members, uid) != s.identity {
+		return false
+	}
+	return state.settled || shardOwner(s.stable, uid) == s.identity
+}
+
+// state returns the current state of the shards.
+func (s *shards) state() shardsState {
+	s.Lock()
+	defer s.Unlock()
+	return s.currentState()
+}
+
+func (s *shards) currentState() shardsState {
+	now := s.now()
+	return shardsState{
+		live:    now.Sub(s.renewed) < s.handoverDelay,
+		settled: now.Sub(s.changed) >= s.handoverDelay,
+	}
+}
+
+// shardOwner returns the member owning the stackset. It uses rendezvous
+// hashing, so that only the stacksets of a member joining or leaving are
+// moved between members.
+func shardOwner(members []string, uid types.UID) string {
+	var (
+		owner   string
+		highest uint64
+	)
+	for _, member := range members {
+		digest := sha256.Sum256([]byte(member + "/" + string(uid)))
+		if sum := binary.BigEndian.Uint64(digest[:8]); owner == "" || sum > highest {
+			owner = member
+			highest = sum
+		}
+	}
+	return owner
+}
+
+// runSharding renews the Lease of this replica and discovers the other live
+// replicas every RenewInterval. Whenever the ownership of stacksets may have
+// changed, the stacksets are moved in and out of the store.
+func (c *StackSetController) runSharding(ctx context.Context) {
+	ticker := time.NewTicker(c.config.Sharding.RenewInterval)
+	defer ticker.Stop()
+
+	state := c.shards.state()
+	for {
+		err := c.syncShardMembers(ctx)
+		if err != nil {
+			c.logger.Errorf("Failed to sync shard members: %v", err)
+		}
+
+		// Stacksets are taken over once the members settled, and given
+		// up if the Lease couldn't be renewed in time.
+		if current := c.shards.state(); current != state {
+			state = current
+			c.resyncOwnership()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncShardMembers renews the Lease of this replica and updates the live
+// members of the shard group.
+func (c *StackSetController) syncShardMembers(ctx context.Context) error {
+	err := c.renewShardLease(ctx)
+	if err != nil {
+		return err
+	}
+
+	leases, err := c.client.CoordinationV1().Leases(c.config.Sharding.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", ShardGroupLabelKey, c.config.Sharding.Group),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list Leases: %w", err)
+	}
+
+	now := c.shards.now()
+	members := []string{c.config.Sharding.Identity}
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == c.config.Sharding.Identity {
+			continue
+		}
+		if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+
+		expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expiry) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+
+	if c.shards.update(members) {
+		c.logger.Infof("Shard members changed: %v", members)
+		c.resyncOwnership()
+	}
+	return nil
+}
+
+// renewShardLease creates or renews the Lease of this replica.
+func (c *StackSetController) renewShardLease(ctx context.Context) error {
+	leases := c.client.CoordinationV1().Leases(c.config.Sharding.Namespace)
+	renewTime := metav1.NewMicroTime(c.shards.now())
+	leaseDuration := int32(c.config.Sharding.LeaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, c.shardLeaseName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordination.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.shardLeaseName(),
+				Namespace: c.config.Sharding.Namespace,
+				Labels:    map[string]string{ShardGroupLabelKey: c.config.Sharding.Group},
+			},
+			Spec: coordination.LeaseSpec{
+				HolderIdentity:       &c.config.Sharding.Identity,
+				LeaseDurationSeconds: &leaseDuration,
+				AcquireTime:          &renewTime,
+				RenewTime:            &renewTime,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create Lease: %w", err)
+		}
+		c.shards.renew(renewTime.Time)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Lease: %w", err)
+	}
+
+	lease.Spec.LeaseDurationSeconds = &leaseDuration
+	lease.Spec.RenewTime = &renewTime
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to renew Lease: %w", err)
+	}
+	c.shards.renew(renewTime.Time)
+	return nil
+}
+
+// releaseShardLease deletes the Lease of this replica, so that the other
+// replicas take over its stacksets without waiting for the Lease to expire.
+func (c *StackSetController) releaseShardLease(ctx context.Context) error {
+	err := c.client.CoordinationV1().Leases(c.config.Sharding.Namespace).Delete(ctx, c.shardLeaseName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Lease: %w", err)
+	}
+	return nil
+}
+
+func (c *StackSetController) shardLeaseName() string {
+	return c.config.Sharding.Group + "-shard-" + c.config.Sharding.Identity
+}
+
+// ownsShard returns true if the stackset is assigned to this replica, or if
+// sharding is disabled.
+func (c *StackSetController) ownsShard(uid types.UID) bool {
+	if c.shards == nil {
+		return true
+	}
+	return c.shards.owns(uid)
+}
+
+// resyncOwnership adds the stacksets which are now owned by this replica to
+// the store and removes the ones which aren't anymore.
+func (c *StackSetController) resyncOwnership() {
+	if c.stacksetCache == nil {
+		return
+	}
+
+	for _, obj := range c.stacksetCache.List() {
+		stackset, ok := obj.(*zv1.StackSet)
+		if !ok {
+			continue
+		}
+
+		c.Lock()
+		_, stored := c.stacksetStore[stackset.UID]
+		c.Unlock()
+
+		if stored != c.hasOwnership(stackset) {
+			c.storeStackSet(stackset.DeepCopy(), false)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordination "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestShardOwner(t *testing.T) {
+	require.Empty(t, shardOwner(nil, "123"))
+
+	members := []string{"a", "b", "c"}
+	owners := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		owner := shardOwner(members, uid)
+		owners[owner]++
+
+		// Only the stacksets of a leaving member are moved
+		remaining := shardOwner([]string{"a", "b"}, uid)
+		if owner != "c" {
+			require.Equal(t, owner, remaining)
+		}
+
+		// The order of the members doesn't matter
+		require.Equal(t, owner, shardOwner([]string{"c", "a", "b"}, uid))
+	}
+
+	for _, member := range members {
+		require.Greater(t, owners[member], 50, "stacksets of %s", member)
+	}
+}
+
+func TestShardsHandover(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	// Find stacksets owned by a, and by b once it joins
+	var ownedByA, movedToB types.UID
+	for i := 0; ownedByA == "" || movedToB == ""; i++ {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		switch shardOwner([]string{"a", "b"}, uid) {
+		case "a":
+			ownedByA = uid
+		case "b":
+			movedToB = uid
+		}
+	}
+
+	a := newShards("a", time.Minute, clock)
+	b := newShards("b", time.Minute, clock)
+	a.renew(now)
+	require.True(t, a.update([]string{"a"}))
+	require.False(t, a.update([]string{"a"}))
+
+	// Nothing is owned until the members settled
+	require.False(t, a.owns(ownedByA))
+	now = now.Add(time.Minute)
+	a.renew(now)
+	require.True(t, a.owns(ownedByA))
+	require.True(t, a.owns(movedToB))
+
+	// b joins, a immediately gives up the stacksets of b
+	require.True(t, a.update([]string{"b", "a"}))
+	b.renew(now)
+	require.True(t, b.update([]string{"a", "b"}))
+	require.True(t, a.owns(ownedByA))
+	require.False(t, a.owns(movedToB))
+	require.False(t, b.owns(movedToB))
+
+	// b takes over once the members settled
+	now = now.Add(time.Minute)
+	a.renew(now)
+	b.renew(now)
+	require.True(t, b.owns(movedToB))
+	require.False(t, b.owns(ownedByA))
+	require.True(t, a.owns(ownedByA))
+
+	// b leaves, a only takes over its stacksets once the members settled
+	require.True(t, a.update([]string{"a"}))
+	require.True(t, a.owns(ownedByA))
+	require.False(t, a.owns(movedToB))
+
+	// b joins again before the members settled, so the stacksets are
+	// still handed over from a
+	now = now.Add(30 * time.Second)
+	a.renew(now)
+	require.True(t, a.update([]string{"a", "b"}))
+	require.True(t, a.owns(ownedByA))
+	require.False(t, a.owns(movedToB))
+
+	// a gives up all stacksets if it can't renew its Lease
+	now = now.Add(time.Minute)
+	require.False(t, a.owns(ownedByA))
+	a.renew(now)
+	require.True(t, a.owns(ownedByA))
+}
+
+func TestSyncShardMembers(t *testing.T) {
+	env := NewTestEnvironment()
+	c := env.controller
+	c.config.Sharding = &ShardingConfig{
+		Group:         "stackset-controller",
+		Identity:      "a",
+		Namespace:     "kube-system",
+		LeaseDuration: time.Minute,
+		RenewInterval: 10 * time.Second,
+	}
+
+	now := time.Now()
+	c.shards = newShards("a", time.Minute, func() time.Time { return now })
+
+	lease := func(identity string, renewed time.Time, group string) *coordination.Lease {
+		renewTime := metav1.NewMicroTime(renewed)
+		duration := int32(60)
+		return &coordination.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-shard-%s", group, identity),
+				Namespace: "kube-system",
+				Labels:    map[string]string{ShardGroupLabelKey: group},
+			},
+			Spec: coordination.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &duration,
+				RenewTime:            &renewTime,
+			},
+		}
+	}
+
+	for _, l := range []*coordination.Lease{
+		lease("b", now.Add(-30*time.Second), "stackset-controller"),
+		lease("c", now.Add(-2*time.Minute), "stackset-controller"),
+		lease("d", now, "stackset-controller-other"),
+	} {
+		_, err := env.client.CoordinationV1().Leases("kube-system").Create(context.Background(), l, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	err := c.syncShardMembers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, c.shards.members)
+
+	own, err := env.client.CoordinationV1().Leases("kube-system").Get(context.Background(), "stackset-controller-shard-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "a", *own.Spec.HolderIdentity)
+	require.Equal(t, now.Unix(), own.Spec.RenewTime.Unix())
+
+	// The Lease is renewed
+	now = now.Add(time.Minute)
+	err = c.syncShardMembers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, c.shards.members)
+
+	own, err = env.client.CoordinationV1().Leases("kube-system").Get(context.Background(), "stackset-controller-shard-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, now.Unix(), own.Spec.RenewTime.Unix())
+
+	err = c.releaseShardLease(context.Background())
+	require.NoError(t, err)
+	_, err = env.client.CoordinationV1().Leases("kube-system").Get(context.Background(), "stackset-controller-shard-a", metav1.GetOptions{})
+	require.Error(t, err)
+}
+
+func TestHasOwnershipSharded(t *testing.T) {
+	env := NewTestEnvironment()
+	c := env.controller
+
+	now := time.Now()
+	c.shards = newShards("a", time.Minute, func() time.Time { return now })
+	c.shards.renew(now)
+	c.shards.update([]string{"a", "b"})
+	now = now.Add(time.Minute)
+	c.shards.renew(now)
+
+	var owned, notOwned types.UID
+	for i := 0; owned == "" || notOwned == ""; i++ {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		if shardOwner([]string{"a", "b"}, uid) == "a" {
+			owned = uid
+		} else {
+			notOwned = uid
+		}
+	}
+
+	ownedStackset := testStackset("foo", "default", owned)
+	notOwnedStackset := testStackset("bar", "default", notOwned)
+	require.True(t, c.hasOwnership(&ownedStackset))
+	require.False(t, c.hasOwnership(&notOwnedStackset))
+
+	ownedStackset.Annotations = map[string]string{StacksetControllerControllerAnnotationKey: "other"}
+	require.False(t, c.hasOwnership(&ownedStackset))
+}
@@ -46,6 +46,10 @@ func (c *StackSetController) ReconcileStackDeployment(ctx context.Context, stack
 
 	// Create new deployment
 	if existing == nil {
+		if c.planChange(verbCreate, "Deployment", nil, deployment) {
+			return nil
+		}
+
 		_, err := c.client.AppsV1().Deployments(deployment.Namespace).Create(ctx, deployment, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -69,6 +73,10 @@ func (c *StackSetController) ReconcileStackDeployment(ctx context.Context, stack
 	updated.Spec = deployment.Spec
 	updated.Spec.Selector = existing.Spec.Selector
 
+	if c.planChange(verbUpdate, "Deployment", existing, updated) {
+		return nil
+	}
+
 	_, err := c.client.AppsV1().Deployments(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return err
@@ -91,6 +99,10 @@ func (c *StackSetController) ReconcileStackHPA(ctx context.Context, stack *zv1.S
 	// HPA removed
 	if hpa == nil {
 		if existing != nil {
+			if c.planChange(verbDelete, "HorizontalPodAutoscaler", existing, nil) {
+				return nil
+			}
+
 			err := c.client.AutoscalingV2().HorizontalPodAutoscalers(existing.Namespace).Delete(ctx, existing.Name, metav1.DeleteOptions{})
 			if err != nil {
 				return err
@@ -107,6 +119,10 @@ func (c *StackSetController) ReconcileStackHPA(ctx context.Context, stack *zv1.S
 
 	// Create new HPA
 	if existing == nil {
+		if c.planChange(verbCreate, "HorizontalPodAutoscaler", nil, hpa) {
+			return nil
+		}
+
 		_, err := c.client.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace).Create(ctx, hpa, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -131,6 +147,10 @@ func (c *StackSetController) ReconcileStackHPA(ctx context.Context, stack *zv1.S
 	syncObjectMeta(updated, hpa)
 	updated.Spec = hpa.Spec
 
+	if c.planChange(verbUpdate, "HorizontalPodAutoscaler", existing, updated) {
+		return nil
+	}
+
 	_, err = c.client.AutoscalingV2().HorizontalPodAutoscalers(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return err
@@ -152,6 +172,10 @@ func (c *StackSetController) ReconcileStackService(ctx context.Context, stack *z
 
 	// Create new service
 	if existing == nil {
+		if c.planChange(verbCreate, "Service", nil, service) {
+			return nil
+		}
+
 		_, err := c.client.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -175,6 +199,10 @@ func (c *StackSetController) ReconcileStackService(ctx context.Context, stack *z
 	updated.Spec = service.Spec
 	updated.Spec.ClusterIP = existing.Spec.ClusterIP // ClusterIP is immutable
 
+	if c.planChange(verbUpdate, "Service", existing, updated) {
+		return nil
+	}
+
 	_, err = c.client.CoreV1().Services(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return err
@@ -197,6 +225,10 @@ func (c *StackSetController) ReconcileStackIngress(ctx context.Context, stack *z
 	// Ingress removed
 	if ingress == nil {
 		if existing != nil {
+			if c.planChange(verbDelete, "Ingress", existing, nil) {
+				return nil
+			}
+
 			err := c.client.NetworkingV1().Ingresses(existing.Namespace).Delete(ctx, existing.Name, metav1.DeleteOptions{})
 			if err != nil {
 				return err
@@ -213,6 +245,10 @@ func (c *StackSetController) ReconcileStackIngress(ctx context.Context, stack *z
 
 	// Create new Ingress
 	if existing == nil {
+		if c.planChange(verbCreate, "Ingress", nil, ingress) {
+			return nil
+		}
+
 		_, err := c.client.NetworkingV1().Ingresses(ingress.Namespace).Create(ctx, ingress, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -237,6 +273,10 @@ func (c *StackSetController) ReconcileStackIngress(ctx context.Context, stack *z
 	syncObjectMeta(updated, ingress)
 	updated.Spec = ingress.Spec
 
+	if c.planChange(verbUpdate, "Ingress", existing, updated) {
+		return nil
+	}
+
 	_, err = c.client.NetworkingV1().Ingresses(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return err
@@ -259,6 +299,10 @@ func (c *StackSetController) ReconcileStackRouteGroup(ctx context.Context, stack
 	// RouteGroup removed
 	if routegroup == nil {
 		if existing != nil {
+			if c.planChange(verbDelete, "RouteGroup", existing, nil) {
+				return nil
+			}
+
 			err := c.client.RouteGroupV1().RouteGroups(existing.Namespace).Delete(ctx, existing.Name, metav1.DeleteOptions{})
 			if err != nil {
 				return err
@@ -275,6 +319,10 @@ func (c *StackSetController) ReconcileStackRouteGroup(ctx context.Context, stack
 
 	// Create new RouteGroup
 	if existing == nil {
+		if c.planChange(verbCreate, "RouteGroup", nil, routegroup) {
+			return nil
+		}
+
 		_, err := c.client.RouteGroupV1().RouteGroups(routegroup.Namespace).Create(ctx, routegroup, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -303,6 +351,10 @@ func (c *StackSetController) ReconcileStackRouteGroup(ctx context.Context, stack
 	syncObjectMeta(updated, routegroup)
 	updated.Spec = routegroup.Spec
 
+	if c.planChange(verbUpdate, "RouteGroup", existing, updated) {
+		return nil
+	}
+
 	_, err = c.client.RouteGroupV1().RouteGroups(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return err
@@ -338,7 +390,7 @@ func (c *StackSetController) ReconcileStackConfigMapRefs(
 			continue
 		}
 
-		if err := validateConfigurationResourceName(stack.Name, rsc.GetName()); err != nil {
+		if err := core.ValidateConfigurationResourceName(stack.Name, rsc.GetName()); err != nil {
 			return err
 		}
 
@@ -375,9 +427,14 @@ func (c *StackSetController) ReconcileStackConfigMapRef(
 			"ConfigMap: %s, Stack: %s", rsc.GetName(), stack.Name)
 	}
 
+	existing := configMap.DeepCopy()
 	objectMeta := updateObjMeta(&configMap.ObjectMeta)
 	configMap.ObjectMeta = *objectMeta
 
+	if c.planChange(verbUpdate, "ConfigMap", existing, configMap) {
+		return nil
+	}
+
 	_, err = c.client.CoreV1().ConfigMaps(configMap.Namespace).
 		Update(ctx, configMap, metav1.UpdateOptions{})
 	if err != nil {
@@ -417,7 +474,7 @@ func (c *StackSetController) ReconcileStackSecretRefs(
 			continue
 		}
 
-		if err := validateConfigurationResourceName(stack.Name, rsc.GetName()); err != nil {
+		if err := core.ValidateConfigurationResourceName(stack.Name, rsc.GetName()); err != nil {
 			return err
 		}
 
@@ -453,9 +510,15 @@ func (c *StackSetController) ReconcileStackSecretRef(ctx context.Context,
 			"Secret: %s, Stack: %s", rsc.GetName(), stack.Name)
 	}
 
+	existing := secret.ObjectMeta.DeepCopy()
 	objectMeta := updateObjMeta(&secret.ObjectMeta)
 	secret.ObjectMeta = *objectMeta
 
+	// Only the metadata is compared to keep the data out of the logs.
+	if c.planChange(verbUpdate, "Secret", existing, &secret.ObjectMeta) {
+		return nil
+	}
+
 	_, err = c.client.CoreV1().Secrets(secret.Namespace).
 		Update(ctx, secret, metav1.UpdateOptions{})
 	if err != nil {
@@ -520,6 +583,10 @@ func (c *StackSetController) ReconcileStackPlatformCredentialsSet(
 		syncObjectMeta(updated, pcs)
 		updated.Spec = pcs.Spec
 
+		if c.planChange(verbUpdate, "PlatformCredentialsSet", e, updated) {
+			return nil
+		}
+
 		_, err := c.client.ZalandoV1().PlatformCredentialsSets(updated.Namespace).
 			Update(ctx, updated, metav1.UpdateOptions{})
 		if err != nil {
@@ -536,6 +603,10 @@ func (c *StackSetController) ReconcileStackPlatformCredentialsSet(
 	}
 
 	// Create new PlatformCredentialsSet
+	if c.planChange(verbCreate, "PlatformCredentialsSet", nil, pcs) {
+		return nil
+	}
+
 	_, err = c.client.ZalandoV1().PlatformCredentialsSets(pcs.Namespace).
 		Create(ctx, pcs, metav1.CreateOptions{})
 	if err != nil {
@@ -3,8 +3,9 @@ package controller
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"maps"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,7 +20,6 @@ import (
 	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
 	"github.com/zalando-incubator/stackset-controller/pkg/core"
 	"github.com/zalando-incubator/stackset-controller/pkg/recorder"
-	"golang.org/x/sync/errgroup"
 	v1 "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -27,34 +27,42 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
 	PrescaleStacksAnnotationKey               = "alpha.stackset-controller.zalando.org/prescale-stacks"
 	ResetHPAMinReplicasDelayAnnotationKey     = "alpha.stackset-controller.zalando.org/reset-hpa-min-replicas-delay"
+	TrafficRateLimitAnnotationKey             = "alpha.stackset-controller.zalando.org/traffic-rate-limit"
+	TrafficRateLimitIntervalAnnotationKey     = "alpha.stackset-controller.zalando.org/traffic-rate-limit-interval"
 	StacksetControllerControllerAnnotationKey = "stackset-controller.zalando.org/controller"
 	ControllerLastUpdatedAnnotationKey        = "stackset-controller.zalando.org/updated-timestamp"
 
 	reasonFailedManageStackSet = "FailedManageStackSet"
-
-	defaultResetMinReplicasDelay = 10 * time.Minute
 )
 
-var configurationResourceNameError = "ConfigurationResource name must be prefixed by Stack name. ConfigurationResource: %s, Stack: %s"
-
 // StackSetController is the main controller. It watches for changes to
-// stackset resources and starts and maintains other controllers per
-// stackset resource.
+// stackset resources and the resources managed for them, and reconciles a
+// stackset whenever it or one of its resources changes.
 type StackSetController struct {
 	logger          *log.Entry
 	client          clientset.Interface
 	config          StackSetConfig
-	stacksetEvents  chan stacksetEvent
+	queue           workqueue.TypedRateLimitingInterface[types.UID]
 	stacksetStore   map[types.UID]zv1.StackSet
+	stacksetCache   cache.Store
+	stackOwners     map[types.UID]types.UID
+	listers         resourceListers
+	containers      map[types.UID]*core.StackSetContainer
+	shards          *shards
+	plan            dryRunPlan
 	recorder        kube_record.EventRecorder
 	metricsReporter *core.MetricsReporter
 	HealthReporter  healthcheck.Handler
@@ -77,11 +85,18 @@ type StackSetConfig struct {
 	ConfigMapSupportEnabled  bool
 	SecretSupportEnabled     bool
 	PcsSupportEnabled        bool
-}
 
-type stacksetEvent struct {
-	Deleted  bool
-	StackSet *zv1.StackSet
+	// DryRun only logs the changes the controller would make to the
+	// resources instead of applying them.
+	DryRun bool
+
+	// Sharding splits the StackSets between multiple replicas of the
+	// controller. Sharding is disabled if not set.
+	Sharding *ShardingConfig
+
+	// AnalysisProvider is used to query the metrics defined in the
+	// analysis section of StackSets. Analysis is disabled if not set.
+	AnalysisProvider core.AnalysisProvider
 }
 
 // eventedError wraps an error that was already exposed as an event to the user
@@ -114,13 +129,30 @@ func NewStackSetController(
 		logger = logger.WithField("controller_id", config.ControllerID)
 	}
 
+	var stacksetShards *shards
+	if config.Sharding != nil {
+		stacksetShards = newShards(config.Sharding.Identity, config.Sharding.LeaseDuration, time.Now)
+	}
+
+	eventRecorder := recorder.CreateEventRecorder(client)
+	if config.DryRun {
+		eventRecorder = recorder.CreateLoggingEventRecorder()
+	}
+
 	return &StackSetController{
-		logger:          logger,
-		client:          client,
-		config:          config,
-		stacksetEvents:  make(chan stacksetEvent, 1),
+		logger: logger,
+		client: client,
+		config: config,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[types.UID](),
+			workqueue.TypedRateLimitingQueueConfig[types.UID]{Name: "stacksets"},
+		),
 		stacksetStore:   make(map[types.UID]zv1.StackSet),
-		recorder:        recorder.CreateEventRecorder(client),
+		stackOwners:     make(map[types.UID]types.UID),
+		containers:      make(map[types.UID]*core.StackSetContainer),
+		shards:          stacksetShards,
+		plan:            dryRunPlan{changes: make(map[string]string)},
+		recorder:        eventRecorder,
 		metricsReporter: metricsReporter,
 		HealthReporter:  healthcheck.NewHandler(),
 		now:             now,
@@ -142,11 +174,16 @@ func (c *StackSetController) stackLogger(ssc *core.StackSetContainer, sc *core.S
 	})
 }
 
-// Run runs the main loop of the StackSetController. Before the loops it
-// sets up a watcher to watch StackSet resources. The watch will send
-// changes over a channel which is polled from the main loop.
+// Run runs the main loop of the StackSetController. Before the loop it
+// sets up watchers for StackSets and the resources managed for them, which
+// queue a StackSet for reconciliation whenever it or one of its resources
+// changes. The queued StackSets are reconciled by ReconcileWorkers workers,
+// while the main loop periodically queues all StackSets as a safety net and
+// reports the metrics. When the context is cancelled Run waits for the
+// workers to finish the StackSets they're reconciling before returning.
 func (c *StackSetController) Run(ctx context.Context) error {
-	var nextCheck time.Time
+	// Give the watchers time to sync before the first check
+	nextCheck := time.Now()
 
 	// We're not alive if nextCheck is too far in the past
 	c.HealthReporter.AddLivenessCheck("nextCheck", func() error {
@@ -156,76 +193,70 @@ func (c *StackSetController) Run(ctx context.Context) error {
 		return nil
 	})
 
+	var workers sync.WaitGroup
+	defer func() {
+		c.queue.ShutDown()
+		workers.Wait()
+
+		if c.shards != nil {
+			releaseCtx, cancel := context.WithTimeout(context.Background(), c.config.Sharding.RenewInterval)
+			defer cancel()
+
+			err := c.releaseShardLease(releaseCtx)
+			if err != nil {
+				c.logger.Errorf("Failed to release the shard Lease: %v", err)
+			}
+		}
+	}()
+
 	err := c.startWatch(ctx)
 	if err != nil {
 		return err
 	}
 
-	http.HandleFunc("/healthz", c.HealthReporter.LiveEndpoint)
+	err = c.migrateHeritageLabels(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.startInformers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.shards != nil {
+		go c.runSharding(ctx)
+	}
+
+	for i := 0; i < c.config.ReconcileWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.UntilWithContext(ctx, c.runWorker, time.Second)
+		}()
+	}
 
 	nextCheck = time.Now().Add(-c.config.Interval)
 
 	for {
 		select {
 		case <-time.After(time.Until(nextCheck)):
-
 			nextCheck = time.Now().Add(c.config.Interval)
 
-			stackSetContainers, err := c.collectResources(ctx)
-			if err != nil {
-				c.logger.Errorf("Failed to collect resources: %v", err)
-				continue
+			// Resync all stacksets, e.g. to continue rate limited
+			// traffic switches and to scale down stacks without
+			// traffic, which aren't triggered by any change.
+			c.Lock()
+			for uid := range c.stacksetStore {
+				c.queue.Add(uid)
 			}
+			containers := maps.Clone(c.containers)
+			c.Unlock()
 
-			var reconcileGroup errgroup.Group
-			reconcileGroup.SetLimit(c.config.ReconcileWorkers)
-			for stackset, container := range stackSetContainers {
-				container := container
-				stackset := stackset
-
-				reconcileGroup.Go(func() error {
-					if _, ok := c.stacksetStore[stackset]; ok {
-						err := c.ReconcileStackSet(ctx, container)
-						if err != nil {
-							c.stacksetLogger(container).Errorf("unable to reconcile a stackset: %v", err)
-							return c.errorEventf(container.StackSet, reasonFailedManageStackSet, err)
-						}
-					}
-					return nil
-				})
-			}
-
-			err = reconcileGroup.Wait()
-			if err != nil {
-				c.logger.Errorf("Failed waiting for reconcilers: %v", err)
-			}
-			err = c.metricsReporter.Report(stackSetContainers)
+			err = c.metricsReporter.Report(containers)
 			if err != nil {
 				c.logger.Errorf("Failed reporting metrics: %v", err)
 			}
-		case e := <-c.stacksetEvents:
-			stackset := *e.StackSet
-			fixupStackSetTypeMeta(&stackset)
-
-			// update/delete existing entry
-			if _, ok := c.stacksetStore[stackset.UID]; ok {
-				if e.Deleted || !c.hasOwnership(&stackset) {
-					delete(c.stacksetStore, stackset.UID)
-					continue
-				}
-
-				// update stackset entry
-				c.stacksetStore[stackset.UID] = stackset
-				continue
-			}
-
-			// check if stackset should be managed by the controller
-			if !c.hasOwnership(&stackset) {
-				continue
-			}
-
-			c.logger.Infof("Adding entry for StackSet %s/%s", stackset.Namespace, stackset.Name)
-			c.stacksetStore[stackset.UID] = stackset
 		case <-ctx.Done():
 			c.logger.Info("Terminating main controller loop.")
 			return nil
@@ -233,24 +264,85 @@ func (c *StackSetController) Run(ctx context.Context) error {
 	}
 }
 
-// collectResources collects resources for all stacksets at once and stores them per StackSet/Stack so that we don't
-// overload the API requests with unnecessary requests
-func (c *StackSetController) collectResources(ctx context.Context) (map[types.UID]*core.StackSetContainer, error) {
-	stacksets := make(map[types.UID]*core.StackSetContainer, len(c.stacksetStore))
-	for uid, stackset := range c.stacksetStore {
-		stackset := stackset
+// runWorker reconciles the queued stacksets until the queue is shut down.
+func (c *StackSetController) runWorker(ctx context.Context) {
+	for c.processNextStackSet(ctx) {
+	}
+}
 
-		reconciler := core.TrafficReconciler(&core.SimpleTrafficReconciler{})
+// processNextStackSet reconciles the next queued stackset. Stacksets which
+// failed to reconcile are queued again with a backoff.
+func (c *StackSetController) processNextStackSet(ctx context.Context) bool {
+	uid, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(uid)
 
-		// use prescaling logic if enabled with an annotation
-		if _, ok := stackset.Annotations[PrescaleStacksAnnotationKey]; ok {
-			resetDelay := defaultResetMinReplicasDelay
-			if resetDelayValue, ok := getResetMinReplicasDelay(stackset.Annotations); ok {
-				resetDelay = resetDelayValue
-			}
-			reconciler = &core.PrescalingTrafficReconciler{
-				ResetHPAMinReplicasTimeout: resetDelay,
-			}
+	// Don't start reconciling the remaining stacksets once terminating
+	if ctx.Err() != nil {
+		return false
+	}
+
+	err := c.reconcile(ctx, uid)
+	if err != nil {
+		c.queue.AddRateLimited(uid)
+		return true
+	}
+
+	c.queue.Forget(uid)
+	return true
+}
+
+// reconcile collects the resources of a stackset and reconciles it.
+func (c *StackSetController) reconcile(ctx context.Context, uid types.UID) error {
+	c.Lock()
+	stackset, ok := c.stacksetStore[uid]
+	if !ok {
+		delete(c.containers, uid)
+	}
+	c.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	stackSetContainers, err := c.collectResources(stackset.Namespace, map[types.UID]zv1.StackSet{uid: stackset})
+	if err != nil {
+		c.logger.Errorf("Failed to collect resources of StackSet %s/%s: %v", stackset.Namespace, stackset.Name, err)
+		return err
+	}
+	container := stackSetContainers[uid]
+
+	err = c.ReconcileStackSet(ctx, container)
+
+	c.Lock()
+	if _, ok := c.stacksetStore[uid]; ok {
+		c.containers[uid] = container
+	}
+	c.Unlock()
+
+	if err != nil {
+		c.stacksetLogger(container).Errorf("unable to reconcile a stackset: %v", err)
+		return c.errorEventf(container.StackSet, reasonFailedManageStackSet, err)
+	}
+	return nil
+}
+
+// collectResources collects resources for the stacksets in the namespace at
+// once from the informer caches and stores them per StackSet/Stack. Only the
+// resources with the heritage label of one of the stacksets are considered,
+// resources created without it are labeled by migrateHeritageLabels.
+func (c *StackSetController) collectResources(namespace string, stacksetStore map[types.UID]zv1.StackSet) (map[types.UID]*core.StackSetContainer, error) {
+	stacksets := make(map[types.UID]*core.StackSetContainer, len(stacksetStore))
+	for uid, stackset := range stacksetStore {
+		stackset := stackset
+
+		reconciler, err := core.NewTrafficReconciler(trafficStrategy(&stackset))
+		if err != nil {
+			c.logger.Errorf("Invalid traffic strategy of StackSet %s/%s, using the default: %v", stackset.Namespace, stackset.Name, err)
+			_ = c.errorEventf(&stackset, "InvalidTrafficStrategy", err)
+			reconciler = &core.SimpleTrafficReconciler{}
 		}
 
 		stacksetContainer := core.NewContainer(
@@ -263,54 +355,59 @@ func (c *StackSetController) collectResources(ctx context.Context) (map[types.UI
 		stacksets[uid] = stacksetContainer
 	}
 
-	err := c.collectStacks(ctx, stacksets)
+	selector, err := heritageSelector(stacksets)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.collectIngresses(ctx, stacksets)
+	err = c.collectStacks(namespace, selector, stacksets)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.collectIngresses(namespace, selector, stacksets)
 	if err != nil {
 		return nil, err
 	}
 
 	if c.config.RouteGroupSupportEnabled {
-		err = c.collectRouteGroups(ctx, stacksets)
+		err = c.collectRouteGroups(namespace, selector, stacksets)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err = c.collectDeployments(ctx, stacksets)
+	err = c.collectDeployments(namespace, selector, stacksets)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.collectServices(ctx, stacksets)
+	err = c.collectServices(namespace, selector, stacksets)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.collectHPAs(ctx, stacksets)
+	err = c.collectHPAs(namespace, selector, stacksets)
 	if err != nil {
 		return nil, err
 	}
 
 	if c.config.ConfigMapSupportEnabled {
-		err = c.collectConfigMaps(ctx, stacksets)
+		err = c.collectConfigMaps(namespace, selector, stacksets)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if c.config.SecretSupportEnabled {
-		err = c.collectSecrets(ctx, stacksets)
+		err = c.collectSecrets(namespace, selector, stacksets)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if c.config.PcsSupportEnabled {
-		err = c.collectPlatformCredentialsSet(ctx, stacksets)
+		err = c.collectPlatformCredentialsSet(namespace, selector, stacksets)
 		if err != nil {
 			return nil, err
 		}
@@ -319,19 +416,18 @@ func (c *StackSetController) collectResources(ctx context.Context) (map[types.UI
 	return stacksets, nil
 }
 
-func (c *StackSetController) collectIngresses(ctx context.Context, stacksets map[types.UID]*core.StackSetContainer) error {
-	ingresses, err := c.client.NetworkingV1().Ingresses(c.config.Namespace).List(ctx, metav1.ListOptions{})
-
+func (c *StackSetController) collectIngresses(namespace string, selector labels.Selector, stacksets map[types.UID]*core.StackSetContainer) error {
+	ingresses, err := c.listers.ingresses.Ingresses(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list Ingresses: %v", err)
 	}
 
-	for _, i := range ingresses.Items {
-		ingress := i
+	for _, i := range ingresses {
+		ingress := i.DeepCopy()
 		if uid, ok := getOwnerUID(ingress.ObjectMeta); ok {
 			// stackset ingress
 			if s, ok := stacksets[uid]; ok {
-				s.Ingress = &ingress
+				s.Ingress = ingress
 				continue
 			}
 
@@ -343,9 +439,9 @@ func (c *StackSetController) collectIngresses(ctx context.Context, stacksets map
 						core.SegmentSuffix,
 					) {
 						// Traffic Segment
-						s.Resources.IngressSegment = &ingress
+						s.Resources.IngressSegment = ingress
 					} else {
-						s.Resources.Ingress = &ingress
+						s.Resources.Ingress = ingress
 					}
 					break
 				}
@@ -355,21 +451,18 @@ func (c *StackSetController) collectIngresses(ctx context.Context, stacksets map
 	return nil
 }
 
-func (c *StackSetController) collectRouteGroups(ctx context.Context, stacksets map[types.UID]*core.StackSetContainer) error {
-	rgs, err := c.client.RouteGroupV1().RouteGroups(c.config.Namespace).List(
-		ctx,
-		metav1.ListOptions{},
-	)
+func (c *StackSetController) collectRouteGroups(namespace string, selector labels.Selector, stacksets map[types.UID]*core.StackSetContainer) error {
+	rgs, err := c.listers.routeGroups.RouteGroups(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list RouteGroups: %v", err)
 	}
 
-	for _, rg := range rgs.Items {
-		routegroup := rg
+	for _, rg := range rgs {
+		routegroup := rg.DeepCopy()
 		if uid, ok := getOwnerUID(routegroup.ObjectMeta); ok {
 			// stackset routegroups
 			if s, ok := stacksets[uid]; ok {
-				s.RouteGroup = &routegroup
+				s.RouteGroup = routegroup
 				continue
 			}
 
@@ -381,9 +474,9 @@ func (c *StackSetController) collectRouteGroups(ctx context.Context, stacksets m
 						core.SegmentSuffix,
 					) {
 						// Traffic Segment
-						s.Resources.RouteGroupSegment = &routegroup
+						s.Resources.RouteGroupSegment = routegroup
 					} else {
-						s.Resources.RouteGroup = &routegroup
+						s.Resources.RouteGroup = routegroup
 					}
 					break
 				}
@@ -393,20 +486,20 @@ func (c *StackSetController) collectRouteGroups(ctx context.Context, stacksets m
 	return nil
 }
 
-func (c *StackSetController) collectStacks(ctx context.Context, stacksets map[types.UID]*core.StackSetContainer) error {
-	stacks, err := c.client.ZalandoV1().Stacks(c.config.Namespace).List(ctx, metav1.ListOptions{})
+func (c *StackSetController) collectStacks(namespace string, selector labels.Selector, stacksets map[types.UID]*core.StackSetContainer) error {
+	stacks, err := c.listers.stacks.Stacks(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list Stacks: %v", err)
 	}
 
-	for _, stack := range stacks.Items {
+	for _, stack := range stacks {
 		if uid, ok := getOwnerUID(stack.ObjectMeta); ok {
 			if s, ok := stacksets[uid]; ok {
-				stack := stack
-				fixupStackTypeMeta(&stack)
+				stack := stack.DeepCopy()
+				fixupStackTypeMeta(stack)
 
 				s.StackContainers[stack.UID] = &core.StackContainer{
-					Stack: &stack,
+					Stack: stack,
 				}
 				continue
 			}
@@ -415,18 +508,18 @@ func (c *StackSetController) collectStacks(ctx context.Context, stacksets map[ty
 	return nil
 }
 
-func (c *StackSetController) collectDeployments(ctx context.Context, stacksets map[types.UID]*core.StackSetContainer) error {
-	deployments, err := c.client.AppsV1().Deployments(c.config.Namespace).List(ctx, metav1.ListOptions{})
+func (c *StackSetController) collectDeployments(namespace string, selector labels.Selector, stacksets map[types.UID]*core.StackSetContainer) error {
+	deployments, err := c.listers.deployments.Deployments(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list Deployments: %v", err)
 	}
 
-	for _, d := range deployments.Items {
-		deployment := d
+	for _, d := range deployments {
+		deployment := d.DeepCopy()
 		if uid, ok := getOwnerUID(deployment.ObjectMeta); ok {
 			for _, stackset := range stacksets {
 				if s, ok := stackset.StackContainers[uid]; ok {
-					s.Resources.Deployment = &deployment
+					s.Resources.Deployment = deployment
 					break
 				}
 			}
@@ -435,26 +528,26 @@ func (c *StackSetController) collectDeployments(ctx context.Context, stacksets m
 	return nil
 }
 
-func (c *StackSetController) collectServices(ctx context.Context, stacksets map[types.UID]*core.StackSetContainer) error {
-	services, err := c.client.CoreV1().Services(c.config.Namespace).List(ctx, metav1.ListOptions{})
+func (c *StackSetController) collectServices(namespace string, selector labels.Selector, stacksets map[types.UID]*core.StackSetContainer) error {
+	services, err := c.listers.services.Services(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list Services: %v", err)
 	}
 
 Items:
-	for _, s := range services.Items {
-		service := s
+	for _, s := range services {
+		service := s.DeepCopy()
 		if uid, ok := getOwnerUID(service.ObjectMeta); ok {
 			for _, stackset := range stacksets {
 				if s, ok := stackset.StackContainers[uid]; ok {
-					s.Resources.Service = &service
+					s.Resources.Service = service
 					continue Items
 				}
 
 				// service/HPA used to be owned by the deployment for some reason
 				for _, stack := range stackset.StackContainers {
 					if stack.Resources.Deployment != nil && stack.Resources.Deployment.UID == uid {
-						stack.Resources.Service = &service
+						stack.Resources.Service = service
 						continue Items
 					}
 				}
@@ -464,26 +557,26 @@ Items:
 	return nil
 }
 
-func (c *StackSetController) collectHPAs(ctx context.Context, stacksets map[types.UID]*core.StackSetContainer) error {
-	hpas, err := c.client.AutoscalingV2().HorizontalPodAutoscalers(c.config.Namespace).List(ctx, metav1.ListOptions{})
+func (c *StackSetController) collectHPAs(namespace string, selector labels.Selector, stacksets map[types.UID]*core.StackSetContainer) error {
+	hpas, err := c.listers.hpas.HorizontalPodAutoscalers(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list HPAs: %v", err)
 	}
 
 Items:
-	for _, h := range hpas.Items {
-		hpa := h
+	for _, h := range hpas {
+		hpa := h.DeepCopy()
 		if uid, ok := getOwnerUID(hpa.ObjectMeta); ok {
 			for _, stackset := range stacksets {
 				if s, ok := stackset.StackContainers[uid]; ok {
-					s.Resources.HPA = &hpa
+					s.Resources.HPA = hpa
 					continue Items
 				}
 
 				// service/HPA used to be owned by the deployment for some reason
 				for _, stack := range stackset.StackContainers {
 					if stack.Resources.Deployment != nil && stack.Resources.Deployment.UID == uid {
-						stack.Resources.HPA = &hpa
+						stack.Resources.HPA = hpa
 						continue Items
 					}
 				}
@@ -494,20 +587,21 @@ Items:
 }
 
 func (c *StackSetController) collectConfigMaps(
-	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
 	stacksets map[types.UID]*core.StackSetContainer,
 ) error {
-	configMaps, err := c.client.CoreV1().ConfigMaps(c.config.Namespace).List(ctx, metav1.ListOptions{})
+	configMaps, err := c.listers.configMaps.ConfigMaps(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list ConfigMaps: %v", err)
 	}
 
-	for _, cm := range configMaps.Items {
-		configMap := cm
+	for _, cm := range configMaps {
+		configMap := cm.DeepCopy()
 		if uid, ok := getOwnerUID(configMap.ObjectMeta); ok {
 			for _, stackset := range stacksets {
 				if s, ok := stackset.StackContainers[uid]; ok {
-					s.Resources.ConfigMaps = append(s.Resources.ConfigMaps, &configMap)
+					s.Resources.ConfigMaps = append(s.Resources.ConfigMaps, configMap)
 					break
 				}
 			}
@@ -517,20 +611,21 @@ func (c *StackSetController) collectConfigMaps(
 }
 
 func (c *StackSetController) collectSecrets(
-	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
 	stacksets map[types.UID]*core.StackSetContainer,
 ) error {
-	secrets, err := c.client.CoreV1().Secrets(c.config.Namespace).List(ctx, metav1.ListOptions{})
+	secrets, err := c.listers.secrets.Secrets(namespace).List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list Secrets: %v", err)
 	}
 
-	for _, sct := range secrets.Items {
-		secret := sct
+	for _, sct := range secrets {
+		secret := sct.DeepCopy()
 		if uid, ok := getOwnerUID(secret.ObjectMeta); ok {
 			for _, stackset := range stacksets {
 				if s, ok := stackset.StackContainers[uid]; ok {
-					s.Resources.Secrets = append(s.Resources.Secrets, &secret)
+					s.Resources.Secrets = append(s.Resources.Secrets, secret)
 					break
 				}
 			}
@@ -540,23 +635,24 @@ func (c *StackSetController) collectSecrets(
 }
 
 func (c *StackSetController) collectPlatformCredentialsSet(
-	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
 	stacksets map[types.UID]*core.StackSetContainer,
 ) error {
-	platformCredentialsSets, err := c.client.ZalandoV1().PlatformCredentialsSets(c.config.Namespace).
-		List(ctx, metav1.ListOptions{})
+	platformCredentialsSets, err := c.listers.platformCredentialsSets.PlatformCredentialsSets(namespace).
+		List(selector)
 	if err != nil {
 		return fmt.Errorf("failed to list PlatformCredentialsSet: %v", err)
 	}
 
-	for _, platformCredentialsSet := range platformCredentialsSets.Items {
-		pcs := platformCredentialsSet
-		if uid, ok := getOwnerUID(platformCredentialsSet.ObjectMeta); ok {
+	for _, platformCredentialsSet := range platformCredentialsSets {
+		pcs := platformCredentialsSet.DeepCopy()
+		if uid, ok := getOwnerUID(pcs.ObjectMeta); ok {
 			for _, stackset := range stacksets {
 				if s, ok := stackset.StackContainers[uid]; ok {
 					s.Resources.PlatformCredentialsSets = append(
 						s.Resources.PlatformCredentialsSets,
-						&pcs,
+						pcs,
 					)
 					break
 				}
@@ -566,6 +662,25 @@ func (c *StackSetController) collectPlatformCredentialsSet(
 	return nil
 }
 
+// heritageSelector selects the resources of the stacksets by their heritage
+// label.
+func heritageSelector(stacksets map[types.UID]*core.StackSetContainer) (labels.Selector, error) {
+	if len(stacksets) == 0 {
+		return labels.Nothing(), nil
+	}
+
+	names := make([]string, 0, len(stacksets))
+	for _, stackset := range stacksets {
+		names = append(names, stackset.StackSet.Name)
+	}
+
+	requirement, err := labels.NewRequirement(core.StacksetHeritageLabelKey, selection.In, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select the resources of the stacksets: %w", err)
+	}
+	return labels.NewSelector().Add(*requirement), nil
+}
+
 func getOwnerUID(objectMeta metav1.ObjectMeta) (types.UID, bool) {
 	if len(objectMeta.OwnerReferences) == 1 {
 		return objectMeta.OwnerReferences[0].UID, true
@@ -592,14 +707,15 @@ func (c *StackSetController) errorEventf(object runtime.Object, reason string, e
 // Whether it's owner is determined by the value of the
 // 'stackset-controller.zalando.org/controller' annotation. If the value
 // matches the controllerID then it owns it, or if the controllerID is
-// "" and there's no annotation set.
+// "" and there's no annotation set. With sharding enabled the stackset must
+// additionally be assigned to this replica.
 func (c *StackSetController) hasOwnership(stackset *zv1.StackSet) bool {
 	if stackset.Annotations != nil {
 		if owner, ok := stackset.Annotations[StacksetControllerControllerAnnotationKey]; ok {
-			return owner == c.config.ControllerID
+			return owner == c.config.ControllerID && c.ownsShard(stackset.UID)
 		}
 	}
-	return c.config.ControllerID == ""
+	return c.config.ControllerID == "" && c.ownsShard(stackset.UID)
 }
 
 func (c *StackSetController) startWatch(ctx context.Context) error {
@@ -619,6 +735,8 @@ func (c *StackSetController) startWatch(ctx context.Context) error {
 		return fmt.Errorf("failed to add event handler: %w", err)
 	}
 
+	c.stacksetCache = informer.GetStore()
+
 	go informer.Run(ctx.Done())
 	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
 		return fmt.Errorf("timed out waiting for caches to sync")
@@ -635,9 +753,7 @@ func (c *StackSetController) add(obj interface{}) {
 	}
 
 	c.logger.Infof("New StackSet added %s/%s", stackset.Namespace, stackset.Name)
-	c.stacksetEvents <- stacksetEvent{
-		StackSet: stackset.DeepCopy(),
-	}
+	c.storeStackSet(stackset.DeepCopy(), false)
 }
 
 func (c *StackSetController) update(oldObj, newObj interface{}) {
@@ -658,22 +774,51 @@ func (c *StackSetController) update(oldObj, newObj interface{}) {
 	)
 
 	c.logger.Infof("StackSet updated %s/%s", newStackset.Namespace, newStackset.Name)
-	c.stacksetEvents <- stacksetEvent{
-		StackSet: newStackset.DeepCopy(),
-	}
+	c.storeStackSet(newStackset.DeepCopy(), false)
 }
 
 func (c *StackSetController) del(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
 	stackset, ok := obj.(*zv1.StackSet)
 	if !ok {
 		return
 	}
 
 	c.logger.Infof("StackSet deleted %s/%s", stackset.Namespace, stackset.Name)
-	c.stacksetEvents <- stacksetEvent{
-		StackSet: stackset.DeepCopy(),
-		Deleted:  true,
+	c.storeStackSet(stackset.DeepCopy(), true)
+}
+
+// storeStackSet adds, updates or removes the stackset in the store of
+// stacksets managed by the controller, and queues it for reconciliation.
+func (c *StackSetController) storeStackSet(stackset *zv1.StackSet, deleted bool) {
+	fixupStackSetTypeMeta(stackset)
+
+	c.Lock()
+	defer c.Unlock()
+
+	// update/delete existing entry
+	if _, ok := c.stacksetStore[stackset.UID]; ok {
+		if deleted || !c.hasOwnership(stackset) {
+			delete(c.stacksetStore, stackset.UID)
+		} else {
+			// update stackset entry
+			c.stacksetStore[stackset.UID] = *stackset
+		}
+		c.queue.Add(stackset.UID)
+		return
 	}
+
+	// check if stackset should be managed by the controller
+	if deleted || !c.hasOwnership(stackset) {
+		return
+	}
+
+	c.logger.Infof("Adding entry for StackSet %s/%s", stackset.Namespace, stackset.Name)
+	c.stacksetStore[stackset.UID] = *stackset
+	c.queue.Add(stackset.UID)
 }
 
 func retryUpdate(updateFn func(retry bool) error) error {
@@ -696,6 +841,7 @@ func (c *StackSetController) ReconcileStatuses(ctx context.Context, ssc *core.St
 	for _, sc := range ssc.StackContainers {
 		stack := sc.Stack.DeepCopy()
 		status := *sc.GenerateStackStatus()
+		status.Conditions = sc.GenerateStackConditions()
 		err := retryUpdate(func(retry bool) error {
 			if retry {
 				updated, err := c.client.ZalandoV1().Stacks(sc.Namespace()).Get(ctx, stack.Name, metav1.GetOptions{})
@@ -706,6 +852,10 @@ func (c *StackSetController) ReconcileStatuses(ctx context.Context, ssc *core.St
 			}
 			if !equality.Semantic.DeepEqual(status, stack.Status) {
 				stack.Status = status
+				if c.planChange(verbUpdateStatus, "Stack", sc.Stack, stack) {
+					return nil
+				}
+
 				_, err := c.client.ZalandoV1().Stacks(sc.Namespace()).UpdateStatus(ctx, stack, metav1.UpdateOptions{})
 				return err
 			}
@@ -718,6 +868,7 @@ func (c *StackSetController) ReconcileStatuses(ctx context.Context, ssc *core.St
 
 	stackset := ssc.StackSet.DeepCopy()
 	status := *ssc.GenerateStackSetStatus()
+	status.Conditions = ssc.GenerateStackSetConditions()
 	err := retryUpdate(func(retry bool) error {
 		if retry {
 			updated, err := c.client.ZalandoV1().StackSets(ssc.StackSet.Namespace).Get(ctx, ssc.StackSet.Name, metav1.GetOptions{})
@@ -728,6 +879,10 @@ func (c *StackSetController) ReconcileStatuses(ctx context.Context, ssc *core.St
 		}
 		if !equality.Semantic.DeepEqual(status, stackset.Status) {
 			stackset.Status = status
+			if c.planChange(verbUpdateStatus, "StackSet", ssc.StackSet, stackset) {
+				return nil
+			}
+
 			_, err := c.client.ZalandoV1().StackSets(ssc.StackSet.Namespace).UpdateStatus(ctx, stackset, metav1.UpdateOptions{})
 			return err
 		}
@@ -770,27 +925,36 @@ func (c *StackSetController) CreateCurrentStack(ctx context.Context, ssc *core.S
 		}
 	}
 
-	created, err := c.client.ZalandoV1().Stacks(newStack.Namespace()).Create(ctx, newStack.Stack, metav1.CreateOptions{})
-	if err != nil {
-		return err
+	// In dry-run mode the resources of the new stack are planned as well
+	created := newStack.Stack
+	if !c.planChange(verbCreate, "Stack", nil, newStack.Stack) {
+		var err error
+		created, err = c.client.ZalandoV1().Stacks(newStack.Namespace()).Create(ctx, newStack.Stack, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+
+		c.recorder.Eventf(
+			ssc.StackSet,
+			v1.EventTypeNormal,
+			"CreatedStack",
+			"Created stack %s",
+			newStack.Name(),
+		)
 	}
 	fixupStackTypeMeta(created)
 
-	c.recorder.Eventf(
-		ssc.StackSet,
-		v1.EventTypeNormal,
-		"CreatedStack",
-		"Created stack %s",
-		newStack.Name(),
-	)
-
 	// Persist ObservedStackVersion in the status
 	updated := ssc.StackSet.DeepCopy()
 	updated.Status.ObservedStackVersion = newStackVersion
 
-	result, err := c.client.ZalandoV1().StackSets(ssc.StackSet.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
-	if err != nil {
-		return err
+	result := updated
+	if !c.planChange(verbUpdateStatus, "StackSet", ssc.StackSet, updated) {
+		var err error
+		result, err = c.client.ZalandoV1().StackSets(ssc.StackSet.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
 	}
 	fixupStackSetTypeMeta(result)
 	ssc.StackSet = result
@@ -811,6 +975,10 @@ func (c *StackSetController) CleanupOldStacks(ctx context.Context, ssc *core.Sta
 		}
 
 		stack := sc.Stack
+		if c.planChange(verbDelete, "Stack", stack, nil) {
+			continue
+		}
+
 		err := c.client.ZalandoV1().Stacks(stack.Namespace).Delete(ctx, stack.Name, metav1.DeleteOptions{})
 		if err != nil {
 			return c.errorEventf(ssc.StackSet, "FailedDeleteStack", err)
@@ -839,6 +1007,10 @@ func (c *StackSetController) AddUpdateStackSetIngress(ctx context.Context, stack
 		}
 		ingress.Annotations[ControllerLastUpdatedAnnotationKey] = c.now()
 
+		if c.planChange(verbCreate, "Ingress", nil, ingress) {
+			return ingress, nil
+		}
+
 		createdIng, err := c.client.NetworkingV1().Ingresses(ingress.Namespace).Create(ctx, ingress, metav1.CreateOptions{})
 		if err != nil {
 			return nil, err
@@ -877,6 +1049,10 @@ func (c *StackSetController) AddUpdateStackSetIngress(ctx context.Context, stack
 
 	updated.Labels = ingress.Labels
 
+	if c.planChange(verbUpdate, "Ingress", existing, updated) {
+		return updated, nil
+	}
+
 	createdIngress, err := c.client.NetworkingV1().Ingresses(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return nil, err
@@ -904,6 +1080,10 @@ func (c *StackSetController) AddUpdateStackSetRouteGroup(ctx context.Context, st
 		}
 		rg.Annotations[ControllerLastUpdatedAnnotationKey] = c.now()
 
+		if c.planChange(verbCreate, "RouteGroup", nil, rg) {
+			return rg, nil
+		}
+
 		createdRg, err := c.client.RouteGroupV1().RouteGroups(rg.Namespace).Create(ctx, rg, metav1.CreateOptions{})
 		if err != nil {
 			return nil, err
@@ -942,6 +1122,10 @@ func (c *StackSetController) AddUpdateStackSetRouteGroup(ctx context.Context, st
 
 	updated.Labels = rg.Labels
 
+	if c.planChange(verbUpdate, "RouteGroup", existing, updated) {
+		return updated, nil
+	}
+
 	createdRg, err := c.client.RouteGroupV1().RouteGroups(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return nil, err
@@ -976,6 +1160,30 @@ func (c *StackSetController) RecordTrafficSwitch(ctx context.Context, ssc *core.
 	return nil
 }
 
+// AnalyzeTraffic analyses the metrics of the newest stack, if configured, and
+// records an event if its traffic was rolled back.
+func (c *StackSetController) AnalyzeTraffic(ctx context.Context, ssc *core.StackSetContainer) error {
+	if c.config.AnalysisProvider == nil {
+		return nil
+	}
+
+	rollback, err := ssc.AnalyzeTraffic(ctx, c.config.AnalysisProvider)
+	if err != nil {
+		return c.errorEventf(ssc.StackSet, "FailedAnalyzeTraffic", err)
+	}
+
+	if rollback != nil {
+		c.recorder.Eventf(
+			ssc.StackSet,
+			v1.EventTypeWarning,
+			"TrafficRolledBack",
+			"Rolled back traffic from %s",
+			rollback.String())
+	}
+
+	return nil
+}
+
 func (c *StackSetController) ReconcileStackSetDesiredTraffic(ctx context.Context, existing *zv1.StackSet, generateUpdated func() []*zv1.DesiredTraffic) error {
 	updatedTraffic := generateUpdated()
 
@@ -986,6 +1194,10 @@ func (c *StackSetController) ReconcileStackSetDesiredTraffic(ctx context.Context
 	updated := existing.DeepCopy()
 	updated.Spec.Traffic = updatedTraffic
 
+	if c.planChange(verbUpdate, "StackSet", existing, updated) {
+		return nil
+	}
+
 	_, err := c.client.ZalandoV1().StackSets(updated.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
 	if err != nil {
 		return err
@@ -1094,6 +1306,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 	// Create current stack, if needed. Proceed on errors.
 	err = c.CreateCurrentStack(ctx, container)
 	if err != nil {
+		container.ReportError("FailedCreateStack", err)
 		err = c.errorEventf(container.StackSet, "FailedCreateStack", err)
 		c.stacksetLogger(container).Errorf("Unable to create stack: %v", err)
 	}
@@ -1104,14 +1317,25 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 		return err
 	}
 
+	// Analyse the newest stack and roll back its traffic if needed. Proceed on errors.
+	err = c.AnalyzeTraffic(ctx, container)
+	if err != nil {
+		c.stacksetLogger(container).Errorf("Unable to analyze traffic: %v", err)
+	}
+
+	// Advance the rollout, if any, before switching traffic.
+	currentTimestamp := time.Now()
+	container.ManageRollout(currentTimestamp)
+
 	// Update the stacks with the currently selected traffic reconciler. Proceed on errors.
-	err = container.ManageTraffic(time.Now())
+	err = container.ManageTraffic(currentTimestamp)
 	if err != nil {
+		container.ReportTrafficError(err)
 		c.stacksetLogger(container).Errorf("Traffic reconciliation failed: %v", err)
 		c.recorder.Eventf(
 			container.StackSet,
 			v1.EventTypeWarning,
-			"TrafficNotSwitched",
+			core.ReasonTrafficNotSwitched,
 			"Failed to switch traffic: "+err.Error())
 	}
 
@@ -1121,6 +1345,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 	// Update traffic segments. Proceed on errors.
 	segsInOrder, err := c.ReconcileTrafficSegments(ctx, container)
 	if err != nil {
+		container.ReportError(reasonFailedManageStackSet, err)
 		err = c.errorEventf(
 			container.StackSet,
 			reasonFailedManageStackSet,
@@ -1139,6 +1364,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 		sc := container.StackContainers[id]
 		err = c.ReconcileStackResources(ctx, container, sc)
 		if err != nil {
+			sc.ReportError("FailedManageStack", err)
 			err = c.errorEventf(sc.Stack, "FailedManageStack", err)
 			c.stackLogger(container, sc).Errorf(
 				"Unable to reconcile stack resources: %v",
@@ -1154,6 +1380,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 
 		err = c.ReconcileStackResources(ctx, container, sc)
 		if err != nil {
+			sc.ReportError("FailedManageStack", err)
 			err = c.errorEventf(sc.Stack, "FailedManageStack", err)
 			c.stackLogger(container, sc).Errorf("Unable to reconcile stack resources: %v", err)
 		}
@@ -1162,6 +1389,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 	// Reconcile stackset resources (update ingress and/or routegroups). Proceed on errors.
 	err = c.RecordTrafficSwitch(ctx, container)
 	if err != nil {
+		container.ReportError(reasonFailedManageStackSet, err)
 		err = c.errorEventf(container.StackSet, reasonFailedManageStackSet, err)
 		c.stacksetLogger(container).Errorf("Unable to reconcile stackset resources: %v", err)
 	}
@@ -1169,6 +1397,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 	// Reconcile desired traffic in the stackset. Proceed on errors.
 	err = c.ReconcileStackSetDesiredTraffic(ctx, container.StackSet, container.GenerateStackSetTraffic)
 	if err != nil {
+		container.ReportError(reasonFailedManageStackSet, err)
 		err = c.errorEventf(container.StackSet, reasonFailedManageStackSet, err)
 		c.stacksetLogger(container).Errorf("Unable to reconcile stackset traffic: %v", err)
 	}
@@ -1176,6 +1405,7 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 	// Delete old stacks. Proceed on errors.
 	err = c.CleanupOldStacks(ctx, container)
 	if err != nil {
+		container.ReportError(reasonFailedManageStackSet, err)
 		err = c.errorEventf(container.StackSet, reasonFailedManageStackSet, err)
 		c.stacksetLogger(container).Errorf("Unable to delete old stacks: %v", err)
 	}
@@ -1189,6 +1419,47 @@ func (c *StackSetController) ReconcileStackSet(ctx context.Context, container *c
 	return nil
 }
 
+// trafficStrategy returns the traffic strategy of the stackset. StackSets
+// without a strategy in their spec are configured by the alpha annotations
+// for prescaling and rate limiting.
+func trafficStrategy(stackset *zv1.StackSet) *zv1.TrafficStrategy {
+	if stackset.Spec.TrafficStrategy != nil {
+		return stackset.Spec.TrafficStrategy
+	}
+
+	strategy := &zv1.TrafficStrategy{}
+
+	// use prescaling logic if enabled with an annotation
+	if _, ok := stackset.Annotations[PrescaleStacksAnnotationKey]; ok {
+		prescaling := zv1.TrafficReconcilerSpec{
+			Type:       core.PrescalingTrafficReconcilerType,
+			Prescaling: &zv1.PrescalingTrafficReconcilerSpec{},
+		}
+		if resetDelay, ok := getResetMinReplicasDelay(stackset.Annotations); ok {
+			prescaling.Prescaling.ResetHPAMinReplicasDelay = &metav1.Duration{Duration: resetDelay}
+		}
+		strategy.Reconcilers = append(strategy.Reconcilers, prescaling)
+	} else {
+		strategy.Reconcilers = append(strategy.Reconcilers, zv1.TrafficReconcilerSpec{
+			Type: core.ReadinessTrafficReconcilerType,
+		})
+	}
+
+	// limit how fast traffic is switched if enabled with an annotation
+	if maxWeightChange, ok := getTrafficRateLimit(stackset.Annotations); ok {
+		rateLimit := zv1.TrafficReconcilerSpec{
+			Type:      core.RateLimitTrafficReconcilerType,
+			RateLimit: &zv1.RateLimitTrafficReconcilerSpec{MaxWeightChange: maxWeightChange},
+		}
+		if interval, ok := getTrafficRateLimitInterval(stackset.Annotations); ok {
+			rateLimit.RateLimit.Interval = &metav1.Duration{Duration: interval}
+		}
+		strategy.Reconcilers = append(strategy.Reconcilers, rateLimit)
+	}
+
+	return strategy
+}
+
 // getResetMinReplicasDelay parses and returns the reset delay if set in the
 // stackset annotation.
 func getResetMinReplicasDelay(annotations map[string]string) (time.Duration, bool) {
@@ -1203,6 +1474,34 @@ func getResetMinReplicasDelay(annotations map[string]string) (time.Duration, boo
 	return resetDelay, true
 }
 
+// getTrafficRateLimit parses and returns the maximum traffic weight change
+// if set in the stackset annotation.
+func getTrafficRateLimit(annotations map[string]string) (float64, bool) {
+	rateLimitStr, ok := annotations[TrafficRateLimitAnnotationKey]
+	if !ok {
+		return 0, false
+	}
+	rateLimit, err := strconv.ParseFloat(rateLimitStr, 64)
+	if err != nil || rateLimit <= 0 {
+		return 0, false
+	}
+	return rateLimit, true
+}
+
+// getTrafficRateLimitInterval parses and returns the traffic rate limit
+// interval if set in the stackset annotation.
+func getTrafficRateLimitInterval(annotations map[string]string) (time.Duration, bool) {
+	intervalStr, ok := annotations[TrafficRateLimitIntervalAnnotationKey]
+	if !ok {
+		return 0, false
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval <= 0 {
+		return 0, false
+	}
+	return interval, true
+}
+
 func fixupStackSetTypeMeta(stackset *zv1.StackSet) {
 	// set TypeMeta manually because of this bug:
 	// https://github.com/kubernetes/client-go/issues/308
@@ -1221,18 +1520,9 @@ func fixupStackTypeMeta(stack *zv1.Stack) {
 // name is not prefixed by Stack name.
 func validateAllConfigurationResourcesNames(stack *zv1.Stack) error {
 	for _, rsc := range stack.Spec.ConfigurationResources {
-		if err := validateConfigurationResourceName(stack.Name, rsc.GetName()); err != nil {
+		if err := core.ValidateConfigurationResourceName(stack.Name, rsc.GetName()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
-
-// validateConfigurationResourceName returns an error if specific resource
-// name is not prefixed by Stack name.
-func validateConfigurationResourceName(stack string, rsc string) error {
-	if !strings.HasPrefix(rsc, stack) {
-		return fmt.Errorf(configurationResourceNameError, rsc, stack)
-	}
-	return nil
-}
@@ -50,12 +50,40 @@ func TestCollectResources(t *testing.T) {
 	testPrescalingStackset.Annotations = map[string]string{PrescaleStacksAnnotationKey: ""}
 
 	testOrphanMeta := stackOwned(testStack("nonexistent", "default", "xxx", zv1.StackSet{}))
-	testUnownedA1Meta := metav1.ObjectMeta{Name: testStackA1.Name, Namespace: testStackA1.Namespace}
-	testUnownedBMeta := metav1.ObjectMeta{Name: testStacksetB.Name, Namespace: testStacksetB.Namespace}
+	testUnknownStackAMeta := stackOwned(testStack("foo-nonexistent", "default", "yyy", testStacksetA))
+	testUnownedA1Meta := metav1.ObjectMeta{Name: testStackA1.Name, Namespace: testStackA1.Namespace, Labels: testStackA1.Labels}
+	testUnownedBMeta := metav1.ObjectMeta{Name: testStacksetB.Name, Namespace: testStacksetB.Namespace, Labels: testStackB1.Labels}
+	testUnlabeledA1Meta := stackOwned(testStackA1)
+	testUnlabeledA1Meta.Labels = nil
 
 	testPrescalingCustomStackset := testStackset("foobaz", "namespace", "789")
 	testPrescalingCustomStackset.Annotations = map[string]string{PrescaleStacksAnnotationKey: "", ResetHPAMinReplicasDelayAnnotationKey: "30s"}
 
+	testRateLimitedStackset := testStackset("qux", "namespace", "321")
+	testRateLimitedStackset.Annotations = map[string]string{TrafficRateLimitAnnotationKey: "10"}
+
+	testRateLimitedPrescalingStackset := testStackset("quux", "namespace", "654")
+	testRateLimitedPrescalingStackset.Annotations = map[string]string{
+		PrescaleStacksAnnotationKey:           "",
+		TrafficRateLimitAnnotationKey:         "20",
+		TrafficRateLimitIntervalAnnotationKey: "30s",
+	}
+
+	testTrafficStrategyStackset := testStackset("corge", "namespace", "987")
+	testTrafficStrategyStackset.Annotations = map[string]string{PrescaleStacksAnnotationKey: ""}
+	testTrafficStrategyStackset.Spec.TrafficStrategy = &zv1.TrafficStrategy{
+		Reconcilers: []zv1.TrafficReconcilerSpec{
+			{
+				Type:      core.RateLimitTrafficReconcilerType,
+				RateLimit: &zv1.RateLimitTrafficReconcilerSpec{MaxWeightChange: 5},
+			},
+			{
+				Type:      core.ReadinessTrafficReconcilerType,
+				Readiness: &zv1.ReadinessTrafficReconcilerSpec{MinReadyPercent: 80},
+			},
+		},
+	}
+
 	for _, tc := range []struct {
 		name        string
 		stacksets   []zv1.StackSet
@@ -75,6 +103,9 @@ func TestCollectResources(t *testing.T) {
 				testStacksetA,
 				testPrescalingStackset,
 				testPrescalingCustomStackset,
+				testRateLimitedStackset,
+				testRateLimitedPrescalingStackset,
+				testTrafficStrategyStackset,
 			},
 			expected: map[types.UID]*core.StackSetContainer{
 				testStacksetA.UID: {
@@ -86,7 +117,7 @@ func TestCollectResources(t *testing.T) {
 					StackSet:        &testPrescalingStackset,
 					StackContainers: map[types.UID]*core.StackContainer{},
 					TrafficReconciler: &core.PrescalingTrafficReconciler{
-						ResetHPAMinReplicasTimeout: defaultResetMinReplicasDelay,
+						ResetHPAMinReplicasTimeout: core.DefaultResetHPAMinReplicasDelay,
 					},
 				},
 				testPrescalingCustomStackset.UID: {
@@ -96,6 +127,41 @@ func TestCollectResources(t *testing.T) {
 						ResetHPAMinReplicasTimeout: 30 * time.Second,
 					},
 				},
+				testRateLimitedStackset.UID: {
+					StackSet:        &testRateLimitedStackset,
+					StackContainers: map[types.UID]*core.StackContainer{},
+					TrafficReconciler: core.TrafficReconcilerChain{
+						&core.SimpleTrafficReconciler{},
+						&core.RateLimitedTrafficReconciler{
+							MaxWeightChange: 10,
+							Interval:        core.DefaultTrafficRateLimitInterval,
+						},
+					},
+				},
+				testRateLimitedPrescalingStackset.UID: {
+					StackSet:        &testRateLimitedPrescalingStackset,
+					StackContainers: map[types.UID]*core.StackContainer{},
+					TrafficReconciler: core.TrafficReconcilerChain{
+						&core.PrescalingTrafficReconciler{
+							ResetHPAMinReplicasTimeout: core.DefaultResetHPAMinReplicasDelay,
+						},
+						&core.RateLimitedTrafficReconciler{
+							MaxWeightChange: 20,
+							Interval:        30 * time.Second,
+						},
+					},
+				},
+				testTrafficStrategyStackset.UID: {
+					StackSet:        &testTrafficStrategyStackset,
+					StackContainers: map[types.UID]*core.StackContainer{},
+					TrafficReconciler: core.TrafficReconcilerChain{
+						&core.RateLimitedTrafficReconciler{
+							MaxWeightChange: 5,
+							Interval:        core.DefaultTrafficRateLimitInterval,
+						},
+						&core.SimpleTrafficReconciler{MinReadyPercent: 80},
+					},
+				},
 			},
 		},
 		{
@@ -179,13 +245,15 @@ func TestCollectResources(t *testing.T) {
 			stacksets: []zv1.StackSet{testStacksetA, testStacksetB},
 			stacks:    []zv1.Stack{testStackA1, testStackA2, testStackB1},
 			deployments: []apps.Deployment{
-				testDeploymentA2,                // stack owned
-				{ObjectMeta: testOrphanMeta},    // owned by unknown stack
-				{ObjectMeta: testUnownedA1Meta}, // same name, but not owned by a stack
+				testDeploymentA2,                    // stack owned
+				{ObjectMeta: testOrphanMeta},        // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta}, // owned by unknown stack of a known stackset
+				{ObjectMeta: testUnownedA1Meta},     // same name, but not owned by a stack
 			},
 			ingresses: []networking.Ingress{
 				{ObjectMeta: stackOwned(testStackA2)},      // stack owned
 				{ObjectMeta: testOrphanMeta},               // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta},        // owned by unknown stack of a known stackset
 				{ObjectMeta: testUnownedA1Meta},            // same name, but not owned by a stack
 				{ObjectMeta: stacksetOwned(testStacksetA)}, // owned by stackset
 				{ObjectMeta: testUnownedBMeta},             // same name, but not owned by a stackset
@@ -193,6 +261,7 @@ func TestCollectResources(t *testing.T) {
 			routegroups: []rgv1.RouteGroup{
 				{ObjectMeta: stackOwned(testStackA2)},      // stack owned
 				{ObjectMeta: testOrphanMeta},               // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta},        // owned by unknown stack of a known stackset
 				{ObjectMeta: testUnownedA1Meta},            // same name, but not owned by a stack
 				{ObjectMeta: stacksetOwned(testStacksetA)}, // owned by stackset
 				{ObjectMeta: testUnownedBMeta},             // same name, but not owned by a stackset
@@ -200,21 +269,25 @@ func TestCollectResources(t *testing.T) {
 			services: []v1.Service{
 				{ObjectMeta: stackOwned(testStackA2)}, // stack owned
 				{ObjectMeta: testOrphanMeta},          // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta},   // owned by unknown stack of a known stackset
 				{ObjectMeta: testUnownedA1Meta},       // same name, but not owned by a stack
 			},
 			hpas: []autoscaling.HorizontalPodAutoscaler{
 				{ObjectMeta: stackOwned(testStackA2)}, // stack owned
 				{ObjectMeta: testOrphanMeta},          // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta},   // owned by unknown stack of a known stackset
 				{ObjectMeta: testUnownedA1Meta},       // same name, but not owned by a stack
 			},
 			configmaps: []v1.ConfigMap{
 				{ObjectMeta: stackOwned(testStackA2)}, // stack owned
 				{ObjectMeta: testOrphanMeta},          // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta},   // owned by unknown stack of a known stackset
 				{ObjectMeta: testUnownedA1Meta},       // same name, but not owned by a stack
 			},
 			secrets: []v1.Secret{
 				{ObjectMeta: stackOwned(testStackA2)}, // stack owned
 				{ObjectMeta: testOrphanMeta},          // owned by unknown stack
+				{ObjectMeta: testUnknownStackAMeta},   // owned by unknown stack of a known stackset
 				{ObjectMeta: testUnownedA1Meta},       // same name, but not owned by a stack
 			},
 			expected: map[types.UID]*core.StackSetContainer{
@@ -252,6 +325,34 @@ func TestCollectResources(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:      "resources without the heritage label are ignored",
+			stacksets: []zv1.StackSet{testStacksetA},
+			stacks: []zv1.Stack{
+				testStackA1,
+				{ObjectMeta: metav1.ObjectMeta{Name: "foo-v3", Namespace: "default", UID: "abc3", OwnerReferences: testStackA1.OwnerReferences}},
+			},
+			deployments: []apps.Deployment{
+				{ObjectMeta: testUnlabeledA1Meta},
+			},
+			ingresses: []networking.Ingress{
+				{ObjectMeta: testUnlabeledA1Meta},
+			},
+			services: []v1.Service{
+				{ObjectMeta: testUnlabeledA1Meta},
+			},
+			expected: map[types.UID]*core.StackSetContainer{
+				testStacksetA.UID: {
+					StackSet: &testStacksetA,
+					StackContainers: map[types.UID]*core.StackContainer{
+						testStackA1.UID: {
+							Stack: &testStackA1,
+						},
+					},
+					TrafficReconciler: &core.SimpleTrafficReconciler{},
+				},
+			},
+		},
 		{
 			name:      "service and HPA owned by the deployment are supported as well",
 			stacksets: []zv1.StackSet{testStacksetA},
@@ -319,7 +420,13 @@ func TestCollectResources(t *testing.T) {
 			err = env.CreateSecrets(context.Background(), tc.secrets)
 			require.NoError(t, err)
 
-			resources, err := env.controller.collectResources(context.Background())
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			err = env.controller.startInformers(ctx)
+			require.NoError(t, err)
+
+			resources, err := env.controller.collectResources(env.controller.config.Namespace, env.controller.stacksetStore)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, resources)
 		})
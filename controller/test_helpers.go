@@ -215,6 +215,7 @@ func testStack(name, namespace string, uid types.UID, ownerStack zv1.StackSet) z
 			Name:      name,
 			Namespace: namespace,
 			UID:       uid,
+			Labels:    map[string]string{core.StacksetHeritageLabelKey: ownerStack.Name},
 			OwnerReferences: []metav1.OwnerReference{
 				{
 					APIVersion: "zalando.org/v1",
@@ -231,6 +232,7 @@ func stackOwned(owner zv1.Stack) metav1.ObjectMeta {
 	return metav1.ObjectMeta{
 		Name:      owner.Name,
 		Namespace: owner.Namespace,
+		Labels:    owner.Labels,
 		OwnerReferences: []metav1.OwnerReference{
 			{
 				APIVersion: "zalando.org/v1",
@@ -253,6 +255,7 @@ func deploymentOwned(owner apps.Deployment) metav1.ObjectMeta {
 	return metav1.ObjectMeta{
 		Name:      owner.Name,
 		Namespace: owner.Namespace,
+		Labels:    owner.Labels,
 		OwnerReferences: []metav1.OwnerReference{
 			{
 				APIVersion: "apps/v1",
@@ -268,6 +271,7 @@ func stacksetOwned(owner zv1.StackSet) metav1.ObjectMeta {
 	return metav1.ObjectMeta{
 		Name:      owner.Name,
 		Namespace: owner.Namespace,
+		Labels:    map[string]string{core.StacksetHeritageLabelKey: owner.Name},
 		OwnerReferences: []metav1.OwnerReference{
 			{
 				APIVersion: "zalando.org/v1",
@@ -0,0 +1,128 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+)
+
+const (
+	prometheusQueryPath = "api/v1/query"
+
+	resultTypeVector = "vector"
+	resultTypeScalar = "scalar"
+)
+
+// PrometheusProvider is an analysis provider querying a Prometheus compatible
+// HTTP API.
+type PrometheusProvider struct {
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// queryResponse is the response of the Prometheus instant query API.
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// sample is a single value of an instant query result, consisting of the
+// timestamp and the value encoded as a string.
+type sample [2]interface{}
+
+// NewPrometheusProvider returns an analysis provider for the Prometheus API
+// at the specified endpoint.
+func NewPrometheusProvider(endpoint *url.URL, timeout time.Duration) *PrometheusProvider {
+	return &PrometheusProvider{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Query evaluates the instant query and returns its value. The query must
+// either return a scalar or a vector with a single element.
+func (p *PrometheusProvider) Query(ctx context.Context, query string) (float64, error) {
+	u := p.endpoint.JoinPath(prometheusQueryPath)
+	u.RawQuery = url.Values{"query": []string{query}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result queryResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode response (status code %d): %w", resp.StatusCode, err)
+	}
+
+	if result.Status != "success" {
+		return 0, fmt.Errorf("query failed (status code %d): %s", resp.StatusCode, result.Error)
+	}
+
+	var value sample
+	switch result.Data.ResultType {
+	case resultTypeScalar:
+		err = json.Unmarshal(result.Data.Result, &value)
+		if err != nil {
+			return 0, err
+		}
+	case resultTypeVector:
+		var vector []struct {
+			Value sample `json:"value"`
+		}
+		err = json.Unmarshal(result.Data.Result, &vector)
+		if err != nil {
+			return 0, err
+		}
+
+		switch len(vector) {
+		case 0:
+			return 0, core.ErrNoAnalysisData
+		case 1:
+			value = vector[0].Value
+		default:
+			return 0, fmt.Errorf("query returned %d series, expected a single one", len(vector))
+		}
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", result.Data.ResultType)
+	}
+
+	return parseSampleValue(value)
+}
+
+// parseSampleValue parses the value of a sample. NaN values, e.g. caused by
+// dividing by a rate of zero requests, are reported as missing data.
+func parseSampleValue(value sample) (float64, error) {
+	str, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid sample value %v", value[1])
+	}
+
+	result, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample value %q: %w", str, err)
+	}
+
+	if math.IsNaN(result) {
+		return 0, core.ErrNoAnalysisData
+	}
+	return result, nil
+}
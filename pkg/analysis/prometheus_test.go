@@ -0,0 +1,101 @@
+package analysis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+)
+
+func TestPrometheusProviderQuery(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		statusCode    int
+		response      string
+		expected      float64
+		expectedError error
+		expectError   bool
+	}{
+		{
+			name:       "vector",
+			statusCode: http.StatusOK,
+			response:   `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000.123,"0.05"]}]}}`,
+			expected:   0.05,
+		},
+		{
+			name:       "scalar",
+			statusCode: http.StatusOK,
+			response:   `{"status":"success","data":{"resultType":"scalar","result":[1700000000.123,"1.5"]}}`,
+			expected:   1.5,
+		},
+		{
+			name:          "empty vector",
+			statusCode:    http.StatusOK,
+			response:      `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			expectedError: core.ErrNoAnalysisData,
+		},
+		{
+			name:          "NaN",
+			statusCode:    http.StatusOK,
+			response:      `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000.123,"NaN"]}]}}`,
+			expectedError: core.ErrNoAnalysisData,
+		},
+		{
+			name:        "multiple series",
+			statusCode:  http.StatusOK,
+			response:    `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"a":"1"},"value":[1,"1"]},{"metric":{"a":"2"},"value":[1,"2"]}]}}`,
+			expectError: true,
+		},
+		{
+			name:        "unsupported result type",
+			statusCode:  http.StatusOK,
+			response:    `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			expectError: true,
+		},
+		{
+			name:        "query error",
+			statusCode:  http.StatusBadRequest,
+			response:    `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid response",
+			statusCode:  http.StatusInternalServerError,
+			response:    `internal server error`,
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/prometheus/api/v1/query", r.URL.Path)
+				query = r.URL.Query().Get("query")
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(tc.response))
+			}))
+			defer server.Close()
+
+			endpoint, err := url.Parse(server.URL + "/prometheus")
+			require.NoError(t, err)
+
+			provider := NewPrometheusProvider(endpoint, time.Second)
+			value, err := provider.Query(context.Background(), `sum(rate(errors{stack="foo-v1"}[1m]))`)
+			require.Equal(t, `sum(rate(errors{stack="foo-v1"}[1m]))`, query)
+
+			switch {
+			case tc.expectedError != nil:
+				require.ErrorIs(t, err, tc.expectedError)
+			case tc.expectError:
+				require.Error(t, err)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
@@ -63,6 +63,169 @@ type StackSetSpec struct {
 	// minReadyPercent sets the minimum percentage of Pods expected
 	// to be Ready to consider a Stack for traffic switch
 	MinReadyPercent int `json:"minReadyPercent,omitempty"`
+	// Rollout defines a plan for progressively shifting traffic to the
+	// newest Stack of the StackSet. While a rollout is in progress the
+	// controller manages the desired traffic of the StackSet.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+	// Analysis defines metrics used to analyse the newest Stack while
+	// traffic is switched to it. If any of the metrics exceeds its
+	// threshold the traffic is rolled back to the previous Stack.
+	// +optional
+	Analysis *AnalysisSpec `json:"analysis,omitempty"`
+	// TrafficStrategy defines how traffic is switched between the Stacks
+	// of the StackSet. Defaults to switching traffic to Stacks as soon as
+	// they are ready.
+	// +optional
+	TrafficStrategy *TrafficStrategy `json:"trafficStrategy,omitempty"`
+	// BlueGreen enables blue/green deployments. New Stacks don't get any
+	// traffic until they are promoted, which switches all traffic to them
+	// at once. The traffic, rollout and analysis of the StackSet are
+	// managed by the controller in this mode.
+	// +optional
+	BlueGreen *BlueGreenSpec `json:"blueGreen,omitempty"`
+}
+
+// BlueGreenSpec defines the blue/green deployment of a StackSet.
+// +k8s:deepcopy-gen=true
+type BlueGreenSpec struct {
+	// Promote is the name of the Stack to switch all traffic to. The
+	// traffic is switched as soon as the Stack is ready.
+	// +optional
+	Promote string `json:"promote,omitempty"`
+	// ConfirmationWindow is how long the previously live Stack is kept
+	// scaled up after a promotion, so that it can be promoted again.
+	// Defaults to 1h.
+	// +optional
+	ConfirmationWindow *metav1.Duration `json:"confirmationWindow,omitempty"`
+}
+
+// TrafficStrategy defines the chain of traffic reconcilers used for
+// switching traffic between the Stacks of a StackSet.
+// +k8s:deepcopy-gen=true
+type TrafficStrategy struct {
+	// Reconcilers is the ordered chain of traffic reconcilers. The traffic
+	// weights computed by a reconciler are the desired traffic weights of
+	// the next one.
+	// +kubebuilder:validation:MinItems=1
+	Reconcilers []TrafficReconcilerSpec `json:"reconcilers"`
+}
+
+// TrafficReconcilerSpec defines a single traffic reconciler of a
+// TrafficStrategy.
+// +k8s:deepcopy-gen=true
+type TrafficReconcilerSpec struct {
+	// Type is the type of the traffic reconciler. The built-in types are
+	// Readiness, Prescaling and RateLimit.
+	Type string `json:"type"`
+	// Readiness configures the Readiness traffic reconciler.
+	// +optional
+	Readiness *ReadinessTrafficReconcilerSpec `json:"readiness,omitempty"`
+	// Prescaling configures the Prescaling traffic reconciler.
+	// +optional
+	Prescaling *PrescalingTrafficReconcilerSpec `json:"prescaling,omitempty"`
+	// RateLimit configures the RateLimit traffic reconciler.
+	// +optional
+	RateLimit *RateLimitTrafficReconcilerSpec `json:"rateLimit,omitempty"`
+}
+
+// ReadinessTrafficReconcilerSpec configures the traffic reconciler which only
+// switches traffic to ready Stacks.
+// +k8s:deepcopy-gen=true
+type ReadinessTrafficReconcilerSpec struct {
+	// MinReadyPercent is the minimum percentage of Pods expected to be
+	// Ready to consider a Stack for traffic switch. Overrides the
+	// minReadyPercent of the StackSet.
+	// +optional
+	MinReadyPercent int `json:"minReadyPercent,omitempty"`
+}
+
+// PrescalingTrafficReconcilerSpec configures the traffic reconciler which
+// scales up Stacks before switching traffic to them.
+// +k8s:deepcopy-gen=true
+type PrescalingTrafficReconcilerSpec struct {
+	// ResetHPAMinReplicasDelay is the time after the last traffic
+	// increase when the minReplicas of the HPA of a prescaled Stack is
+	// reset. Defaults to 10 minutes.
+	// +optional
+	ResetHPAMinReplicasDelay *metav1.Duration `json:"resetHPAMinReplicasDelay,omitempty"`
+}
+
+// RateLimitTrafficReconcilerSpec configures the traffic reconciler which
+// limits how fast traffic is switched.
+// +k8s:deepcopy-gen=true
+type RateLimitTrafficReconcilerSpec struct {
+	// MaxWeightChange is the maximum change of the traffic weight of a
+	// Stack in percentage points per interval.
+	// +kubebuilder:validation:Type=number
+	// +kubebuilder:validation:Format=float
+	MaxWeightChange float64 `json:"maxWeightChange"`
+	// Interval is the minimum time between two traffic switches. Defaults
+	// to 1 minute.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// AnalysisSpec defines the metric based analysis of the newest Stack of a
+// StackSet.
+// +k8s:deepcopy-gen=true
+type AnalysisSpec struct {
+	// Metrics is the list of metrics to analyse.
+	// +kubebuilder:validation:MinItems=1
+	Metrics []AnalysisMetric `json:"metrics"`
+}
+
+// AnalysisMetric is a metric queried from the analysis provider configured
+// for the controller, e.g. Prometheus.
+// +k8s:deepcopy-gen=true
+type AnalysisMetric struct {
+	// Name is the name of the metric, used for reporting.
+	Name string `json:"name"`
+	// Query is the query returning a single value for the analysed
+	// Stack. It's a Go template which can refer to {{ .Namespace }},
+	// {{ .StackSet }} and {{ .Stack }}.
+	Query string `json:"query"`
+	// Max is the maximum value of the metric which is considered
+	// healthy.
+	// +kubebuilder:validation:Type=number
+	// +kubebuilder:validation:Format=float
+	Max float64 `json:"max"`
+}
+
+// RolloutSpec defines a stepwise traffic rollout to the newest Stack of a
+// StackSet.
+// +k8s:deepcopy-gen=true
+type RolloutSpec struct {
+	// Steps is the ordered list of traffic weights the newest Stack is
+	// moved through. The remaining traffic is distributed between the
+	// other Stacks proportionally to their current weights.
+	// +kubebuilder:validation:MinItems=1
+	Steps []RolloutStep `json:"steps"`
+	// Paused stops the rollout from advancing to the next step. The
+	// traffic of the current step is kept.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+	// Aborted moves all traffic away from the newest Stack. Setting it
+	// back to false restarts an aborted or rolled back rollout from the
+	// first step.
+	// +optional
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+// RolloutStep is a single step of a rollout.
+// +k8s:deepcopy-gen=true
+type RolloutStep struct {
+	// Weight is the desired traffic weight of the newest Stack for this
+	// step.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:validation:Type=number
+	// +kubebuilder:validation:Format=float
+	Weight float64 `json:"weight"`
+	// Pause is the time to wait after the actual traffic reached the
+	// weight of the step before advancing to the next step.
+	// +optional
+	Pause *metav1.Duration `json:"pause,omitempty"`
 }
 
 // EmbeddedObjectMetaWithAnnotations defines the metadata which can be attached
@@ -147,6 +310,50 @@ type RouteGroupSpec struct {
 	// The load balancing algorithm used for the generated per stack backends.
 	// +optional
 	LBAlgorithm rg.BackendAlgorithmType `json:"lbAlgorithm,omitempty"`
+	// CanaryRoutes routes requests with a matching header or cookie to a
+	// specific Stack, independent of the traffic weights.
+	// +optional
+	CanaryRoutes []CanaryRoute `json:"canaryRoutes,omitempty"`
+	// StickyTraffic makes the Stack serving a request depend on the value
+	// of a header or cookie instead of being random, so that requests with
+	// the same value are served by the same Stack while switching traffic.
+	// +optional
+	StickyTraffic *StickyTrafficSpec `json:"stickyTraffic,omitempty"`
+}
+
+// StickyTrafficSpec defines the header or cookie used for assigning requests
+// to the traffic segments of the Stacks. The value is hashed, so any value
+// identifying a user, e.g. a user id, can be used.
+// +k8s:deepcopy-gen=true
+type StickyTrafficSpec struct {
+	// Header is the name of the header used for assigning requests.
+	// +optional
+	Header string `json:"header,omitempty"`
+	// Cookie is the name of the cookie used for assigning requests.
+	// +optional
+	Cookie string `json:"cookie,omitempty"`
+}
+
+// CanaryRoute routes the requests matching a header and/or cookie to a Stack.
+// +k8s:deepcopy-gen=true
+type CanaryRoute struct {
+	// StackName is the name of the Stack receiving the matching requests.
+	StackName string `json:"stackName"`
+	// Header matches requests by the value of an HTTP header.
+	// +optional
+	Header *CanaryMatch `json:"header,omitempty"`
+	// Cookie matches requests by the value of a cookie.
+	// +optional
+	Cookie *CanaryMatch `json:"cookie,omitempty"`
+}
+
+// CanaryMatch matches requests with the exact value of a header or cookie.
+// +k8s:deepcopy-gen=true
+type CanaryMatch struct {
+	// Name is the name of the header or cookie.
+	Name string `json:"name"`
+	// Value is the value of the header or cookie.
+	Value string `json:"value"`
 }
 
 func (s *RouteGroupSpec) GetHosts() []string {
@@ -171,6 +378,23 @@ type StackLifecycle struct {
 	// not getting traffic are deleted.
 	// +kubebuilder:validation:Minimum=1
 	Limit *int32 `json:"limit,omitempty"`
+	// Pinned is a list of Stacks which are never deleted and are kept at
+	// a minimum number of replicas while they are not getting traffic,
+	// e.g. the last known good Stack to roll back to.
+	// +optional
+	Pinned []PinnedStack `json:"pinned,omitempty"`
+}
+
+// PinnedStack is a Stack protected from being deleted and scaled down.
+// +k8s:deepcopy-gen=true
+type PinnedStack struct {
+	// StackName is the name of the pinned Stack.
+	StackName string `json:"stackName"`
+	// MinReplicas is the number of replicas the Stack is scaled down to
+	// when it isn't getting traffic. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
 }
 
 // StackTemplate defines the template used for the Stack created from a
@@ -334,6 +558,94 @@ type StackSetStatus struct {
 	// Traffic is the actual traffic setting on services for this stackset
 	// +optional
 	Traffic []*ActualTraffic `json:"traffic,omitempty"`
+	// Rollout is the state of the rollout defined in the StackSet spec.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// ScheduledTraffic is the traffic of the scheduled switches which
+	// haven't been applied yet, ordered by time.
+	// +optional
+	ScheduledTraffic []*DesiredTraffic `json:"scheduledTraffic,omitempty"`
+	// BlueGreen is the state of the blue/green deployment defined in the
+	// StackSet spec.
+	// +optional
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+	// ObservedGeneration is the generation of the StackSet which was
+	// last reconciled successfully.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the latest observations of the state of the
+	// StackSet.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// BlueGreenStatus is the status of a blue/green deployment.
+// +k8s:deepcopy-gen=true
+type BlueGreenStatus struct {
+	// LiveStack is the name of the Stack getting all traffic.
+	// +optional
+	LiveStack string `json:"liveStack,omitempty"`
+	// CandidateStack is the name of the newest Stack, which is waiting to
+	// be promoted.
+	// +optional
+	CandidateStack string `json:"candidateStack,omitempty"`
+	// PreviousLiveStack is the name of the Stack which was live before
+	// the last promotion. It's kept until the confirmation window passed.
+	// +optional
+	PreviousLiveStack string `json:"previousLiveStack,omitempty"`
+	// PromotedAt is the time of the last promotion.
+	// +optional
+	PromotedAt *metav1.Time `json:"promotedAt,omitempty"`
+}
+
+// RolloutPhase is the phase of a rollout.
+// +kubebuilder:validation:Enum=Progressing;Paused;Aborted;RolledBack;Completed
+type RolloutPhase string
+
+const (
+	RolloutPhaseProgressing RolloutPhase = "Progressing"
+	RolloutPhasePaused      RolloutPhase = "Paused"
+	RolloutPhaseAborted     RolloutPhase = "Aborted"
+	RolloutPhaseRolledBack  RolloutPhase = "RolledBack"
+	RolloutPhaseCompleted   RolloutPhase = "Completed"
+)
+
+// Types of the conditions of StackSets and Stacks.
+const (
+	// ConditionReady is true if the resources are reconciled, the traffic
+	// is switched and all stacks getting traffic are ready.
+	ConditionReady = "Ready"
+	// ConditionTrafficSwitched is true if the actual traffic matches the
+	// desired traffic.
+	ConditionTrafficSwitched = "TrafficSwitched"
+	// ConditionProgressing is true while traffic is switched, a rollout is
+	// progressing or the pods of a stack are updated.
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded is true if stacks getting traffic aren't ready or
+	// traffic was rolled back.
+	ConditionDegraded = "Degraded"
+	// ConditionResourcesReconciled is true if all resources were
+	// reconciled successfully.
+	ConditionResourcesReconciled = "ResourcesReconciled"
+)
+
+// RolloutStatus is the status of a rollout.
+// +k8s:deepcopy-gen=true
+type RolloutStatus struct {
+	// StackName is the name of the Stack being rolled out.
+	StackName string `json:"stackName"`
+	// Step is the index of the current step of the rollout.
+	Step int32 `json:"step"`
+	// StepReachedAt is the time when the actual traffic of the Stack
+	// reached the weight of the current step.
+	// +optional
+	StepReachedAt *metav1.Time `json:"stepReachedAt,omitempty"`
+	// Phase is the current phase of the rollout.
+	Phase RolloutPhase `json:"phase"`
 }
 
 // Traffic is the actual traffic setting on services for this
@@ -352,11 +664,28 @@ type ActualTraffic struct {
 // DesiredTraffic is the desired traffic setting to direct traffic to
 // a stack. This is meant to use by clients to orchestrate traffic
 // switching.
+// +k8s:deepcopy-gen=true
 type DesiredTraffic struct {
 	StackName string `json:"stackName"`
 	// +kubebuilder:validation:Type=number
 	// +kubebuilder:validation:Format=float
 	Weight float64 `json:"weight"`
+	// Mirror is the percentage of the live requests copied to the stack
+	// while it doesn't get any traffic. The responses of the stack are
+	// discarded. The stack isn't scaled down while it's mirrored to.
+	// Mirroring requires a RouteGroup.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:validation:Type=number
+	// +kubebuilder:validation:Format=float
+	// +optional
+	Mirror float64 `json:"mirror,omitempty"`
+	// NotBefore schedules the traffic switch. Entries with the same
+	// timestamp form a switch which replaces the desired traffic of all
+	// stacks once the time has passed. Removing the entries cancels the
+	// switch.
+	// +optional
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -587,9 +916,28 @@ type StackStatus struct {
 	// NoTrafficSince is the timestamp defining the last time the stack was
 	// observed getting traffic.
 	NoTrafficSince *metav1.Time `json:"noTrafficSince,omitempty"`
+	// LastTrafficSwitch is the timestamp defining the last time the actual
+	// traffic weight of the stack was changed.
+	// +optional
+	LastTrafficSwitch *metav1.Time `json:"lastTrafficSwitch,omitempty"`
 	// LabelSelector is the label selector used to find all pods managed by
 	// a stack.
 	LabelSelector string `json:"labelSelector,omitempty"`
+	// Pinned is true if the stack is pinned in the lifecycle of the
+	// StackSet, and thus never deleted.
+	// +optional
+	Pinned bool `json:"pinned,omitempty"`
+	// ObservedGeneration is the generation of the stack which was last
+	// reconciled successfully.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the latest observations of the state of the stack.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // Prescaling hold prescaling information
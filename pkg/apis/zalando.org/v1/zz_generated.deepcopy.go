@@ -26,9 +26,47 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	v2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnalysisMetric) DeepCopyInto(out *AnalysisMetric) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnalysisMetric.
+func (in *AnalysisMetric) DeepCopy() *AnalysisMetric {
+	if in == nil {
+		return nil
+	}
+	out := new(AnalysisMetric)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnalysisSpec) DeepCopyInto(out *AnalysisSpec) {
+	*out = *in
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]AnalysisMetric, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnalysisSpec.
+func (in *AnalysisSpec) DeepCopy() *AnalysisSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AnalysisSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Autoscaler) DeepCopyInto(out *Autoscaler) {
 	*out = *in
@@ -118,6 +156,89 @@ func (in *AutoscalerMetrics) DeepCopy() *AutoscalerMetrics {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlueGreenSpec) DeepCopyInto(out *BlueGreenSpec) {
+	*out = *in
+	if in.ConfirmationWindow != nil {
+		in, out := &in.ConfirmationWindow, &out.ConfirmationWindow
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueGreenSpec.
+func (in *BlueGreenSpec) DeepCopy() *BlueGreenSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BlueGreenSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlueGreenStatus) DeepCopyInto(out *BlueGreenStatus) {
+	*out = *in
+	if in.PromotedAt != nil {
+		in, out := &in.PromotedAt, &out.PromotedAt
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueGreenStatus.
+func (in *BlueGreenStatus) DeepCopy() *BlueGreenStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BlueGreenStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryMatch) DeepCopyInto(out *CanaryMatch) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryMatch.
+func (in *CanaryMatch) DeepCopy() *CanaryMatch {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryMatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryRoute) DeepCopyInto(out *CanaryRoute) {
+	*out = *in
+	if in.Header != nil {
+		in, out := &in.Header, &out.Header
+		*out = new(CanaryMatch)
+		**out = **in
+	}
+	if in.Cookie != nil {
+		in, out := &in.Cookie, &out.Cookie
+		*out = new(CanaryMatch)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryRoute.
+func (in *CanaryRoute) DeepCopy() *CanaryRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Client) DeepCopyInto(out *Client) {
 	*out = *in
@@ -209,6 +330,26 @@ func (in *ConfigurationResourcesSpec) DeepCopy() *ConfigurationResourcesSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DesiredTraffic) DeepCopyInto(out *DesiredTraffic) {
+	*out = *in
+	if in.NotBefore != nil {
+		in, out := &in.NotBefore, &out.NotBefore
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DesiredTraffic.
+func (in *DesiredTraffic) DeepCopy() *DesiredTraffic {
+	if in == nil {
+		return nil
+	}
+	out := new(DesiredTraffic)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EmbeddedObjectMeta) DeepCopyInto(out *EmbeddedObjectMeta) {
 	*out = *in
@@ -398,6 +539,27 @@ func (in *PCS) DeepCopy() *PCS {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PinnedStack) DeepCopyInto(out *PinnedStack) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PinnedStack.
+func (in *PinnedStack) DeepCopy() *PinnedStack {
+	if in == nil {
+		return nil
+	}
+	out := new(PinnedStack)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PlatformCredentialsSet) DeepCopyInto(out *PlatformCredentialsSet) {
 	*out = *in
@@ -567,6 +729,128 @@ func (in *PrescalingStatus) DeepCopy() *PrescalingStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrescalingTrafficReconcilerSpec) DeepCopyInto(out *PrescalingTrafficReconcilerSpec) {
+	*out = *in
+	if in.ResetHPAMinReplicasDelay != nil {
+		in, out := &in.ResetHPAMinReplicasDelay, &out.ResetHPAMinReplicasDelay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrescalingTrafficReconcilerSpec.
+func (in *PrescalingTrafficReconcilerSpec) DeepCopy() *PrescalingTrafficReconcilerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PrescalingTrafficReconcilerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitTrafficReconcilerSpec) DeepCopyInto(out *RateLimitTrafficReconcilerSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitTrafficReconcilerSpec.
+func (in *RateLimitTrafficReconcilerSpec) DeepCopy() *RateLimitTrafficReconcilerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitTrafficReconcilerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReadinessTrafficReconcilerSpec) DeepCopyInto(out *ReadinessTrafficReconcilerSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReadinessTrafficReconcilerSpec.
+func (in *ReadinessTrafficReconcilerSpec) DeepCopy() *ReadinessTrafficReconcilerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReadinessTrafficReconcilerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutSpec) DeepCopyInto(out *RolloutSpec) {
+	*out = *in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]RolloutStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutSpec.
+func (in *RolloutSpec) DeepCopy() *RolloutSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutStatus) DeepCopyInto(out *RolloutStatus) {
+	*out = *in
+	if in.StepReachedAt != nil {
+		in, out := &in.StepReachedAt, &out.StepReachedAt
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStatus.
+func (in *RolloutStatus) DeepCopy() *RolloutStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutStep) DeepCopyInto(out *RolloutStep) {
+	*out = *in
+	if in.Pause != nil {
+		in, out := &in.Pause, &out.Pause
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStep.
+func (in *RolloutStep) DeepCopy() *RolloutStep {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RouteGroupSpec) DeepCopyInto(out *RouteGroupSpec) {
 	*out = *in
@@ -590,6 +874,18 @@ func (in *RouteGroupSpec) DeepCopyInto(out *RouteGroupSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.CanaryRoutes != nil {
+		in, out := &in.CanaryRoutes, &out.CanaryRoutes
+		*out = make([]CanaryRoute, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StickyTraffic != nil {
+		in, out := &in.StickyTraffic, &out.StickyTraffic
+		*out = new(StickyTrafficSpec)
+		**out = **in
+	}
 	return
 }
 
@@ -644,6 +940,13 @@ func (in *StackLifecycle) DeepCopyInto(out *StackLifecycle) {
 		*out = new(int32)
 		**out = **in
 	}
+	if in.Pinned != nil {
+		in, out := &in.Pinned, &out.Pinned
+		*out = make([]PinnedStack, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -842,10 +1145,30 @@ func (in *StackSetSpec) DeepCopyInto(out *StackSetSpec) {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
 				*out = new(DesiredTraffic)
-				**out = **in
+				(*in).DeepCopyInto(*out)
 			}
 		}
 	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Analysis != nil {
+		in, out := &in.Analysis, &out.Analysis
+		*out = new(AnalysisSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TrafficStrategy != nil {
+		in, out := &in.TrafficStrategy, &out.TrafficStrategy
+		*out = new(TrafficStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BlueGreen != nil {
+		in, out := &in.BlueGreen, &out.BlueGreen
+		*out = new(BlueGreenSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -873,6 +1196,34 @@ func (in *StackSetStatus) DeepCopyInto(out *StackSetStatus) {
 			}
 		}
 	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScheduledTraffic != nil {
+		in, out := &in.ScheduledTraffic, &out.ScheduledTraffic
+		*out = make([]*DesiredTraffic, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(DesiredTraffic)
+				(*in).DeepCopyInto(*out)
+			}
+		}
+	}
+	if in.BlueGreen != nil {
+		in, out := &in.BlueGreen, &out.BlueGreen
+		*out = new(BlueGreenStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -987,6 +1338,17 @@ func (in *StackStatus) DeepCopyInto(out *StackStatus) {
 		in, out := &in.NoTrafficSince, &out.NoTrafficSince
 		*out = (*in).DeepCopy()
 	}
+	if in.LastTrafficSwitch != nil {
+		in, out := &in.LastTrafficSwitch, &out.LastTrafficSwitch
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -1018,6 +1380,22 @@ func (in *StackTemplate) DeepCopy() *StackTemplate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StickyTrafficSpec) DeepCopyInto(out *StickyTrafficSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StickyTrafficSpec.
+func (in *StickyTrafficSpec) DeepCopy() *StickyTrafficSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StickyTrafficSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Token) DeepCopyInto(out *Token) {
 	*out = *in
@@ -1058,3 +1436,57 @@ func (in *TokenStatus) DeepCopy() *TokenStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficReconcilerSpec) DeepCopyInto(out *TrafficReconcilerSpec) {
+	*out = *in
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(ReadinessTrafficReconcilerSpec)
+		**out = **in
+	}
+	if in.Prescaling != nil {
+		in, out := &in.Prescaling, &out.Prescaling
+		*out = new(PrescalingTrafficReconcilerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(RateLimitTrafficReconcilerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficReconcilerSpec.
+func (in *TrafficReconcilerSpec) DeepCopy() *TrafficReconcilerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficReconcilerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficStrategy) DeepCopyInto(out *TrafficStrategy) {
+	*out = *in
+	if in.Reconcilers != nil {
+		in, out := &in.Reconcilers, &out.Reconcilers
+		*out = make([]TrafficReconcilerSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficStrategy.
+func (in *TrafficStrategy) DeepCopy() *TrafficStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"text/template"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+// ErrNoAnalysisData is returned by an AnalysisProvider if a query didn't
+// return any data, e.g. because the analysed stack didn't get any requests
+// yet.
+var ErrNoAnalysisData = errors.New("query returned no data")
+
+// AnalysisProvider queries the metrics used to analyse stacks getting
+// traffic.
+type AnalysisProvider interface {
+	// Query evaluates the query and returns its single value.
+	Query(ctx context.Context, query string) (float64, error)
+}
+
+// TrafficRollback contains information about a traffic rollback caused by a
+// metric exceeding its threshold.
+type TrafficRollback struct {
+	StackName         string
+	PreviousStackName string
+	Metric            string
+	Value             float64
+	Max               float64
+}
+
+func (tr TrafficRollback) String() string {
+	return fmt.Sprintf("%s to %s: %s is %g, exceeding %g", tr.StackName, tr.PreviousStackName, tr.Metric, tr.Value, tr.Max)
+}
+
+// analysisQueryData holds the values which can be referenced from analysis
+// query templates.
+type analysisQueryData struct {
+	Namespace string
+	StackSet  string
+	Stack     string
+}
+
+// AnalyzeTraffic analyses the newest stack, while traffic is switched to it,
+// using the metrics defined in the StackSet. If any of the metrics exceeds its threshold
+// the desired traffic is moved back to the previous stack and the rollback is
+// returned. It must be called before ManageRollout and ManageTraffic.
+func (ssc *StackSetContainer) AnalyzeTraffic(ctx context.Context, provider AnalysisProvider) (*TrafficRollback, error) {
+	analysis := ssc.StackSet.Spec.Analysis
+	if analysis == nil || ssc.StackSet.Spec.BlueGreen != nil {
+		return nil, nil
+	}
+
+	candidate := ssc.analysisCandidate()
+	if candidate == nil {
+		return nil, nil
+	}
+
+	data := analysisQueryData{
+		Namespace: ssc.StackSet.Namespace,
+		StackSet:  ssc.StackSet.Name,
+		Stack:     candidate.Name(),
+	}
+
+	for _, metric := range analysis.Metrics {
+		query, err := renderAnalysisQuery(metric, data)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := provider.Query(ctx, query)
+		if err != nil {
+			if errors.Is(err, ErrNoAnalysisData) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to query metric %s: %w", metric.Name, err)
+		}
+
+		if value > metric.Max {
+			previous := ssc.rollbackTraffic(candidate)
+			return &TrafficRollback{
+				StackName:         candidate.Name(),
+				PreviousStackName: previous.Name(),
+				Metric:            metric.Name,
+				Value:             value,
+				Max:               metric.Max,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// renderAnalysisQuery renders the query template of the metric.
+func renderAnalysisQuery(metric zv1.AnalysisMetric, data analysisQueryData) (string, error) {
+	tmpl, err := template.New(metric.Name).Option("missingkey=error").Parse(metric.Query)
+	if err != nil {
+		return "", fmt.Errorf("invalid query for metric %s: %w", metric.Name, err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("invalid query for metric %s: %w", metric.Name, err)
+	}
+	return buf.String(), nil
+}
+
+// analysisCandidate returns the stack that should be analysed. That's the
+// newest stack, if it's getting traffic while being rolled out and there's
+// another stack the traffic can be rolled back to.
+func (ssc *StackSetContainer) analysisCandidate() *StackContainer {
+	candidate := ssc.newestStack()
+	if candidate == nil || candidate.desiredTrafficWeight == 0 || candidate.actualTrafficWeight == 0 {
+		return nil
+	}
+
+	if !ssc.rollingOut(candidate) {
+		return nil
+	}
+
+	for _, sc := range ssc.StackContainers {
+		if sc != candidate && !sc.PendingRemoval {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// rollingOut returns true if the traffic is still being switched to the
+// stack, i.e. a rollout of the stack is in progress or it doesn't get all the
+// traffic yet. Once it got all the traffic the previous stacks are scaled
+// down, so the traffic isn't rolled back anymore.
+func (ssc *StackSetContainer) rollingOut(sc *StackContainer) bool {
+	rollout := ssc.StackSet.Status.Rollout
+	if ssc.StackSet.Spec.Rollout != nil && rollout != nil && rollout.StackName == sc.Name() &&
+		(rollout.Phase == zv1.RolloutPhaseProgressing || rollout.Phase == zv1.RolloutPhasePaused) {
+		return true
+	}
+	return sc.desiredTrafficWeight < 100 || sc.actualTrafficWeight < 100
+}
+
+// rollbackTraffic moves all desired traffic from the specified stack to the
+// previous stack and returns it. The previous stack is the one getting most of
+// the remaining actual traffic or, if there's none, the one that most
+// recently got traffic.
+func (ssc *StackSetContainer) rollbackTraffic(stack *StackContainer) *StackContainer {
+	others := make(map[string]*StackContainer)
+	var previous *StackContainer
+	for _, sc := range ssc.StackContainers {
+		if sc == stack || sc.PendingRemoval {
+			continue
+		}
+		others[sc.Name()] = sc
+
+		if sc.actualTrafficWeight == 0 {
+			continue
+		}
+		if previous == nil || sc.actualTrafficWeight > previous.actualTrafficWeight ||
+			(sc.actualTrafficWeight == previous.actualTrafficWeight && sc.Name() < previous.Name()) {
+			previous = sc
+		}
+	}
+
+	if previous == nil {
+		previous = findFallbackStack(others)
+	}
+
+	for _, sc := range ssc.StackContainers {
+		sc.desiredTrafficWeight = 0
+	}
+	previous.desiredTrafficWeight = 100
+	ssc.rolledBackStack = stack.Name()
+	stack.trafficRolledBack = true
+
+	return previous
+}
@@ -0,0 +1,420 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeAnalysisProvider struct {
+	values  map[string]float64
+	err     error
+	queries []string
+}
+
+func (p *fakeAnalysisProvider) Query(_ context.Context, query string) (float64, error) {
+	p.queries = append(p.queries, query)
+	if p.err != nil {
+		return 0, p.err
+	}
+	value, ok := p.values[query]
+	if !ok {
+		return 0, ErrNoAnalysisData
+	}
+	return value, nil
+}
+
+func TestAnalyzeTraffic(t *testing.T) {
+	analysis := &zv1.AnalysisSpec{
+		Metrics: []zv1.AnalysisMetric{
+			{
+				Name:  "error-rate",
+				Query: `errors{namespace="{{ .Namespace }}",stackset="{{ .StackSet }}",stack="{{ .Stack }}"}`,
+				Max:   0.01,
+			},
+			{
+				Name:  "latency",
+				Query: `latency{stack="{{ .Stack }}"}`,
+				Max:   0.5,
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name             string
+		analysis         *zv1.AnalysisSpec
+		stacks           map[types.UID]*StackContainer
+		values           map[string]float64
+		providerErr      error
+		expectedQueries  []string
+		expectedRollback *TrafficRollback
+		expectError      bool
+		expectedDesired  map[string]float64
+	}{
+		{
+			name: "no analysis",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 50, "foo-v2": 50},
+		},
+		{
+			name:     "newest stack without traffic isn't analysed",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 100, "foo-v2": 0},
+		},
+		{
+			name:     "stack with all the traffic isn't analysed",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").createdAt(hourAgo).noTrafficSince(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+			},
+			values: map[string]float64{
+				`errors{namespace="default",stackset="foo",stack="foo-v2"}`: 0.5,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 100},
+		},
+		{
+			name:     "single stack isn't analysed",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(100, 100).createdAt(hourAgo).stack(),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 100},
+		},
+		{
+			name:     "healthy stack",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			values: map[string]float64{
+				`errors{namespace="default",stackset="foo",stack="foo-v2"}`: 0.01,
+				`latency{stack="foo-v2"}`:                                   0.2,
+			},
+			expectedQueries: []string{
+				`errors{namespace="default",stackset="foo",stack="foo-v2"}`,
+				`latency{stack="foo-v2"}`,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 50, "foo-v2": 50},
+		},
+		{
+			name:     "missing data is ignored",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedQueries: []string{
+				`errors{namespace="default",stackset="foo",stack="foo-v2"}`,
+				`latency{stack="foo-v2"}`,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 50, "foo-v2": 50},
+		},
+		{
+			name:        "provider errors are returned",
+			analysis:    analysis,
+			providerErr: errors.New("connection refused"),
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedQueries: []string{
+				`errors{namespace="default",stackset="foo",stack="foo-v2"}`,
+			},
+			expectError:     true,
+			expectedDesired: map[string]float64{"foo-v1": 50, "foo-v2": 50},
+		},
+		{
+			name: "invalid query",
+			analysis: &zv1.AnalysisSpec{
+				Metrics: []zv1.AnalysisMetric{{Name: "invalid", Query: `{{ .Unknown }}`}},
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectError:     true,
+			expectedDesired: map[string]float64{"foo-v1": 50, "foo-v2": 50},
+		},
+		{
+			name:     "threshold exceeded, traffic rolled back to the stack with traffic",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(10, 10).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(60, 70).createdAt(hourAgo.Add(time.Minute)).stack(),
+				"v3": testStack("foo-v3").traffic(30, 20).createdAt(fiveMinutesAgo).stack(),
+			},
+			values: map[string]float64{
+				`errors{namespace="default",stackset="foo",stack="foo-v3"}`: 0.01,
+				`latency{stack="foo-v3"}`:                                   0.7,
+			},
+			expectedQueries: []string{
+				`errors{namespace="default",stackset="foo",stack="foo-v3"}`,
+				`latency{stack="foo-v3"}`,
+			},
+			expectedRollback: &TrafficRollback{
+				StackName:         "foo-v3",
+				PreviousStackName: "foo-v2",
+				Metric:            "latency",
+				Value:             0.7,
+				Max:               0.5,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 100, "foo-v3": 0},
+		},
+		{
+			name:     "threshold exceeded, traffic rolled back to the stack which most recently had traffic",
+			analysis: analysis,
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").createdAt(hourAgo).noTrafficSince(hourAgo).stack(),
+				"v2": testStack("foo-v2").createdAt(hourAgo.Add(time.Minute)).noTrafficSince(fiveMinutesAgo).stack(),
+				"v3": testStack("foo-v3").traffic(100, 90).createdAt(fiveMinutesAgo).stack(),
+			},
+			values: map[string]float64{
+				`errors{namespace="default",stackset="foo",stack="foo-v3"}`: 0.5,
+			},
+			expectedQueries: []string{
+				`errors{namespace="default",stackset="foo",stack="foo-v3"}`,
+			},
+			expectedRollback: &TrafficRollback{
+				StackName:         "foo-v3",
+				PreviousStackName: "foo-v2",
+				Metric:            "error-rate",
+				Value:             0.5,
+				Max:               0.01,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 100, "foo-v3": 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "foo",
+						Namespace: "default",
+					},
+					Spec: zv1.StackSetSpec{
+						Ingress:  &zv1.StackSetIngressSpec{},
+						Analysis: tc.analysis,
+					},
+				},
+				StackContainers: tc.stacks,
+			}
+
+			provider := &fakeAnalysisProvider{values: tc.values, err: tc.providerErr}
+			rollback, err := c.AnalyzeTraffic(context.Background(), provider)
+			if tc.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expectedRollback, rollback)
+			require.Equal(t, tc.expectedQueries, provider.queries)
+
+			desired := make(map[string]float64)
+			for _, sc := range c.StackContainers {
+				desired[sc.Name()] = sc.desiredTrafficWeight
+			}
+			require.Equal(t, tc.expectedDesired, desired)
+		})
+	}
+}
+
+func TestAnalyzeTrafficRollsBackRollout(t *testing.T) {
+	c := &StackSetContainer{
+		StackSet: &zv1.StackSet{
+			Spec: zv1.StackSetSpec{
+				Ingress: &zv1.StackSetIngressSpec{},
+				Rollout: &zv1.RolloutSpec{
+					Steps: []zv1.RolloutStep{{Weight: 10}, {Weight: 100}},
+				},
+				Analysis: &zv1.AnalysisSpec{
+					Metrics: []zv1.AnalysisMetric{{Name: "error-rate", Query: "errors", Max: 0.01}},
+				},
+			},
+			Status: zv1.StackSetStatus{
+				Rollout: &zv1.RolloutStatus{
+					StackName: "foo-v2",
+					Phase:     zv1.RolloutPhaseProgressing,
+				},
+			},
+		},
+		StackContainers: map[types.UID]*StackContainer{
+			"v1": testStack("foo-v1").traffic(90, 90).createdAt(hourAgo).stack(),
+			"v2": testStack("foo-v2").traffic(10, 10).createdAt(fiveMinutesAgo).stack(),
+		},
+	}
+
+	rollback, err := c.AnalyzeTraffic(context.Background(), &fakeAnalysisProvider{values: map[string]float64{"errors": 0.1}})
+	require.NoError(t, err)
+	require.NotNil(t, rollback)
+
+	c.ManageRollout(time.Now())
+	require.Equal(t, &zv1.RolloutStatus{StackName: "foo-v2", Phase: zv1.RolloutPhaseRolledBack}, c.GenerateStackSetStatus().Rollout)
+	require.EqualValues(t, 100, c.stackByName("foo-v1").desiredTrafficWeight)
+	require.EqualValues(t, 0, c.stackByName("foo-v2").desiredTrafficWeight)
+}
+
+func TestAnalyzeTrafficOnlyWhileRollingOut(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		phase            zv1.RolloutPhase
+		expectedAnalysed bool
+	}{
+		{
+			name:             "rollout in progress",
+			phase:            zv1.RolloutPhaseProgressing,
+			expectedAnalysed: true,
+		},
+		{
+			name:             "paused rollout",
+			phase:            zv1.RolloutPhasePaused,
+			expectedAnalysed: true,
+		},
+		{
+			name:  "completed rollout",
+			phase: zv1.RolloutPhaseCompleted,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					Spec: zv1.StackSetSpec{
+						Ingress: &zv1.StackSetIngressSpec{},
+						Rollout: &zv1.RolloutSpec{
+							Steps: []zv1.RolloutStep{{Weight: 10}, {Weight: 100, Pause: &metav1.Duration{Duration: time.Hour}}},
+						},
+						Analysis: &zv1.AnalysisSpec{
+							Metrics: []zv1.AnalysisMetric{{Name: "error-rate", Query: "errors", Max: 0.01}},
+						},
+					},
+					Status: zv1.StackSetStatus{
+						Rollout: &zv1.RolloutStatus{
+							StackName: "foo-v2",
+							Step:      1,
+							Phase:     tc.phase,
+						},
+					},
+				},
+				StackContainers: map[types.UID]*StackContainer{
+					"v1": testStack("foo-v1").createdAt(hourAgo).noTrafficSince(fiveMinutesAgo).stack(),
+					"v2": testStack("foo-v2").traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+				},
+			}
+
+			rollback, err := c.AnalyzeTraffic(context.Background(), &fakeAnalysisProvider{values: map[string]float64{"errors": 0.1}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedAnalysed, rollback != nil)
+		})
+	}
+}
+
+func TestAnalyzeTrafficRollbackIsNotRateLimited(t *testing.T) {
+	c := &StackSetContainer{
+		StackSet: &zv1.StackSet{
+			Spec: zv1.StackSetSpec{
+				Ingress: &zv1.StackSetIngressSpec{},
+				Analysis: &zv1.AnalysisSpec{
+					Metrics: []zv1.AnalysisMetric{{Name: "error-rate", Query: "errors", Max: 0.01}},
+				},
+			},
+		},
+		StackContainers: map[types.UID]*StackContainer{
+			"v1": testStack("foo-v1").traffic(50, 50).ready(3).createdAt(hourAgo).stack(),
+			"v2": testStack("foo-v2").traffic(50, 50).ready(3).createdAt(fiveMinutesAgo).lastTrafficSwitch(time.Now()).stack(),
+		},
+		TrafficReconciler: TrafficReconcilerChain{
+			SimpleTrafficReconciler{},
+			RateLimitedTrafficReconciler{MaxWeightChange: 10, Interval: time.Minute},
+		},
+	}
+
+	rollback, err := c.AnalyzeTraffic(context.Background(), &fakeAnalysisProvider{values: map[string]float64{"errors": 0.1}})
+	require.NoError(t, err)
+	require.NotNil(t, rollback)
+
+	err = c.ManageTraffic(time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, 100, c.stackByName("foo-v1").actualTrafficWeight)
+	require.EqualValues(t, 0, c.stackByName("foo-v2").actualTrafficWeight)
+}
+
+func TestAnalyzeTrafficRollbackIsNotRateLimitedUntilComplete(t *testing.T) {
+	spec := zv1.StackSetSpec{
+		Ingress: &zv1.StackSetIngressSpec{},
+		Analysis: &zv1.AnalysisSpec{
+			Metrics: []zv1.AnalysisMetric{{Name: "error-rate", Query: "errors", Max: 0.01}},
+		},
+	}
+	reconciler := TrafficReconcilerChain{
+		SimpleTrafficReconciler{},
+		RateLimitedTrafficReconciler{MaxWeightChange: 10, Interval: time.Minute},
+	}
+	provider := &fakeAnalysisProvider{values: map[string]float64{"errors": 0.1}}
+
+	// The previous stack isn't ready yet, so the traffic isn't switched
+	// in the first reconciliation.
+	c := &StackSetContainer{
+		StackSet: &zv1.StackSet{Spec: spec},
+		StackContainers: map[types.UID]*StackContainer{
+			"v1": testStack("foo-v1").traffic(50, 50).partiallyReady(1, 3).createdAt(hourAgo).stack(),
+			"v2": testStack("foo-v2").traffic(50, 50).ready(3).createdAt(fiveMinutesAgo).lastTrafficSwitch(time.Now()).stack(),
+		},
+		TrafficReconciler: reconciler,
+	}
+
+	rollback, err := c.AnalyzeTraffic(context.Background(), provider)
+	require.NoError(t, err)
+	require.NotNil(t, rollback)
+	require.Error(t, c.ManageTraffic(time.Now()))
+
+	v2 := c.stackByName("foo-v2")
+	status := *v2.GenerateStackStatus()
+	status.Conditions = v2.GenerateStackConditions()
+	degraded := meta.FindStatusCondition(status.Conditions, zv1.ConditionDegraded)
+	require.NotNil(t, degraded)
+	require.Equal(t, metav1.ConditionTrue, degraded.Status)
+	require.Equal(t, reasonTrafficRolledBack, degraded.Reason)
+
+	// The next reconciliation restores the rollback from the status of
+	// the stack and switches the traffic at once.
+	next := testStack("foo-v2").traffic(0, 50).ready(3).createdAt(fiveMinutesAgo).stack()
+	next.Stack.Status = status
+	next.updateFromStatus()
+
+	c = &StackSetContainer{
+		StackSet: &zv1.StackSet{Spec: spec},
+		StackContainers: map[types.UID]*StackContainer{
+			"v1": testStack("foo-v1").traffic(100, 50).ready(3).createdAt(hourAgo).stack(),
+			"v2": next,
+		},
+		TrafficReconciler: reconciler,
+	}
+
+	rollback, err = c.AnalyzeTraffic(context.Background(), provider)
+	require.NoError(t, err)
+	require.Nil(t, rollback)
+	require.NoError(t, c.ManageTraffic(time.Now()))
+	require.EqualValues(t, 100, c.stackByName("foo-v1").actualTrafficWeight)
+	require.EqualValues(t, 0, c.stackByName("foo-v2").actualTrafficWeight)
+
+	// The rollback is complete, so further switches are rate limited.
+	require.False(t, next.trafficRolledBack)
+	degraded = meta.FindStatusCondition(next.GenerateStackConditions(), zv1.ConditionDegraded)
+	require.NotNil(t, degraded)
+	require.Equal(t, metav1.ConditionFalse, degraded.Status)
+}
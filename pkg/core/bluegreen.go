@@ -0,0 +1,98 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultBlueGreenConfirmationWindow is the default time the previously live
+// stack of a blue/green StackSet is kept after a promotion.
+const DefaultBlueGreenConfirmationWindow = time.Hour
+
+// manageBlueGreenTraffic sends all traffic to the live stack of a blue/green
+// StackSet. A promoted stack becomes live at once as soon as it's ready, no
+// traffic reconciler is involved. The candidate and the previously live stack
+// are kept scaled up, the latter until the confirmation window passed.
+func (ssc *StackSetContainer) manageBlueGreenTraffic(currentTimestamp time.Time) error {
+	spec := ssc.StackSet.Spec.BlueGreen
+
+	status := ssc.StackSet.Status.BlueGreen.DeepCopy()
+	if status == nil {
+		status = &zv1.BlueGreenStatus{}
+	}
+	ssc.blueGreenStatus = status
+
+	live := ssc.stackByName(status.LiveStack)
+	if live == nil || live.PendingRemoval {
+		live = ssc.blueGreenFallbackStack()
+		if live == nil {
+			return errNoStacks
+		}
+	}
+
+	minReadyPercent := normalizeMinReadyPercent(ssc.StackSet.Spec.MinReadyPercent)
+	for _, sc := range ssc.StackContainers {
+		sc.minReadyPercent = minReadyPercent
+	}
+
+	var err error
+	if spec.Promote != "" && spec.Promote != live.Name() {
+		promoted := ssc.stackByName(spec.Promote)
+		switch {
+		case promoted == nil || promoted.PendingRemoval:
+			err = fmt.Errorf("unable to promote unknown stack %s", spec.Promote)
+		case promoted.IsReady():
+			status.PreviousLiveStack = live.Name()
+			status.PromotedAt = &metav1.Time{Time: currentTimestamp}
+			live = promoted
+		}
+	}
+	status.LiveStack = live.Name()
+
+	confirmationWindow := DefaultBlueGreenConfirmationWindow
+	if spec.ConfirmationWindow != nil {
+		confirmationWindow = spec.ConfirmationWindow.Duration
+	}
+	if status.PromotedAt == nil || currentTimestamp.Sub(status.PromotedAt.Time) >= confirmationWindow {
+		status.PreviousLiveStack = ""
+	}
+
+	status.CandidateStack = ""
+	if newest := ssc.newestStack(); newest != live && newest.Stack.CreationTimestamp.After(live.Stack.CreationTimestamp.Time) {
+		status.CandidateStack = newest.Name()
+	}
+
+	for _, sc := range ssc.StackContainers {
+		weight := 0.0
+		if sc == live {
+			weight = 100
+		}
+		sc.desiredTrafficWeight = weight
+		sc.actualTrafficWeight = weight
+		sc.blueGreenRetained = sc.Name() == status.CandidateStack || sc.Name() == status.PreviousLiveStack
+	}
+	return err
+}
+
+// blueGreenFallbackStack returns the stack considered live if the status
+// doesn't have one, i.e. the stack with the most traffic.
+func (ssc *StackSetContainer) blueGreenFallbackStack() *StackContainer {
+	stacks := make(map[string]*StackContainer)
+	var fallback *StackContainer
+	for _, sc := range ssc.StackContainers {
+		if sc.PendingRemoval {
+			continue
+		}
+		stacks[sc.Name()] = sc
+		if sc.actualTrafficWeight > 0 && (fallback == nil || sc.actualTrafficWeight > fallback.actualTrafficWeight) {
+			fallback = sc
+		}
+	}
+	if fallback == nil && len(stacks) > 0 {
+		fallback = findFallbackStack(stacks)
+	}
+	return fallback
+}
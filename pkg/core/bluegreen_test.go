@@ -0,0 +1,209 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestManageTrafficBlueGreen(t *testing.T) {
+	now := time.Now()
+	tenMinutesAgo := &metav1.Time{Time: now.Add(-10 * time.Minute)}
+	twoHoursAgo := &metav1.Time{Time: now.Add(-2 * time.Hour)}
+
+	for _, tc := range []struct {
+		name             string
+		spec             zv1.BlueGreenSpec
+		status           *zv1.BlueGreenStatus
+		stacks           map[types.UID]*StackContainer
+		expectedStatus   *zv1.BlueGreenStatus
+		expectedWeights  map[string]float64
+		expectedRetained []string
+		expectError      bool
+	}{
+		{
+			name: "stack with traffic is live, newer stack is the candidate",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").ready(3).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:      "foo-v1",
+				CandidateStack: "foo-v2",
+			},
+			expectedWeights:  map[string]float64{"foo-v1": 100, "foo-v2": 0},
+			expectedRetained: []string{"foo-v2"},
+		},
+		{
+			name: "traffic weights are ignored",
+			status: &zv1.BlueGreenStatus{
+				LiveStack: "foo-v1",
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").ready(3).traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:      "foo-v1",
+				CandidateStack: "foo-v2",
+			},
+			expectedWeights:  map[string]float64{"foo-v1": 100, "foo-v2": 0},
+			expectedRetained: []string{"foo-v2"},
+		},
+		{
+			name: "ready stack is promoted",
+			spec: zv1.BlueGreenSpec{Promote: "foo-v2"},
+			status: &zv1.BlueGreenStatus{
+				LiveStack:      "foo-v1",
+				CandidateStack: "foo-v2",
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").ready(3).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:         "foo-v2",
+				PreviousLiveStack: "foo-v1",
+				PromotedAt:        &metav1.Time{Time: now},
+			},
+			expectedWeights:  map[string]float64{"foo-v1": 0, "foo-v2": 100},
+			expectedRetained: []string{"foo-v1"},
+		},
+		{
+			name: "promotion waits for the stack to be ready",
+			spec: zv1.BlueGreenSpec{Promote: "foo-v2"},
+			status: &zv1.BlueGreenStatus{
+				LiveStack:      "foo-v1",
+				CandidateStack: "foo-v2",
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").partiallyReady(1, 3).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:      "foo-v1",
+				CandidateStack: "foo-v2",
+			},
+			expectedWeights:  map[string]float64{"foo-v1": 100, "foo-v2": 0},
+			expectedRetained: []string{"foo-v2"},
+		},
+		{
+			name: "unknown stacks can't be promoted",
+			spec: zv1.BlueGreenSpec{Promote: "foo-v3"},
+			status: &zv1.BlueGreenStatus{
+				LiveStack: "foo-v1",
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).createdAt(hourAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack: "foo-v1",
+			},
+			expectedWeights: map[string]float64{"foo-v1": 100},
+			expectError:     true,
+		},
+		{
+			name: "previous live stack is kept during the confirmation window",
+			spec: zv1.BlueGreenSpec{Promote: "foo-v2"},
+			status: &zv1.BlueGreenStatus{
+				LiveStack:         "foo-v2",
+				PreviousLiveStack: "foo-v1",
+				PromotedAt:        tenMinutesAgo,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).createdAt(hourAgo).noTrafficSince(tenMinutesAgo.Time).stack(),
+				"v2": testStack("foo-v2").ready(3).traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:         "foo-v2",
+				PreviousLiveStack: "foo-v1",
+				PromotedAt:        tenMinutesAgo,
+			},
+			expectedWeights:  map[string]float64{"foo-v1": 0, "foo-v2": 100},
+			expectedRetained: []string{"foo-v1"},
+		},
+		{
+			name: "previous live stack is released after the confirmation window",
+			spec: zv1.BlueGreenSpec{
+				Promote:            "foo-v2",
+				ConfirmationWindow: &metav1.Duration{Duration: 5 * time.Minute},
+			},
+			status: &zv1.BlueGreenStatus{
+				LiveStack:         "foo-v2",
+				PreviousLiveStack: "foo-v1",
+				PromotedAt:        tenMinutesAgo,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).createdAt(hourAgo).noTrafficSince(tenMinutesAgo.Time).stack(),
+				"v2": testStack("foo-v2").ready(3).traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:  "foo-v2",
+				PromotedAt: tenMinutesAgo,
+			},
+			expectedWeights: map[string]float64{"foo-v1": 0, "foo-v2": 100},
+		},
+		{
+			name: "previous live stack can be promoted again",
+			spec: zv1.BlueGreenSpec{Promote: "foo-v1"},
+			status: &zv1.BlueGreenStatus{
+				LiveStack:         "foo-v2",
+				PreviousLiveStack: "foo-v1",
+				PromotedAt:        twoHoursAgo,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).createdAt(hourAgo).noTrafficSince(tenMinutesAgo.Time).stack(),
+				"v2": testStack("foo-v2").ready(3).traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.BlueGreenStatus{
+				LiveStack:         "foo-v1",
+				CandidateStack:    "foo-v2",
+				PreviousLiveStack: "foo-v2",
+				PromotedAt:        &metav1.Time{Time: now},
+			},
+			expectedWeights:  map[string]float64{"foo-v1": 100, "foo-v2": 0},
+			expectedRetained: []string{"foo-v2"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := tc.spec
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					Spec: zv1.StackSetSpec{
+						Ingress:   &zv1.StackSetIngressSpec{},
+						BlueGreen: &spec,
+					},
+					Status: zv1.StackSetStatus{
+						BlueGreen: tc.status,
+					},
+				},
+				StackContainers:   tc.stacks,
+				TrafficReconciler: SimpleTrafficReconciler{},
+			}
+
+			err := c.ManageTraffic(now)
+			if tc.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, tc.expectedStatus, c.GenerateStackSetStatus().BlueGreen)
+
+			var retained []string
+			for _, sc := range c.StackContainers {
+				require.Equal(t, tc.expectedWeights[sc.Name()], sc.desiredTrafficWeight, "desired weight of %s", sc.Name())
+				require.Equal(t, tc.expectedWeights[sc.Name()], sc.actualTrafficWeight, "actual weight of %s", sc.Name())
+				if sc.blueGreenRetained {
+					require.False(t, sc.ScaledDown(), "stack %s must not be scaled down", sc.Name())
+					retained = append(retained, sc.Name())
+				}
+			}
+			require.Equal(t, tc.expectedRetained, retained)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+
+	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+// canaryPredicates returns the Skipper predicates matching the requests of the
+// canary route.
+func canaryPredicates(canary zv1.CanaryRoute) ([]string, error) {
+	var predicates []string
+	if canary.Header != nil {
+		predicates = append(predicates, fmt.Sprintf("Header(%q, %q)", canary.Header.Name, canary.Header.Value))
+	}
+	if canary.Cookie != nil {
+		// The Cookie predicate matches the value with a regular expression
+		value := "^" + regexp.QuoteMeta(canary.Cookie.Value) + "$"
+		predicates = append(predicates, fmt.Sprintf("Cookie(%q, %q)", canary.Cookie.Name, value))
+	}
+
+	if len(predicates) == 0 {
+		return nil, fmt.Errorf("invalid canary route for stack %s: no header or cookie to match", canary.StackName)
+	}
+	return append(predicates, canaryRouteWeightPredicate), nil
+}
+
+// generateCanaryRoutes returns a copy of the routes for each of the canary
+// routes, matching only the requests of the canary route. Routes without
+// explicit backends send the requests to the backend of the stack of the
+// canary route.
+func generateCanaryRoutes(routes []rgv1.RouteGroupRouteSpec, canaries []zv1.CanaryRoute) ([]rgv1.RouteGroupRouteSpec, error) {
+	var result []rgv1.RouteGroupRouteSpec
+	for _, canary := range canaries {
+		predicates, err := canaryPredicates(canary)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, route := range routes {
+			canaryRoute := *route.DeepCopy()
+			canaryRoute.Predicates = append(canaryRoute.Predicates, predicates...)
+			if len(canaryRoute.Backends) == 0 {
+				canaryRoute.Backends = []rgv1.RouteGroupBackendReference{
+					{
+						BackendName: canary.StackName,
+						Weight:      100,
+					},
+				}
+			}
+			result = append(result, canaryRoute)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ReasonTrafficNotSwitched is the reason of events and conditions
+	// reporting a failed traffic switch.
+	ReasonTrafficNotSwitched = "TrafficNotSwitched"
+
+	reasonStackSetReady       = "StackSetReady"
+	reasonStackReady          = "StackReady"
+	reasonStackNotReady       = "StackNotReady"
+	reasonStacksNotReady      = "StacksNotReady"
+	reasonScaledDown          = "ScaledDown"
+	reasonResourcesReconciled = "ResourcesReconciled"
+	reasonTrafficSwitched     = "TrafficSwitched"
+	reasonTrafficSwitching    = "TrafficSwitching"
+	reasonTrafficRolledBack   = "TrafficRolledBack"
+	reasonRolloutProgressing  = "RolloutProgressing"
+	reasonStacksProgressing   = "StacksProgressing"
+	reasonReplicasUpdating    = "ReplicasUpdating"
+	reasonComplete            = "Complete"
+	reasonAsExpected          = "AsExpected"
+)
+
+// reconcileError is an error of a reconciliation step, with the reason of
+// the event it was reported with.
+type reconcileError struct {
+	reason string
+	err    error
+}
+
+// ReportError records the error of a reconciliation step of the StackSet
+// with the reason of the event it was reported with. The first error is
+// reported by the ResourcesReconciled condition of the StackSet.
+func (ssc *StackSetContainer) ReportError(reason string, err error) {
+	ssc.reconcileErrors = append(ssc.reconcileErrors, reconcileError{reason: reason, err: err})
+}
+
+// ReportTrafficError records the error of the traffic switch, which is
+// reported by the TrafficSwitched condition of the StackSet.
+func (ssc *StackSetContainer) ReportTrafficError(err error) {
+	ssc.trafficError = err
+}
+
+// ReportError records the error of reconciling the resources of the stack
+// with the reason of the event it was reported with.
+func (sc *StackContainer) ReportError(reason string, err error) {
+	sc.reconcileErrors = append(sc.reconcileErrors, reconcileError{reason: reason, err: err})
+}
+
+// GenerateStackSetConditions returns the conditions of the StackSet based
+// on the conditions in its current status, which keep their transition time
+// unless their status changes.
+func (ssc *StackSetContainer) GenerateStackSetConditions() []metav1.Condition {
+	var notReady, switching, progressing, degraded, failed []string
+	for _, sc := range ssc.sortedStacks() {
+		if sc.trafficSwitching() {
+			switching = append(switching, sc.Name())
+		}
+		if sc.progressing() {
+			progressing = append(progressing, sc.Name())
+		}
+		if sc.degraded() {
+			degraded = append(degraded, sc.Name())
+		}
+		if len(sc.reconcileErrors) > 0 {
+			failed = append(failed, fmt.Sprintf("%s: %s", sc.Name(), joinErrors(sc.reconcileErrors)))
+		}
+		if sc.actualTrafficWeight > 0 && !sc.IsReady() {
+			notReady = append(notReady, sc.Name())
+		}
+	}
+
+	conditions := conditionSet{
+		conditions: append([]metav1.Condition(nil), ssc.StackSet.Status.Conditions...),
+		generation: ssc.StackSet.Generation,
+	}
+
+	switch {
+	case len(ssc.reconcileErrors) > 0:
+		conditions.set(zv1.ConditionResourcesReconciled, false, ssc.reconcileErrors[0].reason, joinErrors(ssc.reconcileErrors))
+	case len(failed) > 0:
+		conditions.set(zv1.ConditionResourcesReconciled, false, "FailedManageStack", strings.Join(failed, "; "))
+	default:
+		conditions.set(zv1.ConditionResourcesReconciled, true, reasonResourcesReconciled, "")
+	}
+
+	switch {
+	case ssc.trafficError != nil:
+		conditions.set(zv1.ConditionTrafficSwitched, false, ReasonTrafficNotSwitched, ssc.trafficError.Error())
+	case len(switching) > 0:
+		conditions.set(zv1.ConditionTrafficSwitched, false, reasonTrafficSwitching, "switching traffic of stacks "+strings.Join(switching, ", "))
+	default:
+		conditions.set(zv1.ConditionTrafficSwitched, true, reasonTrafficSwitched, "")
+	}
+
+	switch {
+	case ssc.rolloutStatus != nil && ssc.rolloutStatus.Phase == zv1.RolloutPhaseProgressing:
+		conditions.set(zv1.ConditionProgressing, true, reasonRolloutProgressing, fmt.Sprintf("rolling out stack %s, step %d", ssc.rolloutStatus.StackName, ssc.rolloutStatus.Step))
+	case len(switching) > 0:
+		conditions.set(zv1.ConditionProgressing, true, reasonTrafficSwitching, "switching traffic of stacks "+strings.Join(switching, ", "))
+	case len(progressing) > 0:
+		conditions.set(zv1.ConditionProgressing, true, reasonStacksProgressing, "updating stacks "+strings.Join(progressing, ", "))
+	default:
+		conditions.set(zv1.ConditionProgressing, false, reasonComplete, "")
+	}
+
+	switch {
+	case ssc.rolledBackStack != "":
+		conditions.set(zv1.ConditionDegraded, true, reasonTrafficRolledBack, "rolled back traffic of stack "+ssc.rolledBackStack)
+	case ssc.rolloutStatus != nil && ssc.rolloutStatus.Phase == zv1.RolloutPhaseRolledBack:
+		conditions.set(zv1.ConditionDegraded, true, reasonTrafficRolledBack, "rolled back traffic of stack "+ssc.rolloutStatus.StackName)
+	case len(notReady) > 0:
+		conditions.set(zv1.ConditionDegraded, true, reasonStacksNotReady, "stacks getting traffic aren't ready: "+strings.Join(notReady, ", "))
+	default:
+		conditions.set(zv1.ConditionDegraded, false, reasonAsExpected, "")
+	}
+
+	conditions.setReady()
+	return conditions.conditions
+}
+
+// GenerateStackConditions returns the conditions of the stack based on the
+// conditions in its current status, which keep their transition time unless
+// their status changes.
+func (sc *StackContainer) GenerateStackConditions() []metav1.Condition {
+	conditions := conditionSet{
+		conditions: append([]metav1.Condition(nil), sc.Stack.Status.Conditions...),
+		generation: sc.Stack.Generation,
+	}
+
+	if len(sc.reconcileErrors) > 0 {
+		conditions.set(zv1.ConditionResourcesReconciled, false, sc.reconcileErrors[0].reason, joinErrors(sc.reconcileErrors))
+	} else {
+		conditions.set(zv1.ConditionResourcesReconciled, true, reasonResourcesReconciled, "")
+	}
+
+	if sc.trafficSwitching() {
+		conditions.set(zv1.ConditionTrafficSwitched, false, reasonTrafficSwitching, fmt.Sprintf("actual traffic %.1f%%, desired traffic %.1f%%", sc.actualTrafficWeight, sc.desiredTrafficWeight))
+	} else {
+		conditions.set(zv1.ConditionTrafficSwitched, true, reasonTrafficSwitched, "")
+	}
+
+	if sc.progressing() {
+		conditions.set(zv1.ConditionProgressing, true, reasonReplicasUpdating, sc.replicasMessage())
+	} else {
+		conditions.set(zv1.ConditionProgressing, false, reasonComplete, "")
+	}
+
+	switch {
+	case sc.trafficRolledBack:
+		conditions.set(zv1.ConditionDegraded, true, reasonTrafficRolledBack, "traffic is rolled back because the analysis of the stack failed")
+	case sc.degraded():
+		conditions.set(zv1.ConditionDegraded, true, reasonStackNotReady, "stack is getting traffic but isn't ready: "+sc.replicasMessage())
+	default:
+		conditions.set(zv1.ConditionDegraded, false, reasonAsExpected, "")
+	}
+
+	// The traffic of ready stacks may still be switched, e.g. during a
+	// rollout, so only failed reconciliations make them not ready.
+	switch {
+	case len(sc.reconcileErrors) > 0:
+		conditions.set(zv1.ConditionReady, false, sc.reconcileErrors[0].reason, joinErrors(sc.reconcileErrors))
+	case sc.ScaledDown():
+		conditions.set(zv1.ConditionReady, false, reasonScaledDown, "stack is scaled down because it isn't getting traffic")
+	case !sc.IsReady():
+		conditions.set(zv1.ConditionReady, false, reasonStackNotReady, sc.replicasMessage())
+	default:
+		conditions.set(zv1.ConditionReady, true, reasonStackReady, "")
+	}
+	return conditions.conditions
+}
+
+// trafficSwitching returns true if the actual traffic of the stack doesn't
+// match its desired traffic yet.
+func (sc *StackContainer) trafficSwitching() bool {
+	return math.Abs(sc.actualTrafficWeight-sc.desiredTrafficWeight) > trafficWeightTolerance
+}
+
+// progressing returns true while the resources or the pods of the stack are
+// updated.
+func (sc *StackContainer) progressing() bool {
+	if sc.ScaledDown() {
+		return false
+	}
+	return !sc.resourcesUpdated || sc.updatedReplicas < sc.deploymentReplicas || sc.readyReplicas < sc.deploymentReplicas
+}
+
+// degraded returns true if the stack is getting traffic without being ready.
+func (sc *StackContainer) degraded() bool {
+	return sc.actualTrafficWeight > 0 && !sc.IsReady()
+}
+
+func (sc *StackContainer) replicasMessage() string {
+	return fmt.Sprintf("%d of %d replicas updated, %d ready", sc.updatedReplicas, sc.deploymentReplicas, sc.readyReplicas)
+}
+
+// sortedStacks returns the stacks which aren't removed, sorted by name.
+func (ssc *StackSetContainer) sortedStacks() []*StackContainer {
+	stacks := make([]*StackContainer, 0, len(ssc.StackContainers))
+	for _, sc := range ssc.StackContainers {
+		if sc.PendingRemoval {
+			continue
+		}
+		stacks = append(stacks, sc)
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		return stacks[i].Name() < stacks[j].Name()
+	})
+	return stacks
+}
+
+// conditionSet updates a list of conditions of an object.
+type conditionSet struct {
+	conditions []metav1.Condition
+	generation int64
+}
+
+func (c *conditionSet) set(conditionType string, status bool, reason, message string) {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: c.generation,
+	}
+	if status {
+		condition.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&c.conditions, condition)
+}
+
+// setReady sets the Ready condition, which is false with the reason of the
+// first condition which isn't as expected.
+func (c *conditionSet) setReady() {
+	for _, condition := range []struct {
+		conditionType string
+		expected      metav1.ConditionStatus
+	}{
+		{zv1.ConditionResourcesReconciled, metav1.ConditionTrue},
+		{zv1.ConditionTrafficSwitched, metav1.ConditionTrue},
+		{zv1.ConditionDegraded, metav1.ConditionFalse},
+	} {
+		current := meta.FindStatusCondition(c.conditions, condition.conditionType)
+		if current != nil && current.Status != condition.expected {
+			c.set(zv1.ConditionReady, false, current.Reason, current.Message)
+			return
+		}
+	}
+	c.set(zv1.ConditionReady, true, reasonStackSetReady, "")
+}
+
+func joinErrors(errs []reconcileError) string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type expectedCondition struct {
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+}
+
+func requireConditions(t *testing.T, expected map[string]expectedCondition, conditions []metav1.Condition) {
+	t.Helper()
+	require.Len(t, conditions, len(expected))
+	for conditionType, expectedCondition := range expected {
+		condition := meta.FindStatusCondition(conditions, conditionType)
+		require.NotNil(t, condition, conditionType)
+		require.Equal(t, expectedCondition.status, condition.Status, conditionType)
+		require.Equal(t, expectedCondition.reason, condition.Reason, conditionType)
+		require.Equal(t, expectedCondition.message, condition.Message, conditionType)
+		require.EqualValues(t, 2, condition.ObservedGeneration, conditionType)
+	}
+}
+
+func TestGenerateStackSetConditions(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		stacks       map[types.UID]*StackContainer
+		rollout      *zv1.RolloutStatus
+		errors       []reconcileError
+		trafficError error
+		expected     map[string]expectedCondition
+	}{
+		{
+			name: "ready",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+				"v2": testStack("foo-v2").pendingRemoval().stack(),
+			},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionTrue, "StackSetReady", ""},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name: "switching traffic",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(50, 100).stack(),
+				"v2": testStack("foo-v2").ready(3).traffic(50, 0).stack(),
+			},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "TrafficSwitching", "switching traffic of stacks foo-v1, foo-v2"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionFalse, "TrafficSwitching", "switching traffic of stacks foo-v1, foo-v2"},
+				zv1.ConditionProgressing:         {metav1.ConditionTrue, "TrafficSwitching", "switching traffic of stacks foo-v1, foo-v2"},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name: "traffic not switched",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(0, 100).stack(),
+				"v2": testStack("foo-v2").partiallyReady(1, 3).traffic(100, 0).stack(),
+			},
+			trafficError: errors.New("stacks not ready: foo-v2"),
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "TrafficNotSwitched", "stacks not ready: foo-v2"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionFalse, "TrafficNotSwitched", "stacks not ready: foo-v2"},
+				zv1.ConditionProgressing:         {metav1.ConditionTrue, "TrafficSwitching", "switching traffic of stacks foo-v1, foo-v2"},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name: "rollout and stack updates",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(90, 90).stack(),
+				"v2": testStack("foo-v2").ready(3).traffic(10, 10).stack(),
+				"v3": testStack("foo-v3").partiallyReady(1, 3).stack(),
+			},
+			rollout: &zv1.RolloutStatus{StackName: "foo-v2", Step: 1, Phase: zv1.RolloutPhaseProgressing},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionTrue, "StackSetReady", ""},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionTrue, "RolloutProgressing", "rolling out stack foo-v2, step 1"},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name: "stacks getting traffic aren't ready",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").deployment(true, 3, 3, 0).traffic(100, 100).stack(),
+			},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "StacksNotReady", "stacks getting traffic aren't ready: foo-v1"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionTrue, "StacksProgressing", "updating stacks foo-v1"},
+				zv1.ConditionDegraded:            {metav1.ConditionTrue, "StacksNotReady", "stacks getting traffic aren't ready: foo-v1"},
+			},
+		},
+		{
+			name: "rolled back rollout",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+				"v2": testStack("foo-v2").ready(3).stack(),
+			},
+			rollout: &zv1.RolloutStatus{StackName: "foo-v2", Phase: zv1.RolloutPhaseRolledBack},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "TrafficRolledBack", "rolled back traffic of stack foo-v2"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionTrue, "TrafficRolledBack", "rolled back traffic of stack foo-v2"},
+			},
+		},
+		{
+			name: "failed reconciliation",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+			},
+			errors: []reconcileError{
+				{reason: "FailedCreateStack", err: errors.New("quota exceeded")},
+				{reason: "FailedManageStackSet", err: errors.New("conflict")},
+			},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "FailedCreateStack", "quota exceeded; conflict"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionFalse, "FailedCreateStack", "quota exceeded; conflict"},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, sc := range tc.stacks {
+				sc.minReadyPercent = 1
+			}
+
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				},
+				StackContainers: tc.stacks,
+				rolloutStatus:   tc.rollout,
+				reconcileErrors: tc.errors,
+				trafficError:    tc.trafficError,
+			}
+			requireConditions(t, tc.expected, c.GenerateStackSetConditions())
+		})
+	}
+}
+
+func TestGenerateStackSetConditionsFailedStack(t *testing.T) {
+	sc := testStack("foo-v1").ready(3).traffic(100, 100).stack()
+	sc.ReportError("FailedManageStack", errors.New("invalid deployment"))
+
+	c := &StackSetContainer{
+		StackSet:        &zv1.StackSet{ObjectMeta: metav1.ObjectMeta{Generation: 2}},
+		StackContainers: map[types.UID]*StackContainer{"v1": sc},
+	}
+	conditions := c.GenerateStackSetConditions()
+
+	condition := meta.FindStatusCondition(conditions, zv1.ConditionResourcesReconciled)
+	require.Equal(t, metav1.ConditionFalse, condition.Status)
+	require.Equal(t, "FailedManageStack", condition.Reason)
+	require.Equal(t, "foo-v1: invalid deployment", condition.Message)
+	require.True(t, meta.IsStatusConditionFalse(conditions, zv1.ConditionReady))
+}
+
+func TestGenerateStackConditions(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		stack    *StackContainer
+		errors   []reconcileError
+		expected map[string]expectedCondition
+	}{
+		{
+			name:  "ready",
+			stack: testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionTrue, "StackReady", ""},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name:  "ready while traffic is switched",
+			stack: testStack("foo-v1").ready(3).traffic(50, 20).stack(),
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionTrue, "StackReady", ""},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionFalse, "TrafficSwitching", "actual traffic 20.0%, desired traffic 50.0%"},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name:  "updating with traffic",
+			stack: testStack("foo-v1").deployment(true, 3, 1, 0).traffic(100, 100).stack(),
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "StackNotReady", "1 of 3 replicas updated, 0 ready"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionTrue, "ReplicasUpdating", "1 of 3 replicas updated, 0 ready"},
+				zv1.ConditionDegraded:            {metav1.ConditionTrue, "StackNotReady", "stack is getting traffic but isn't ready: 1 of 3 replicas updated, 0 ready"},
+			},
+		},
+		{
+			name:  "scaled down",
+			stack: testStack("foo-v1").noTrafficSince(hourAgo).stack(),
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "ScaledDown", "stack is scaled down because it isn't getting traffic"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionTrue, "ResourcesReconciled", ""},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+		{
+			name:   "failed reconciliation",
+			stack:  testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+			errors: []reconcileError{{reason: "FailedManageStack", err: errors.New("invalid deployment")}},
+			expected: map[string]expectedCondition{
+				zv1.ConditionReady:               {metav1.ConditionFalse, "FailedManageStack", "invalid deployment"},
+				zv1.ConditionResourcesReconciled: {metav1.ConditionFalse, "FailedManageStack", "invalid deployment"},
+				zv1.ConditionTrafficSwitched:     {metav1.ConditionTrue, "TrafficSwitched", ""},
+				zv1.ConditionProgressing:         {metav1.ConditionFalse, "Complete", ""},
+				zv1.ConditionDegraded:            {metav1.ConditionFalse, "AsExpected", ""},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.stack.Stack.Generation = 2
+			tc.stack.minReadyPercent = 1
+			tc.stack.reconcileErrors = tc.errors
+			requireConditions(t, tc.expected, tc.stack.GenerateStackConditions())
+		})
+	}
+}
+
+func TestGenerateConditionsKeepsTransitionTime(t *testing.T) {
+	transition := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	sc := testStack("foo-v1").ready(3).traffic(100, 100).stack()
+	sc.Stack.Status.Conditions = []metav1.Condition{
+		{Type: zv1.ConditionReady, Status: metav1.ConditionTrue, Reason: "StackReady", LastTransitionTime: transition},
+		{Type: zv1.ConditionDegraded, Status: metav1.ConditionTrue, Reason: "StackNotReady", LastTransitionTime: transition},
+	}
+
+	conditions := sc.GenerateStackConditions()
+	require.Equal(t, transition, meta.FindStatusCondition(conditions, zv1.ConditionReady).LastTransitionTime)
+	require.True(t, meta.FindStatusCondition(conditions, zv1.ConditionDegraded).LastTransitionTime.After(transition.Time))
+
+	// the status of the stack isn't modified
+	require.Equal(t, metav1.ConditionTrue, sc.Stack.Status.Conditions[1].Status)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// DefaultStackSet sets the defaults the controller would otherwise apply
+// implicitly when reconciling the StackSet, so that the effective
+// configuration is visible in the stored object. old is the StackSet before
+// an update or nil when it's created.
+//
+// Service ports derived from the containers of an old StackSet are derived
+// again, so they follow changes of the container ports.
+func DefaultStackSet(stackset, old *zv1.StackSet) {
+	template := &stackset.Spec.StackTemplate.Spec
+	template.Version = currentStackVersion(stackset)
+
+	lifecycle := &stackset.Spec.StackLifecycle
+	if lifecycle.Limit == nil {
+		limit := int32(stackLifecycleLimit(*lifecycle))
+		lifecycle.Limit = &limit
+	}
+	if lifecycle.ScaledownTTLSeconds == nil {
+		ttl := int64(stackScaledownTTL(*lifecycle) / time.Second)
+		lifecycle.ScaledownTTLSeconds = &ttl
+	}
+
+	if old != nil && template.Service != nil {
+		oldTemplate := old.Spec.StackTemplate.Spec.StackSpec
+		if equality.Semantic.DeepEqual(template.Service.Ports, servicePortsFromContainers(oldTemplate.PodTemplate.Spec.Containers)) {
+			template.Service.Ports = nil
+		}
+	}
+
+	ports := stackServicePorts(template.StackSpec)
+	if len(ports) == 0 {
+		return
+	}
+	if template.Service == nil {
+		template.Service = &zv1.StackServiceSpec{}
+	}
+	template.Service.Ports = ports
+	template.Service = sanitizeServicePorts(template.Service)
+}
+
+// stackLifecycleLimit returns the maximum number of stacks to keep.
+func stackLifecycleLimit(lifecycle zv1.StackLifecycle) int {
+	if lifecycle.Limit == nil {
+		return defaultStackLifecycleLimit
+	}
+	return int(*lifecycle.Limit)
+}
+
+// stackScaledownTTL returns the time after which stacks without traffic are
+// scaled down.
+func stackScaledownTTL(lifecycle zv1.StackLifecycle) time.Duration {
+	if lifecycle.ScaledownTTLSeconds == nil {
+		return defaultScaledownTTL
+	}
+	return time.Duration(*lifecycle.ScaledownTTLSeconds) * time.Second
+}
+
+// stackServicePorts returns the ports of the stack service, which are
+// derived from the container ports if the service doesn't define any.
+func stackServicePorts(spec zv1.StackSpec) []v1.ServicePort {
+	if spec.Service == nil || len(spec.Service.Ports) == 0 {
+		return servicePortsFromContainers(spec.PodTemplate.Spec.Containers)
+	}
+	return spec.Service.Ports
+}
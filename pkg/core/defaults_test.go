@@ -0,0 +1,101 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestDefaultStackSet(t *testing.T) {
+	stackset := func(ports []v1.ServicePort, containerPorts ...int32) *zv1.StackSet {
+		var container v1.Container
+		for _, port := range containerPorts {
+			container.Ports = append(container.Ports, v1.ContainerPort{ContainerPort: port})
+		}
+
+		result := &zv1.StackSet{}
+		result.Spec.StackTemplate.Spec.PodTemplate.Spec.Containers = []v1.Container{container}
+		if ports != nil {
+			result.Spec.StackTemplate.Spec.Service = &zv1.StackServiceSpec{Ports: ports}
+		}
+		return result
+	}
+	derivedPort := func(port int32) v1.ServicePort {
+		return v1.ServicePort{Name: "port-0-0", Protocol: v1.ProtocolTCP, Port: port, TargetPort: intstr.FromInt(int(port))}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		stackset      *zv1.StackSet
+		old           *zv1.StackSet
+		expectedPorts []v1.ServicePort
+	}{
+		{
+			name:     "no ports",
+			stackset: stackset(nil),
+		},
+		{
+			name:          "ports derived from the containers",
+			stackset:      stackset(nil, 8080),
+			expectedPorts: []v1.ServicePort{derivedPort(8080)},
+		},
+		{
+			name:          "protocol of service ports",
+			stackset:      stackset([]v1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}, 8080),
+			expectedPorts: []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+		},
+		{
+			name:          "derived ports follow the container ports",
+			stackset:      stackset([]v1.ServicePort{derivedPort(8080)}, 9090),
+			old:           stackset([]v1.ServicePort{derivedPort(8080)}, 8080),
+			expectedPorts: []v1.ServicePort{derivedPort(9090)},
+		},
+		{
+			name:          "service ports aren't derived again",
+			stackset:      stackset([]v1.ServicePort{derivedPort(80)}, 9090),
+			old:           stackset([]v1.ServicePort{derivedPort(80)}, 8080),
+			expectedPorts: []v1.ServicePort{derivedPort(80)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			DefaultStackSet(tc.stackset, tc.old)
+
+			spec := tc.stackset.Spec
+			require.Equal(t, defaultVersion, spec.StackTemplate.Spec.Version)
+			require.EqualValues(t, defaultStackLifecycleLimit, *spec.StackLifecycle.Limit)
+			require.EqualValues(t, 300, *spec.StackLifecycle.ScaledownTTLSeconds)
+			if tc.expectedPorts == nil {
+				require.Nil(t, spec.StackTemplate.Spec.Service)
+			} else {
+				require.Equal(t, tc.expectedPorts, spec.StackTemplate.Spec.Service.Ports)
+			}
+		})
+	}
+}
+
+func TestDefaultStackSetKeepsValues(t *testing.T) {
+	limit := int32(3)
+	ttl := int64(60)
+	stackset := &zv1.StackSet{
+		Spec: zv1.StackSetSpec{
+			StackLifecycle: zv1.StackLifecycle{
+				Limit:               &limit,
+				ScaledownTTLSeconds: &ttl,
+			},
+			StackTemplate: zv1.StackTemplate{
+				Spec: zv1.StackSpecTemplate{
+					Version: "v1",
+				},
+			},
+		},
+	}
+
+	expected := stackset.DeepCopy()
+	DefaultStackSet(stackset, nil)
+	require.Equal(t, expected, stackset)
+	require.Equal(t, 3, stackLifecycleLimit(stackset.Spec.StackLifecycle))
+	require.Equal(t, "1m0s", stackScaledownTTL(stackset.Spec.StackLifecycle).String())
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
+)
+
+// trafficMirror is a stack receiving a copy of a share of the live requests.
+type trafficMirror struct {
+	stackName string
+	// tag identifies the mirrored requests in Skipper, where it has to be
+	// unique across all routes.
+	tag     string
+	percent float64
+}
+
+// mirroring returns true if a share of the live requests is copied to the
+// stack. Stacks are only mirrored to while they don't get any traffic
+// themselves. Like stacks with traffic, they aren't scaled down while they
+// are mirrored to.
+func (sc *StackContainer) mirroring() bool {
+	return sc.mirrorWeight > 0 && !sc.HasTraffic() && !sc.PendingRemoval
+}
+
+func (sc *StackContainer) mirrorTag() string {
+	return sc.Namespace() + "/" + sc.Name()
+}
+
+// trafficMirrors returns the stacks being mirrored to, sorted by name.
+func (ssc *StackSetContainer) trafficMirrors() []trafficMirror {
+	var mirrors []trafficMirror
+	for _, sc := range ssc.StackContainers {
+		if sc.mirroring() {
+			mirrors = append(mirrors, trafficMirror{
+				stackName: sc.Name(),
+				tag:       sc.mirrorTag(),
+				percent:   sc.mirrorWeight,
+			})
+		}
+	}
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].stackName < mirrors[j].stackName
+	})
+	return mirrors
+}
+
+// updateTrafficMirrors passes the stacks being mirrored to to the stacks with
+// traffic, whose traffic segments copy the requests.
+func (ssc *StackSetContainer) updateTrafficMirrors() {
+	mirrors := ssc.trafficMirrors()
+	for _, sc := range ssc.StackContainers {
+		sc.trafficMirrors = nil
+		if sc.HasTraffic() {
+			sc.trafficMirrors = mirrors
+		}
+	}
+}
+
+// generateMirrorRoutes returns a copy of the routes for each of the traffic
+// mirrors, matching its share of the requests and copying them to the
+// mirrored stack. The original requests are still served by the route.
+func generateMirrorRoutes(routes []rgv1.RouteGroupRouteSpec, mirrors []trafficMirror) []rgv1.RouteGroupRouteSpec {
+	var result []rgv1.RouteGroupRouteSpec
+	for _, mirror := range mirrors {
+		for _, route := range routes {
+			mirrorRoute := *route.DeepCopy()
+			mirrorRoute.Predicates = append(mirrorRoute.Predicates, fmt.Sprintf("Traffic(%g)", mirror.percent/100))
+			mirrorRoute.Filters = append(mirrorRoute.Filters, fmt.Sprintf("teeLoopback(%q)", mirror.tag))
+			result = append(result, mirrorRoute)
+		}
+	}
+	return result
+}
+
+// generateMirroredRoutes returns a copy of the routes matching only the
+// requests copied to the stack. Routes without explicit backends send the
+// requests to the backend of the stack.
+func (sc *StackContainer) generateMirroredRoutes(routes []rgv1.RouteGroupRouteSpec) []rgv1.RouteGroupRouteSpec {
+	if !sc.mirroring() {
+		return nil
+	}
+
+	result := make([]rgv1.RouteGroupRouteSpec, 0, len(routes))
+	for _, route := range routes {
+		mirroredRoute := *route.DeepCopy()
+		mirroredRoute.Predicates = append(mirroredRoute.Predicates, fmt.Sprintf("Tee(%q)", sc.mirrorTag()), mirroredRouteWeightPredicate)
+		if len(mirroredRoute.Backends) == 0 {
+			mirroredRoute.Backends = []rgv1.RouteGroupBackendReference{
+				{
+					BackendName: sc.Name(),
+					Weight:      100,
+				},
+			}
+		}
+		result = append(result, mirroredRoute)
+	}
+	return result
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+// rolloutWeightTolerance is the tolerance used when comparing the actual
+// traffic weight of a stack with the weight of a rollout step. Actual weights
+// are rounded to whole numbers before they are reconciled.
+const rolloutWeightTolerance = 1.0
+
+// ManageRollout advances the rollout defined in the StackSet spec, if any. It
+// updates the desired traffic weights of the stacks according to the current
+// rollout step and must be called before ManageTraffic.
+//
+// A rollout targets the newest stack of the StackSet. It advances to the next
+// step once the actual traffic of the stack reached the weight of the current
+// step and the pause of the step has passed.
+func (ssc *StackSetContainer) ManageRollout(currentTimestamp time.Time) {
+	ssc.rolloutStatus = nil
+
+	rollout := ssc.StackSet.Spec.Rollout
+	if rollout == nil || len(rollout.Steps) == 0 || ssc.StackSet.Spec.BlueGreen != nil {
+		return
+	}
+
+	// No ingress -> no traffic management required
+	if ssc.StackSet.Spec.Ingress == nil && ssc.StackSet.Spec.RouteGroup == nil && ssc.StackSet.Spec.ExternalIngress == nil {
+		return
+	}
+
+	target := ssc.newestStack()
+	if target == nil {
+		return
+	}
+
+	status := ssc.StackSet.Status.Rollout.DeepCopy()
+	if status == nil || status.StackName != target.Name() {
+		status = &zv1.RolloutStatus{
+			StackName: target.Name(),
+			Phase:     zv1.RolloutPhaseProgressing,
+		}
+
+		// Nothing to roll out if the stack already gets all the
+		// traffic, e.g. because it's the first stack of the StackSet.
+		if target.desiredTrafficWeight >= 100 || !ssc.hasTrafficOutside(target) {
+			status.Phase = zv1.RolloutPhaseCompleted
+		}
+	}
+	ssc.rolloutStatus = status
+
+	if rollout.Aborted {
+		status.Phase = zv1.RolloutPhaseAborted
+		status.StepReachedAt = nil
+		ssc.setRolloutWeight(target, 0)
+		return
+	}
+
+	// Keep the traffic away from a stack which was rolled back because of
+	// a failed analysis.
+	if status.Phase == zv1.RolloutPhaseRolledBack || ssc.rolledBackStack == target.Name() {
+		status.Phase = zv1.RolloutPhaseRolledBack
+		status.StepReachedAt = nil
+		ssc.setRolloutWeight(target, 0)
+		return
+	}
+
+	switch status.Phase {
+	case zv1.RolloutPhaseCompleted:
+		return
+	case zv1.RolloutPhaseAborted:
+		// The abort was reverted, start over.
+		status.Step = 0
+		status.StepReachedAt = nil
+	}
+
+	// The steps might have been changed while rolling out.
+	if int(status.Step) >= len(rollout.Steps) {
+		status.Step = int32(len(rollout.Steps) - 1)
+	}
+
+	step := rollout.Steps[status.Step]
+	ssc.setRolloutWeight(target, step.Weight)
+
+	if rollout.Paused {
+		status.Phase = zv1.RolloutPhasePaused
+		return
+	}
+	status.Phase = zv1.RolloutPhaseProgressing
+
+	// Wait until the weight of the step is actually served.
+	if target.actualTrafficWeight+rolloutWeightTolerance < step.Weight {
+		return
+	}
+
+	if status.StepReachedAt == nil {
+		status.StepReachedAt = wrapTime(currentTimestamp)
+	}
+
+	if step.Pause != nil && currentTimestamp.Sub(status.StepReachedAt.Time) < step.Pause.Duration {
+		return
+	}
+
+	if int(status.Step) == len(rollout.Steps)-1 {
+		status.Phase = zv1.RolloutPhaseCompleted
+		return
+	}
+
+	status.Step++
+	status.StepReachedAt = nil
+	ssc.setRolloutWeight(target, rollout.Steps[status.Step].Weight)
+}
+
+// newestStack returns the most recently created stack which is not pending
+// removal.
+func (ssc *StackSetContainer) newestStack() *StackContainer {
+	var newest *StackContainer
+	for _, sc := range ssc.StackContainers {
+		if sc.PendingRemoval {
+			continue
+		}
+
+		if newest == nil {
+			newest = sc
+			continue
+		}
+
+		created, newestCreated := sc.Stack.CreationTimestamp.Time, newest.Stack.CreationTimestamp.Time
+		if created.After(newestCreated) || (created.Equal(newestCreated) && sc.Name() > newest.Name()) {
+			newest = sc
+		}
+	}
+	return newest
+}
+
+// hasTrafficOutside returns true if any stack other than the specified one
+// gets traffic.
+func (ssc *StackSetContainer) hasTrafficOutside(target *StackContainer) bool {
+	for _, sc := range ssc.StackContainers {
+		if sc != target && sc.HasTraffic() {
+			return true
+		}
+	}
+	return false
+}
+
+// setRolloutWeight sets the desired traffic weight of the target stack and
+// distributes the remaining traffic between the other stacks proportionally
+// to their current desired weights. If none of the other stacks has desired
+// traffic their actual weights are used instead, and if there's no traffic at
+// all the remaining traffic goes to the fallback stack.
+func (ssc *StackSetContainer) setRolloutWeight(target *StackContainer, weight float64) {
+	others := make(map[string]*StackContainer)
+	for _, sc := range ssc.StackContainers {
+		if sc != target && !sc.PendingRemoval {
+			others[sc.Name()] = sc
+		}
+	}
+
+	if len(others) == 0 {
+		return
+	}
+
+	weights := make(map[string]float64, len(others))
+	for name, sc := range others {
+		weights[name] = sc.desiredTrafficWeight
+	}
+
+	if allZero(weights) {
+		for name, sc := range others {
+			weights[name] = sc.actualTrafficWeight
+		}
+	}
+
+	if allZero(weights) {
+		weights[findFallbackStack(others).Name()] = 100
+	}
+
+	normalizeWeights(weights)
+
+	target.desiredTrafficWeight = weight
+	for name, sc := range others {
+		sc.desiredTrafficWeight = weights[name] * (100 - weight) / 100
+	}
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestManageRollout(t *testing.T) {
+	now := time.Now()
+	steps := []zv1.RolloutStep{
+		{Weight: 10, Pause: &metav1.Duration{Duration: 10 * time.Minute}},
+		{Weight: 50},
+		{Weight: 100},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		rollout         *zv1.RolloutSpec
+		status          *zv1.RolloutStatus
+		stacks          map[types.UID]*StackContainer
+		expectedStatus  *zv1.RolloutStatus
+		expectedDesired map[string]float64
+	}{
+		{
+			name: "no rollout",
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").stack(),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 100, "foo-v2": 0},
+		},
+		{
+			name:    "single stack completes immediately",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v1",
+				Phase:     zv1.RolloutPhaseCompleted,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0},
+		},
+		{
+			name:    "new stack starts the rollout",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v1",
+				Phase:     zv1.RolloutPhaseCompleted,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 90, "foo-v2": 10},
+		},
+		{
+			name:    "remaining traffic is distributed proportionally",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(25, 25).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(75, 75).createdAt(hourAgo.Add(time.Minute)).stack(),
+				"v3": testStack("foo-v3").createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v3",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 22.5, "foo-v2": 67.5, "foo-v3": 10},
+		},
+		{
+			name:    "step reached, pause starts",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(90, 90).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(10, 10).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				StepReachedAt: wrapTime(now),
+				Phase:         zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 90, "foo-v2": 10},
+		},
+		{
+			name:    "step not reached yet",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(90, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(10, 0).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 90, "foo-v2": 10},
+		},
+		{
+			name:    "pause not passed yet",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				StepReachedAt: wrapTime(fiveMinutesAgo),
+				Phase:         zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(90, 90).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(10, 10).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				StepReachedAt: wrapTime(fiveMinutesAgo),
+				Phase:         zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 90, "foo-v2": 10},
+		},
+		{
+			name:    "pause passed, advance to the next step",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				StepReachedAt: wrapTime(hourAgo),
+				Phase:         zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(90, 90).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(10, 10).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Step:      1,
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 50, "foo-v2": 50},
+		},
+		{
+			name:    "last step reached, rollout completed",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Step:      2,
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				Step:          2,
+				StepReachedAt: wrapTime(now),
+				Phase:         zv1.RolloutPhaseCompleted,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 100},
+		},
+		{
+			name:    "completed rollout doesn't touch traffic",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Step:      2,
+				Phase:     zv1.RolloutPhaseCompleted,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(30, 30).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(70, 70).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Step:      2,
+				Phase:     zv1.RolloutPhaseCompleted,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 30, "foo-v2": 70},
+		},
+		{
+			name:    "paused rollout keeps the current step",
+			rollout: &zv1.RolloutSpec{Steps: steps, Paused: true},
+			status: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				StepReachedAt: wrapTime(hourAgo),
+				Phase:         zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(90, 90).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(10, 10).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				StepReachedAt: wrapTime(hourAgo),
+				Phase:         zv1.RolloutPhasePaused,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 90, "foo-v2": 10},
+		},
+		{
+			name:    "aborted rollout moves traffic back",
+			rollout: &zv1.RolloutSpec{Steps: steps, Aborted: true},
+			status: &zv1.RolloutStatus{
+				StackName:     "foo-v2",
+				Step:          1,
+				StepReachedAt: wrapTime(hourAgo),
+				Phase:         zv1.RolloutPhaseProgressing,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(50, 50).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").traffic(50, 50).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Step:      1,
+				Phase:     zv1.RolloutPhaseAborted,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 100, "foo-v2": 0},
+		},
+		{
+			name:    "aborted completed rollout falls back to the previous stack",
+			rollout: &zv1.RolloutSpec{Steps: steps, Aborted: true},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v3",
+				Step:      2,
+				Phase:     zv1.RolloutPhaseCompleted,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").createdAt(hourAgo).noTrafficSince(hourAgo).stack(),
+				"v2": testStack("foo-v2").createdAt(hourAgo.Add(time.Minute)).noTrafficSince(fiveMinutesAgo).stack(),
+				"v3": testStack("foo-v3").traffic(100, 100).createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v3",
+				Step:      2,
+				Phase:     zv1.RolloutPhaseAborted,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 100, "foo-v3": 0},
+		},
+		{
+			name:    "resumed aborted rollout starts over",
+			rollout: &zv1.RolloutSpec{Steps: steps},
+			status: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Step:      1,
+				Phase:     zv1.RolloutPhaseAborted,
+			},
+			stacks: map[types.UID]*StackContainer{
+				"v1": testStack("foo-v1").traffic(100, 100).createdAt(hourAgo).stack(),
+				"v2": testStack("foo-v2").createdAt(fiveMinutesAgo).stack(),
+			},
+			expectedStatus: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			expectedDesired: map[string]float64{"foo-v1": 90, "foo-v2": 10},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					Spec: zv1.StackSetSpec{
+						Ingress: &zv1.StackSetIngressSpec{},
+						Rollout: tc.rollout,
+					},
+					Status: zv1.StackSetStatus{
+						Rollout: tc.status,
+					},
+				},
+				StackContainers: tc.stacks,
+			}
+
+			c.ManageRollout(now)
+			require.Equal(t, tc.expectedStatus, c.GenerateStackSetStatus().Rollout)
+
+			desired := make(map[string]float64)
+			for _, sc := range c.StackContainers {
+				desired[sc.Name()] = sc.desiredTrafficWeight
+			}
+			require.Equal(t, tc.expectedDesired, desired)
+		})
+	}
+}
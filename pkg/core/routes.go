@@ -0,0 +1,22 @@
+package core
+
+// Skipper prefers the route with the most predicates, and the Weight
+// predicate adds to that. For the N predicates of a route in the spec, the
+// routes generated from it have the following priorities, from lowest to
+// highest:
+//
+//	N+1 routes of the traffic segments
+//	N+2 routes mirroring the requests of the traffic segments
+//	N+3 routes hashing the sticky traffic key of the requests
+//	N+4 sticky routes
+//	N+5 sticky routes mirroring the requests
+//	N+6 canary routes, N+7 if they match both a header and a cookie
+//	N+8 routes of the mirrored requests
+//
+// The Weight predicates below keep that order.
+const (
+	hashRouteWeightPredicate     = "Weight(1)"
+	stickyRouteWeightPredicate   = "Weight(3)"
+	canaryRouteWeightPredicate   = "Weight(5)"
+	mirroredRouteWeightPredicate = "Weight(7)"
+)
@@ -108,16 +108,7 @@ func (sc *StackContainer) resourceMeta() metav1.ObjectMeta {
 
 // getServicePorts gets the service ports to be used for the stack service.
 func getServicePorts(stackSpec zv1.StackSpecInternal, backendPort *intstr.IntOrString) ([]v1.ServicePort, error) {
-	var servicePorts []v1.ServicePort
-	if stackSpec.StackSpec.Service == nil ||
-		len(stackSpec.StackSpec.Service.Ports) == 0 {
-
-		servicePorts = servicePortsFromContainers(
-			stackSpec.StackSpec.PodTemplate.Spec.Containers,
-		)
-	} else {
-		servicePorts = stackSpec.StackSpec.Service.Ports
-	}
+	servicePorts := stackServicePorts(stackSpec.StackSpec)
 
 	// validate that one port in the list maps to the backendPort.
 	if backendPort != nil {
@@ -186,8 +177,13 @@ func (sc *StackContainer) GenerateDeployment() *appsv1.Deployment {
 		}
 	} else {
 		// Stack scaled down (manually or because it doesn't receive traffic), check if we need to scale down the deployment
-		if sc.deploymentReplicas != 0 {
-			updatedReplicas = wrapReplicas(0)
+		scaledDownReplicas := int32(0)
+		if sc.pinned && desiredReplicas != 0 {
+			// Pinned stacks keep a minimum number of replicas
+			scaledDownReplicas = min(sc.pinnedMinReplicas, desiredReplicas)
+		}
+		if sc.deploymentReplicas != scaledDownReplicas {
+			updatedReplicas = wrapReplicas(scaledDownReplicas)
 		}
 	}
 
@@ -468,7 +464,31 @@ func (sc *StackContainer) GenerateRouteGroupSegment() (
 		r.Predicates = append(r.Predicates, sc.trafficSegment())
 		segmentedRoutes = append(segmentedRoutes, r)
 	}
-	res.Spec.Routes = segmentedRoutes
+
+	// Sticky, mirror and canary routes go after the segmented routes, the
+	// segment limits are read from the first route.
+	hashRoutes, stickyRoutes, err := sc.generateStickyRoutes(res.Spec.Routes)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashRoutes) > 0 {
+		res.Spec.Backends = append(res.Spec.Backends, rgv1.RouteGroupBackend{
+			Name: stickyTrafficBackend,
+			Type: rgv1.LoopbackRouteGroupBackend,
+		})
+	}
+	mirrorRoutes := generateMirrorRoutes(append(append([]rgv1.RouteGroupRouteSpec{}, segmentedRoutes...), stickyRoutes...), sc.trafficMirrors)
+	canaryRoutes, err := generateCanaryRoutes(res.Spec.Routes, sc.canaryRoutes)
+	if err != nil {
+		return nil, err
+	}
+	mirroredRoutes := sc.generateMirroredRoutes(res.Spec.Routes)
+
+	routes := append(segmentedRoutes, hashRoutes...)
+	routes = append(routes, stickyRoutes...)
+	routes = append(routes, mirrorRoutes...)
+	routes = append(routes, canaryRoutes...)
+	res.Spec.Routes = append(routes, mirroredRoutes...)
 
 	return res, nil
 }
@@ -574,8 +594,21 @@ func (sc *StackContainer) GenerateStackStatus() *zv1.StackStatus {
 		DesiredReplicas:      sc.deploymentReplicas,
 		Prescaling:           prescaling,
 		NoTrafficSince:       wrapTime(sc.noTrafficSince),
+		LastTrafficSwitch:    wrapTime(sc.lastTrafficSwitch),
 		LabelSelector:        labels.Set(sc.selector()).String(),
+		Pinned:               sc.pinned,
+		ObservedGeneration:   sc.observedGeneration(),
+	}
+}
+
+// observedGeneration returns the generation of the stack if its resources
+// were reconciled without errors, and the previously observed generation
+// otherwise.
+func (sc *StackContainer) observedGeneration() int64 {
+	if len(sc.reconcileErrors) > 0 {
+		return sc.Stack.Status.ObservedGeneration
 	}
+	return sc.Stack.Generation
 }
 
 func (sc *StackContainer) GeneratePlatformCredentialsSet(pcs *zv1.PCS) (*zv1.PlatformCredentialsSet, error) {
@@ -2,6 +2,7 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"slices"
 	"strconv"
@@ -848,6 +849,243 @@ func TestStackGenerateRouteGroupSegment(t *testing.T) {
 		}
 	}
 }
+func TestStackGenerateRouteGroupSegmentCanaryRoutes(t *testing.T) {
+	backendPort := intstr.FromInt(int(80))
+	c := &StackContainer{
+		Stack: &zv1.Stack{
+			ObjectMeta: testStackMeta,
+		},
+		routeGroupSpec: &zv1.RouteGroupSpec{
+			Hosts: []string{"example.teapot.zalan.do"},
+			Routes: []rgv1.RouteGroupRouteSpec{
+				{Predicates: []string{`Method("GET")`}},
+			},
+		},
+		canaryRoutes: []zv1.CanaryRoute{
+			{
+				StackName: "foo-v1",
+				Cookie:    &zv1.CanaryMatch{Name: "canary", Value: "v1"},
+			},
+		},
+		segmentLowerLimit: 0.1,
+		segmentUpperLimit: 0.3,
+		backendPort:       &backendPort,
+	}
+
+	rg, err := c.GenerateRouteGroupSegment()
+	require.NoError(t, err)
+	require.Equal(t, []rgv1.RouteGroupRouteSpec{
+		{
+			Predicates: []string{`Method("GET")`, "TrafficSegment(0.10, 0.30)"},
+		},
+		{
+			Predicates: []string{`Method("GET")`, `Cookie("canary", "^v1$")`, "Weight(5)"},
+			Backends: []rgv1.RouteGroupBackendReference{
+				{
+					BackendName: "foo-v1",
+					Weight:      100,
+				},
+			},
+		},
+	}, rg.Spec.Routes)
+
+	lowerLimit, upperLimit, err := GetSegmentLimits(rg.Spec.Routes[0].Predicates...)
+	require.NoError(t, err)
+	require.Equal(t, 0.1, lowerLimit)
+	require.Equal(t, 0.3, upperLimit)
+}
+
+func TestStackGenerateRouteGroupSegmentTrafficMirrors(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		lowerLimit     float64
+		upperLimit     float64
+		mirrorWeight   float64
+		trafficMirrors []trafficMirror
+		expectedRoutes []rgv1.RouteGroupRouteSpec
+	}{
+		{
+			name:       "stack with traffic",
+			lowerLimit: 0.5,
+			upperLimit: 1,
+			trafficMirrors: []trafficMirror{
+				{stackName: "foo-v2", tag: "bar/foo-v2", percent: 10},
+			},
+			expectedRoutes: []rgv1.RouteGroupRouteSpec{
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.50, 1.00)"},
+				},
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.50, 1.00)", `HeaderRegexp("X-User-Id", ".")`, "Weight(1)"},
+					Filters:    []string{fmt.Sprintf("lua(%q, %q)", stickyTrafficHashScript, "header=X-User-Id")},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "sticky-traffic-loopback",
+							Weight:      100,
+						},
+					},
+				},
+				{
+					Predicates: []string{`Method("GET")`, `HeaderRegexp("X-Stackset-Sticky-Bucket", "^(?:[8-9a-f][0-9a-f])$")`, "Weight(3)"},
+				},
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.50, 1.00)", "Traffic(0.1)"},
+					Filters:    []string{`teeLoopback("bar/foo-v2")`},
+				},
+				{
+					Predicates: []string{`Method("GET")`, `HeaderRegexp("X-Stackset-Sticky-Bucket", "^(?:[8-9a-f][0-9a-f])$")`, "Weight(3)", "Traffic(0.1)"},
+					Filters:    []string{`teeLoopback("bar/foo-v2")`},
+				},
+			},
+		},
+		{
+			name:         "mirrored stack",
+			mirrorWeight: 10,
+			expectedRoutes: []rgv1.RouteGroupRouteSpec{
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.00, 0.00)"},
+				},
+				{
+					Predicates: []string{`Method("GET")`, `Tee("bar/foo-v1")`, "Weight(7)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "foo-v1",
+							Weight:      100,
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backendPort := intstr.FromInt(int(80))
+			c := &StackContainer{
+				Stack: &zv1.Stack{
+					ObjectMeta: testStackMeta,
+				},
+				routeGroupSpec: &zv1.RouteGroupSpec{
+					Hosts: []string{"example.teapot.zalan.do"},
+					Routes: []rgv1.RouteGroupRouteSpec{
+						{Predicates: []string{`Method("GET")`}},
+					},
+				},
+				stickyTraffic:     &zv1.StickyTrafficSpec{Header: "X-User-Id"},
+				mirrorWeight:      tc.mirrorWeight,
+				trafficMirrors:    tc.trafficMirrors,
+				segmentLowerLimit: tc.lowerLimit,
+				segmentUpperLimit: tc.upperLimit,
+				backendPort:       &backendPort,
+			}
+
+			rg, err := c.GenerateRouteGroupSegment()
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRoutes, rg.Spec.Routes)
+		})
+	}
+}
+
+func TestStackGenerateRouteGroupSegmentStickyTraffic(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		lowerLimit       float64
+		upperLimit       float64
+		expectedBackends []string
+		expectedRoutes   []rgv1.RouteGroupRouteSpec
+	}{
+		{
+			name:             "segment with traffic",
+			lowerLimit:       0.5,
+			upperLimit:       1,
+			expectedBackends: []string{"foo-v1", "sticky-traffic-loopback"},
+			expectedRoutes: []rgv1.RouteGroupRouteSpec{
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.50, 1.00)"},
+				},
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.50, 1.00)", `Cookie("session", ".")`, "Weight(1)"},
+					Filters:    []string{fmt.Sprintf("lua(%q, %q)", stickyTrafficHashScript, "cookie=session")},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "sticky-traffic-loopback",
+							Weight:      100,
+						},
+					},
+				},
+				{
+					Predicates: []string{`Method("GET")`, `HeaderRegexp("X-Stackset-Sticky-Bucket", "^(?:[8-9a-f][0-9a-f])$")`, "Weight(3)"},
+				},
+				{
+					Predicates: []string{`Method("GET")`, `Header("X-Canary", "v1")`, "Weight(5)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "foo-v1",
+							Weight:      100,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:             "segment without traffic",
+			expectedBackends: []string{"foo-v1"},
+			expectedRoutes: []rgv1.RouteGroupRouteSpec{
+				{
+					Predicates: []string{`Method("GET")`, "TrafficSegment(0.00, 0.00)"},
+				},
+				{
+					Predicates: []string{`Method("GET")`, `Header("X-Canary", "v1")`, "Weight(5)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "foo-v1",
+							Weight:      100,
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backendPort := intstr.FromInt(int(80))
+			c := &StackContainer{
+				Stack: &zv1.Stack{
+					ObjectMeta: testStackMeta,
+				},
+				routeGroupSpec: &zv1.RouteGroupSpec{
+					Hosts: []string{"example.teapot.zalan.do"},
+					Routes: []rgv1.RouteGroupRouteSpec{
+						{Predicates: []string{`Method("GET")`}},
+					},
+				},
+				canaryRoutes: []zv1.CanaryRoute{
+					{
+						StackName: "foo-v1",
+						Header:    &zv1.CanaryMatch{Name: "X-Canary", Value: "v1"},
+					},
+				},
+				stickyTraffic:     &zv1.StickyTrafficSpec{Cookie: "session"},
+				segmentLowerLimit: tc.lowerLimit,
+				segmentUpperLimit: tc.upperLimit,
+				backendPort:       &backendPort,
+			}
+
+			rg, err := c.GenerateRouteGroupSegment()
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRoutes, rg.Spec.Routes)
+
+			var backends []string
+			for _, backend := range rg.Spec.Backends {
+				backends = append(backends, backend.Name)
+			}
+			require.Equal(t, tc.expectedBackends, backends)
+
+			lowerLimit, upperLimit, err := GetSegmentLimits(rg.Spec.Routes[0].Predicates...)
+			require.NoError(t, err)
+			require.Equal(t, tc.lowerLimit, lowerLimit)
+			require.Equal(t, tc.upperLimit, upperLimit)
+		})
+	}
+}
+
 func TestGenerateRouteGroupSegmentWithSyncAnnotations(t *testing.T) {
 	for _, tc := range []struct {
 		rgSpec                      *zv1.RouteGroupSpec
@@ -1100,6 +1338,7 @@ func TestStackGenerateDeployment(t *testing.T) {
 		prescalingReplicas int32
 		deploymentReplicas int32
 		noTrafficSince     time.Time
+		pinnedMinReplicas  *int32
 		expectedReplicas   int32
 		maxUnavailable     int
 		maxSurge           int
@@ -1136,6 +1375,37 @@ func TestStackGenerateDeployment(t *testing.T) {
 			deploymentReplicas: 0,
 			expectedReplicas:   0,
 		},
+		{
+			name:               "pinned stack scaled down because it doesn't have traffic, deployment still running",
+			stackReplicas:      3,
+			deploymentReplicas: 3,
+			noTrafficSince:     time.Now().Add(-time.Hour),
+			pinnedMinReplicas:  wrapReplicas(1),
+			expectedReplicas:   1,
+		},
+		{
+			name:               "pinned stack scaled down because it doesn't have traffic, deployment already scaled down",
+			stackReplicas:      3,
+			deploymentReplicas: 0,
+			noTrafficSince:     time.Now().Add(-time.Hour),
+			pinnedMinReplicas:  wrapReplicas(2),
+			expectedReplicas:   2,
+		},
+		{
+			name:               "pinned stack doesn't exceed the stack replicas",
+			stackReplicas:      3,
+			deploymentReplicas: 3,
+			noTrafficSince:     time.Now().Add(-time.Hour),
+			pinnedMinReplicas:  wrapReplicas(5),
+			expectedReplicas:   3,
+		},
+		{
+			name:               "pinned stack scaled down to zero",
+			stackReplicas:      0,
+			deploymentReplicas: 3,
+			pinnedMinReplicas:  wrapReplicas(1),
+			expectedReplicas:   0,
+		},
 		{
 			name:               "stack running, deployment has zero replicas",
 			stackReplicas:      3,
@@ -1285,6 +1555,10 @@ func TestStackGenerateDeployment(t *testing.T) {
 				noTrafficSince:     tc.noTrafficSince,
 				scaledownTTL:       time.Minute,
 			}
+			if tc.pinnedMinReplicas != nil {
+				c.pinned = true
+				c.pinnedMinReplicas = *tc.pinnedMinReplicas
+			}
 			if tc.hpaEnabled {
 				c.Stack.Spec.StackSpec.Autoscaler = &zv1.Autoscaler{}
 			}
@@ -1528,6 +1802,7 @@ func TestGenerateStackStatus(t *testing.T) {
 		prescalingReplicas             int32
 		prescalingDesiredTrafficWeight float64
 		prescalingLastTrafficIncrease  time.Time
+		lastTrafficSwitch              time.Time
 	}{
 		{
 			name:                  "with traffic",
@@ -1535,6 +1810,7 @@ func TestGenerateStackStatus(t *testing.T) {
 			expectedLabelSelector: "",
 			actualTrafficWeight:   0.25,
 			desiredTrafficWeight:  0.75,
+			lastTrafficSwitch:     hourAgo,
 		},
 		{
 			name: "without traffic",
@@ -1574,6 +1850,7 @@ func TestGenerateStackStatus(t *testing.T) {
 				prescalingReplicas:             tc.prescalingReplicas,
 				prescalingDesiredTrafficWeight: tc.prescalingDesiredTrafficWeight,
 				prescalingLastTrafficIncrease:  tc.prescalingLastTrafficIncrease,
+				lastTrafficSwitch:              tc.lastTrafficSwitch,
 			}
 			status := c.GenerateStackStatus()
 			expected := &zv1.StackStatus{
@@ -1584,6 +1861,7 @@ func TestGenerateStackStatus(t *testing.T) {
 				UpdatedReplicas:      1,
 				DesiredReplicas:      4,
 				NoTrafficSince:       wrapTime(tc.noTrafficSince),
+				LastTrafficSwitch:    wrapTime(tc.lastTrafficSwitch),
 				LabelSelector:        tc.expectedLabelSelector,
 				Prescaling: zv1.PrescalingStatus{
 					Active:               tc.prescalingActive,
@@ -50,6 +50,32 @@ func sanitizeServicePorts(service *zv1.StackServiceSpec) *zv1.StackServiceSpec {
 	return service
 }
 
+// GenerateStackSpec returns the spec of a stack created from the current
+// stack template of the StackSet.
+func (ssc *StackSetContainer) GenerateStackSpec() *zv1.StackSpecInternal {
+	spec := &zv1.StackSpecInternal{}
+
+	parentSpec := ssc.StackSet.Spec.StackTemplate.Spec.StackSpec.DeepCopy()
+	if parentSpec.Service != nil {
+		parentSpec.Service = sanitizeServicePorts(parentSpec.Service)
+	}
+	spec.StackSpec = *parentSpec
+
+	if ssc.StackSet.Spec.Ingress != nil {
+		spec.Ingress = ssc.StackSet.Spec.Ingress.DeepCopy()
+	}
+
+	if ssc.StackSet.Spec.ExternalIngress != nil {
+		spec.ExternalIngress = ssc.StackSet.Spec.ExternalIngress.DeepCopy()
+	}
+
+	if ssc.StackSet.Spec.RouteGroup != nil {
+		spec.RouteGroup = ssc.StackSet.Spec.RouteGroup.DeepCopy()
+	}
+
+	return spec
+}
+
 // NewStack returns an (optional) stack that should be created
 func (ssc *StackSetContainer) NewStack() (*StackContainer, string) {
 	observedStackVersion := ssc.StackSet.Status.ObservedStackVersion
@@ -60,25 +86,7 @@ func (ssc *StackSetContainer) NewStack() (*StackContainer, string) {
 	// If the current stack doesn't exist, check that we haven't created it
 	// before. We shouldn't recreate it if it was removed for any reason.
 	if stack == nil && observedStackVersion != stackVersion {
-		spec := &zv1.StackSpecInternal{}
-
-		parentSpec := ssc.StackSet.Spec.StackTemplate.Spec.StackSpec.DeepCopy()
-		if parentSpec.Service != nil {
-			parentSpec.Service = sanitizeServicePorts(parentSpec.Service)
-		}
-		spec.StackSpec = *parentSpec
-
-		if ssc.StackSet.Spec.Ingress != nil {
-			spec.Ingress = ssc.StackSet.Spec.Ingress.DeepCopy()
-		}
-
-		if ssc.StackSet.Spec.ExternalIngress != nil {
-			spec.ExternalIngress = ssc.StackSet.Spec.ExternalIngress.DeepCopy()
-		}
-
-		if ssc.StackSet.Spec.RouteGroup != nil {
-			spec.RouteGroup = ssc.StackSet.Spec.RouteGroup.DeepCopy()
-		}
+		spec := ssc.GenerateStackSpec()
 
 		return &StackContainer{
 			Stack: &zv1.Stack{
@@ -112,17 +120,19 @@ func (ssc *StackSetContainer) NewStack() (*StackContainer, string) {
 
 // MarkExpiredStacks marks stacks that should be deleted
 func (ssc *StackSetContainer) MarkExpiredStacks() {
-	historyLimit := defaultStackLifecycleLimit
-	if ssc.StackSet.Spec.StackLifecycle.Limit != nil {
-		historyLimit = int(*ssc.StackSet.Spec.StackLifecycle.Limit)
-	}
+	historyLimit := stackLifecycleLimit(ssc.StackSet.Spec.StackLifecycle)
 
 	gcCandidates := make([]*StackContainer, 0, len(ssc.StackContainers))
 
 	for _, sc := range ssc.StackContainers {
-		// Stacks are considered for cleanup if we don't have RouteGroup nor an ingress or if the stack is scaled down because of inactivity
+		// Pinned stacks are never cleaned up
+		if sc.pinned {
+			continue
+		}
+
+		// Stacks are considered for cleanup if we don't have RouteGroup nor an ingress or if the stack is inactive
 		hasIngress := sc.routeGroupSpec != nil || sc.ingressSpec != nil || ssc.StackSet.Spec.ExternalIngress != nil
-		if !hasIngress || sc.ScaledDown() {
+		if !hasIngress || sc.inactive() {
 			gcCandidates = append(gcCandidates, sc)
 		}
 	}
@@ -217,6 +227,33 @@ func (ssc *StackSetContainer) GenerateRouteGroup() (*rgv1.RouteGroup, error) {
 		result.Spec.Backends = append(result.Spec.Backends, additionalBackend)
 	}
 
+	// route the requests matching the canary routes to their stacks
+	var canaries []zv1.CanaryRoute
+	for _, canary := range stackset.Spec.RouteGroup.CanaryRoutes {
+		if _, ok := stacks[canary.StackName]; ok {
+			canaries = append(canaries, canary)
+		}
+	}
+	canaryRoutes, err := generateCanaryRoutes(stackset.Spec.RouteGroup.Routes, canaries)
+	if err != nil {
+		return nil, err
+	}
+
+	// copy a share of the requests to the mirrored stacks
+	mirrors := ssc.trafficMirrors()
+	var mirroredRoutes []rgv1.RouteGroupRouteSpec
+	for _, mirror := range mirrors {
+		mirroredRoutes = append(mirroredRoutes, ssc.stackByName(mirror.stackName).generateMirroredRoutes(stackset.Spec.RouteGroup.Routes)...)
+	}
+	mirrorRoutes := generateMirrorRoutes(stackset.Spec.RouteGroup.Routes, mirrors)
+
+	if len(canaryRoutes) > 0 || len(mirrors) > 0 {
+		routes := append([]rgv1.RouteGroupRouteSpec{}, result.Spec.Routes...)
+		routes = append(routes, mirrorRoutes...)
+		routes = append(routes, canaryRoutes...)
+		result.Spec.Routes = append(routes, mirroredRoutes...)
+	}
+
 	// sort backends/defaultBackends to ensure have a consistent generated RoutGroup resource
 	sort.Slice(result.Spec.Backends, func(i, j int) bool {
 		return result.Spec.Backends[i].Name < result.Spec.Backends[j].Name
@@ -329,6 +366,10 @@ func (ssc *StackSetContainer) GenerateStackSetStatus() *zv1.StackSetStatus {
 		ReadyStacks:          0,
 		StacksWithTraffic:    0,
 		ObservedStackVersion: ssc.StackSet.Status.ObservedStackVersion,
+		ObservedGeneration:   ssc.observedGeneration(),
+		Rollout:              ssc.rolloutStatus,
+		ScheduledTraffic:     ssc.pendingScheduledTraffic(),
+		BlueGreen:            ssc.blueGreenStatus,
 	}
 	var traffic []*zv1.ActualTraffic
 
@@ -361,16 +402,32 @@ func (ssc *StackSetContainer) GenerateStackSetStatus() *zv1.StackSetStatus {
 	return result
 }
 
+// observedGeneration returns the generation of the StackSet if it and all
+// its stacks were reconciled without errors, and the previously observed
+// generation otherwise.
+func (ssc *StackSetContainer) observedGeneration() int64 {
+	if len(ssc.reconcileErrors) > 0 {
+		return ssc.StackSet.Status.ObservedGeneration
+	}
+	for _, sc := range ssc.StackContainers {
+		if len(sc.reconcileErrors) > 0 {
+			return ssc.StackSet.Status.ObservedGeneration
+		}
+	}
+	return ssc.StackSet.Generation
+}
+
 func (ssc *StackSetContainer) GenerateStackSetTraffic() []*zv1.DesiredTraffic {
 	var traffic []*zv1.DesiredTraffic
 	for _, sc := range ssc.StackContainers {
 		if sc.PendingRemoval {
 			continue
 		}
-		if sc.HasBackendPort() && sc.desiredTrafficWeight > 0 {
+		if sc.HasBackendPort() && (sc.desiredTrafficWeight > 0 || sc.mirrorWeight > 0) {
 			t := &zv1.DesiredTraffic{
 				StackName: sc.Name(),
 				Weight:    sc.desiredTrafficWeight,
+				Mirror:    sc.mirrorWeight,
 			}
 			traffic = append(traffic, t)
 		}
@@ -378,5 +435,5 @@ func (ssc *StackSetContainer) GenerateStackSetTraffic() []*zv1.DesiredTraffic {
 	sort.Slice(traffic, func(i, j int) bool {
 		return traffic[i].StackName < traffic[j].StackName
 	})
-	return traffic
+	return append(traffic, ssc.pendingScheduledTraffic()...)
 }
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"strconv"
 	"testing"
 	"time"
@@ -132,6 +133,39 @@ func TestExpiredStacks(t *testing.T) {
 			},
 			expected: nil,
 		},
+		{
+			name:       "GC'ing a mirrored stack without traffic",
+			limit:      1,
+			routegroup: true,
+			stacks: []*StackContainer{
+				testStack("stack1").createdAt(now.Add(-1 * time.Hour)).noTrafficSince(now.Add(-1 * time.Hour)).stack(),
+				testStack("stack2").createdAt(now.Add(-2 * time.Hour)).noTrafficSince(now.Add(-2 * time.Hour)).mirror(10).stack(),
+				testStack("stack3").createdAt(now.Add(-3 * time.Hour)).noTrafficSince(now.Add(-3 * time.Hour)).stack(),
+			},
+			expected: map[string]bool{"stack2": true, "stack3": true},
+		},
+		{
+			name:       "not GC'ing a pinned mirrored stack",
+			limit:      1,
+			routegroup: true,
+			stacks: []*StackContainer{
+				testStack("stack1").createdAt(now.Add(-1 * time.Hour)).noTrafficSince(now.Add(-1 * time.Hour)).stack(),
+				testStack("stack2").createdAt(now.Add(-2 * time.Hour)).noTrafficSince(now.Add(-2 * time.Hour)).mirror(10).pinned(1).stack(),
+				testStack("stack3").createdAt(now.Add(-3 * time.Hour)).noTrafficSince(now.Add(-3 * time.Hour)).stack(),
+			},
+			expected: map[string]bool{"stack3": true},
+		},
+		{
+			name:    "not GC'ing a pinned stack",
+			limit:   1,
+			ingress: true,
+			stacks: []*StackContainer{
+				testStack("stack1").createdAt(now.Add(-1 * time.Hour)).noTrafficSince(now.Add(-1 * time.Hour)).stack(),
+				testStack("stack2").createdAt(now.Add(-2 * time.Hour)).noTrafficSince(now.Add(-2 * time.Hour)).pinned(1).stack(),
+				testStack("stack3").createdAt(now.Add(-3 * time.Hour)).noTrafficSince(now.Add(-3 * time.Hour)).stack(),
+			},
+			expected: map[string]bool{"stack3": true},
+		},
 		{
 			name:         "not GC'ing a stack with no-traffic-since less than ScaledownTTLSeconds",
 			limit:        1,
@@ -576,6 +610,36 @@ func TestStackSetUpdateFromResourcesScaleDown(t *testing.T) {
 	}
 }
 
+func TestStackSetUpdateFromResourcesPinnedStacks(t *testing.T) {
+	c := dummyStacksetContainer()
+	c.StackSet.Spec.StackLifecycle.Pinned = []zv1.PinnedStack{
+		{StackName: "foo-v1"},
+		{StackName: "foo-v2", MinReplicas: wrapReplicas(3)},
+	}
+	c.StackContainers = map[types.UID]*StackContainer{
+		"v1": testStack("foo-v1").stack(),
+		"v2": testStack("foo-v2").stack(),
+		"v3": testStack("foo-v3").stack(),
+	}
+
+	err := c.UpdateFromResources()
+	require.NoError(t, err)
+
+	for name, expected := range map[string]struct {
+		pinned      bool
+		minReplicas int32
+	}{
+		"foo-v1": {pinned: true, minReplicas: defaultPinnedMinReplicas},
+		"foo-v2": {pinned: true, minReplicas: 3},
+		"foo-v3": {},
+	} {
+		sc := c.stackByName(name)
+		require.Equal(t, expected.pinned, sc.pinned, "stack %s", name)
+		require.Equal(t, expected.minReplicas, sc.pinnedMinReplicas, "stack %s", name)
+		require.Equal(t, expected.pinned, sc.GenerateStackStatus().Pinned, "stack %s", name)
+	}
+}
+
 func TestStackSetUpdateFromResourcesClusterDomain(t *testing.T) {
 	c := dummyStacksetContainer()
 	c.clusterDomains = []string{"foo.example.org"}
@@ -936,6 +1000,7 @@ func TestUpdateTrafficFromStackSet(t *testing.T) {
 		actualTraffic          []*zv1.ActualTraffic
 		expectedDesiredWeights map[string]float64
 		expectedActualWeights  map[string]float64
+		expectedMirrorWeights  map[string]float64
 	}{
 		{
 			name: "desired and actual weights are parsed correctly",
@@ -1003,6 +1068,50 @@ func TestUpdateTrafficFromStackSet(t *testing.T) {
 			expectedDesiredWeights: map[string]float64{"foo-v2": 50, "foo-v3": 50},
 			expectedActualWeights:  map[string]float64{"foo-v2": 25, "foo-v3": 75},
 		},
+		{
+			name: "mirror weights are parsed correctly",
+			desiredTraffic: []*zv1.DesiredTraffic{
+				{
+					StackName: "foo-v1",
+					Weight:    float64(100),
+				},
+				{
+					StackName: "foo-v2",
+					Mirror:    float64(10),
+				},
+			},
+			actualTraffic: []*zv1.ActualTraffic{
+				{
+					ServiceName: "foo-v1",
+					Weight:      float64(100),
+				},
+			},
+			expectedDesiredWeights: map[string]float64{"foo-v1": 100},
+			expectedActualWeights:  map[string]float64{"foo-v1": 100},
+			expectedMirrorWeights:  map[string]float64{"foo-v2": 10},
+		},
+		{
+			name: "scheduled weights are not applied",
+			desiredTraffic: []*zv1.DesiredTraffic{
+				{
+					StackName: "foo-v1",
+					Weight:    float64(100),
+				},
+				{
+					StackName: "foo-v2",
+					Weight:    float64(100),
+					NotBefore: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+				},
+			},
+			actualTraffic: []*zv1.ActualTraffic{
+				{
+					ServiceName: "foo-v1",
+					Weight:      float64(100),
+				},
+			},
+			expectedDesiredWeights: map[string]float64{"foo-v1": 100},
+			expectedActualWeights:  map[string]float64{"foo-v1": 100},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			stack1 := testStack("foo-v1").stack()
@@ -1049,6 +1158,7 @@ func TestUpdateTrafficFromStackSet(t *testing.T) {
 				require.Equal(t, tc.expectedDesiredWeights[sc.Name()], sc.desiredTrafficWeight, "desired stack %s", sc.Stack.Name)
 				require.Equal(t, tc.expectedActualWeights[sc.Name()], sc.actualTrafficWeight, "actual stack %s", sc.Stack.Name)
 				require.Equal(t, tc.expectedActualWeights[sc.Name()], sc.currentActualTrafficWeight, "current stack %s", sc.Stack.Name)
+				require.Equal(t, tc.expectedMirrorWeights[sc.Name()], sc.mirrorWeight, "mirror stack %s", sc.Stack.Name)
 			}
 		})
 	}
@@ -1112,6 +1222,57 @@ func TestGenerateStackSetStatus(t *testing.T) {
 	require.Equal(t, expected.Traffic, status.Traffic)
 }
 
+func TestGenerateObservedGeneration(t *testing.T) {
+	for _, tc := range []struct {
+		name                       string
+		stackSetError              error
+		stackError                 error
+		expectedStackSetGeneration int64
+		expectedStackGeneration    int64
+	}{
+		{
+			name:                       "reconciled successfully",
+			expectedStackSetGeneration: 3,
+			expectedStackGeneration:    5,
+		},
+		{
+			name:                       "failed to reconcile the stackset",
+			stackSetError:              errors.New("failed"),
+			expectedStackSetGeneration: 2,
+			expectedStackGeneration:    5,
+		},
+		{
+			name:                       "failed to reconcile a stack",
+			stackError:                 errors.New("failed"),
+			expectedStackSetGeneration: 2,
+			expectedStackGeneration:    4,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := testStack("foo-v1").ready(3).stack()
+			sc.Stack.Generation = 5
+			sc.Stack.Status.ObservedGeneration = 4
+			if tc.stackError != nil {
+				sc.ReportError("FailedManageStack", tc.stackError)
+			}
+
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					ObjectMeta: metav1.ObjectMeta{Generation: 3},
+					Status:     zv1.StackSetStatus{ObservedGeneration: 2},
+				},
+				StackContainers: map[types.UID]*StackContainer{"v1": sc},
+			}
+			if tc.stackSetError != nil {
+				c.ReportError("FailedManageStackSet", tc.stackSetError)
+			}
+
+			require.Equal(t, tc.expectedStackSetGeneration, c.GenerateStackSetStatus().ObservedGeneration)
+			require.Equal(t, tc.expectedStackGeneration, sc.GenerateStackStatus().ObservedGeneration)
+		})
+	}
+}
+
 func TestGenerateStackSetTraffic(t *testing.T) {
 	c := &StackSetContainer{
 		StackSet: &zv1.StackSet{
@@ -1125,6 +1286,7 @@ func TestGenerateStackSetTraffic(t *testing.T) {
 			"v3": testStack("v3").ready(3).stack(),
 			"v4": testStack("v4").stack(),
 			"v5": testStack("v5").ready(3).traffic(20, 10).stack(),
+			"v6": testStack("v6").ready(3).mirror(10).stack(),
 		},
 		backendWeightsAnnotationKey: traffic.DefaultBackendWeightsAnnotationKey,
 	}
@@ -1138,7 +1300,27 @@ func TestGenerateStackSetTraffic(t *testing.T) {
 			StackName: "v5",
 			Weight:    20,
 		},
+		{
+			StackName: "v6",
+			Mirror:    10,
+		},
+	}
+	require.Equal(t, expected, c.GenerateStackSetTraffic())
+
+	// pending scheduled switches are kept
+	notBefore := &metav1.Time{Time: time.Now().Add(time.Hour)}
+	c.scheduledTraffic = []*zv1.DesiredTraffic{
+		{
+			StackName: "v3",
+			Weight:    100,
+			NotBefore: notBefore,
+		},
 	}
+	expected = append(expected, &zv1.DesiredTraffic{
+		StackName: "v3",
+		Weight:    100,
+		NotBefore: notBefore,
+	})
 	require.Equal(t, expected, c.GenerateStackSetTraffic())
 }
 
@@ -1455,3 +1637,240 @@ func TestStackSetGenerateRouteGroup(t *testing.T) {
 	}
 	require.Equal(t, expected, routegroup)
 }
+
+func TestStackSetGenerateRouteGroupCanaryRoutes(t *testing.T) {
+	routes := []rgv1.RouteGroupRouteSpec{
+		{
+			PathSubtree: "/example",
+		},
+		{
+			Path: "/ok",
+			Backends: []rgv1.RouteGroupBackendReference{
+				{
+					BackendName: "shunt",
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		canaryRoutes   []zv1.CanaryRoute
+		expectedRoutes []rgv1.RouteGroupRouteSpec
+		expectError    bool
+	}{
+		{
+			name:           "no canary routes",
+			expectedRoutes: routes,
+		},
+		{
+			name: "header and cookie",
+			canaryRoutes: []zv1.CanaryRoute{
+				{
+					StackName: "foo-v2",
+					Header:    &zv1.CanaryMatch{Name: "X-Canary", Value: "v2"},
+				},
+				{
+					StackName: "foo-v1",
+					Header:    &zv1.CanaryMatch{Name: "X-Canary", Value: "v1"},
+					Cookie:    &zv1.CanaryMatch{Name: "canary", Value: "v1.0"},
+				},
+			},
+			expectedRoutes: []rgv1.RouteGroupRouteSpec{
+				routes[0],
+				routes[1],
+				{
+					PathSubtree: "/example",
+					Predicates:  []string{`Header("X-Canary", "v2")`, "Weight(5)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "foo-v2",
+							Weight:      100,
+						},
+					},
+				},
+				{
+					Path:       "/ok",
+					Predicates: []string{`Header("X-Canary", "v2")`, "Weight(5)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "shunt",
+						},
+					},
+				},
+				{
+					PathSubtree: "/example",
+					Predicates:  []string{`Header("X-Canary", "v1")`, `Cookie("canary", "^v1\\.0$")`, "Weight(5)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "foo-v1",
+							Weight:      100,
+						},
+					},
+				},
+				{
+					Path:       "/ok",
+					Predicates: []string{`Header("X-Canary", "v1")`, `Cookie("canary", "^v1\\.0$")`, "Weight(5)"},
+					Backends: []rgv1.RouteGroupBackendReference{
+						{
+							BackendName: "shunt",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "unknown stacks are ignored",
+			canaryRoutes: []zv1.CanaryRoute{
+				{
+					StackName: "foo-v3",
+					Header:    &zv1.CanaryMatch{Name: "X-Canary", Value: "v3"},
+				},
+			},
+			expectedRoutes: routes,
+		},
+		{
+			name: "canary route without header or cookie",
+			canaryRoutes: []zv1.CanaryRoute{
+				{
+					StackName: "foo-v2",
+				},
+			},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "foo",
+						Namespace: "bar",
+					},
+					Spec: zv1.StackSetSpec{
+						RouteGroup: &zv1.RouteGroupSpec{
+							Hosts: []string{"example.org"},
+							AdditionalBackends: []rgv1.RouteGroupBackend{
+								{
+									Name: "shunt",
+									Type: rgv1.ShuntRouteGroupBackend,
+								},
+							},
+							Routes:       routes,
+							BackendPort:  int(testPort),
+							CanaryRoutes: tc.canaryRoutes,
+						},
+					},
+				},
+				StackContainers: map[types.UID]*StackContainer{
+					"v1": testStack("foo-v1").traffic(100, 100).stack(),
+					"v2": testStack("foo-v2").stack(),
+				},
+			}
+
+			routegroup, err := c.GenerateRouteGroup()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRoutes, routegroup.Spec.Routes)
+			require.Equal(t, routes, c.StackSet.Spec.RouteGroup.Routes)
+		})
+	}
+}
+
+func TestStackSetGenerateRouteGroupTrafficMirrors(t *testing.T) {
+	routes := []rgv1.RouteGroupRouteSpec{
+		{
+			PathSubtree: "/example",
+		},
+	}
+
+	c := &StackSetContainer{
+		StackSet: &zv1.StackSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "foo",
+				Namespace: "bar",
+			},
+			Spec: zv1.StackSetSpec{
+				RouteGroup: &zv1.RouteGroupSpec{
+					Hosts:       []string{"example.org"},
+					Routes:      routes,
+					BackendPort: int(testPort),
+				},
+			},
+		},
+		StackContainers: map[types.UID]*StackContainer{
+			"v1": testStack("foo-v1").traffic(100, 100).stack(),
+			"v2": testStack("foo-v2").mirror(12.5).stack(),
+			"v3": testStack("foo-v3").mirror(50).noTrafficSince(hourAgo).pendingRemoval().stack(),
+		},
+	}
+	for _, sc := range c.StackContainers {
+		sc.Stack.Namespace = "bar"
+		sc.scaledownTTL = 10 * time.Minute
+	}
+
+	routegroup, err := c.GenerateRouteGroup()
+	require.NoError(t, err)
+	require.Equal(t, []rgv1.RouteGroupRouteSpec{
+		routes[0],
+		{
+			PathSubtree: "/example",
+			Predicates:  []string{"Traffic(0.125)"},
+			Filters:     []string{`teeLoopback("bar/foo-v2")`},
+		},
+		{
+			PathSubtree: "/example",
+			Predicates:  []string{`Tee("bar/foo-v2")`, "Weight(7)"},
+			Backends: []rgv1.RouteGroupBackendReference{
+				{
+					BackendName: "foo-v2",
+					Weight:      100,
+				},
+			},
+		},
+	}, routegroup.Spec.Routes)
+	require.Equal(t, routes, c.StackSet.Spec.RouteGroup.Routes)
+}
+
+func TestStackSetUpdateFromResourcesCanaryRoutes(t *testing.T) {
+	canary := zv1.CanaryRoute{
+		StackName: "foo-v2",
+		Header:    &zv1.CanaryMatch{Name: "X-Canary", Value: "v2"},
+	}
+
+	c := &StackSetContainer{
+		StackSet: &zv1.StackSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "foo",
+			},
+			Spec: zv1.StackSetSpec{
+				RouteGroup: &zv1.RouteGroupSpec{
+					Hosts:        []string{"example.org"},
+					BackendPort:  int(testPort),
+					CanaryRoutes: []zv1.CanaryRoute{canary},
+				},
+			},
+		},
+		StackContainers: map[types.UID]*StackContainer{
+			"v1": testStack("foo-v1").stack(),
+			"v2": testStack("foo-v2").stack(),
+		},
+	}
+
+	err := c.UpdateFromResources()
+	require.NoError(t, err)
+
+	for _, sc := range c.StackContainers {
+		sc.noTrafficSince = hourAgo
+	}
+
+	v1 := c.stackByName("foo-v1")
+	require.Empty(t, v1.canaryRoutes)
+	require.True(t, v1.ScaledDown())
+
+	v2 := c.stackByName("foo-v2")
+	require.Equal(t, []zv1.CanaryRoute{canary}, v2.canaryRoutes)
+	require.False(t, v2.ScaledDown())
+}
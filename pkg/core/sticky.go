@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+const (
+	// stickyTrafficBuckets is the number of buckets requests are assigned
+	// to by the hash of their sticky traffic key.
+	stickyTrafficBuckets = 256
+
+	// stickyTrafficBucketHeader is the header the bucket of a request is
+	// stored in before it's routed again.
+	stickyTrafficBucketHeader = "X-Stackset-Sticky-Bucket"
+
+	// stickyTrafficBackend is the name of the loopback backend of the
+	// routes hashing the sticky traffic key.
+	stickyTrafficBackend = "sticky-traffic-loopback"
+
+	// stickyTrafficHashScript is the Lua script storing the bucket of the
+	// request in the bucket header, as two hexadecimal digits. The key is
+	// read from the header or cookie passed as parameter. The hash is kept
+	// below 2^24, so that it stays exact with the floating point numbers of
+	// Lua.
+	stickyTrafficHashScript = "function request(ctx, params) " +
+		"local key = params.header and ctx.request.header[params.header] or ctx.request.cookie[params.cookie] or '' " +
+		"local hash = 0 " +
+		"for i = 1, #key do hash = (hash * 31 + key:byte(i)) % 16777213 end " +
+		"ctx.request.header['" + stickyTrafficBucketHeader + "'] = string.format('%02x', hash % 256) " +
+		"end"
+)
+
+// stickyTrafficKeyPredicate returns the Skipper predicate matching the
+// requests with a sticky traffic key, and the Lua filter assigning them to a
+// bucket by the hash of the key.
+func stickyTrafficKeyPredicate(sticky *zv1.StickyTrafficSpec) (string, string, error) {
+	if (sticky.Header == "") == (sticky.Cookie == "") {
+		return "", "", fmt.Errorf("invalid sticky traffic: exactly one of header or cookie must be specified")
+	}
+
+	if sticky.Header != "" {
+		return fmt.Sprintf("HeaderRegexp(%q, %q)", sticky.Header, "."),
+			fmt.Sprintf("lua(%q, %q)", stickyTrafficHashScript, "header="+sticky.Header), nil
+	}
+	return fmt.Sprintf("Cookie(%q, %q)", sticky.Cookie, "."),
+		fmt.Sprintf("lua(%q, %q)", stickyTrafficHashScript, "cookie="+sticky.Cookie), nil
+}
+
+// stickyTrafficPredicate returns the Skipper predicate matching the requests
+// whose bucket falls into the traffic segment. It returns an empty predicate
+// if no bucket belongs to the segment.
+func stickyTrafficPredicate(lowerLimit, upperLimit float64) string {
+	// Use the limits of the TrafficSegment predicate, so that the buckets
+	// are distributed like the random requests.
+	lowerLimit = math.Round(lowerLimit*100) / 100
+	upperLimit = math.Round(upperLimit*100) / 100
+
+	// A bucket b belongs to the segment if lowerLimit <= b/256 < upperLimit
+	from := int(math.Ceil(lowerLimit * stickyTrafficBuckets))
+	to := int(math.Ceil(upperLimit*stickyTrafficBuckets)) - 1
+	if to >= stickyTrafficBuckets {
+		to = stickyTrafficBuckets - 1
+	}
+	if from > to {
+		return ""
+	}
+
+	return fmt.Sprintf("HeaderRegexp(%q, %q)", stickyTrafficBucketHeader, "^(?:"+hexBucketsPattern(from, to)+")$")
+}
+
+// hexBucketsPattern returns a regular expression matching the two digit
+// hexadecimal numbers from the first to the last bucket, e.g. 1[3-9a-f]|2[0-9]
+// for the buckets 0x13 to 0x29.
+func hexBucketsPattern(from, to int) string {
+	firstHigh, lastHigh := from/16, to/16
+	if firstHigh == lastHigh {
+		return hexDigitsPattern(firstHigh, firstHigh) + hexDigitsPattern(from%16, to%16)
+	}
+
+	var alternatives []string
+
+	// Buckets before the first complete range of the low digit
+	if from%16 != 0 {
+		alternatives = append(alternatives, hexDigitsPattern(firstHigh, firstHigh)+hexDigitsPattern(from%16, 15))
+		firstHigh++
+	}
+
+	// Buckets after the last complete range of the low digit
+	var last string
+	if to%16 != 15 {
+		last = hexDigitsPattern(lastHigh, lastHigh) + hexDigitsPattern(0, to%16)
+		lastHigh--
+	}
+
+	if firstHigh <= lastHigh {
+		alternatives = append(alternatives, hexDigitsPattern(firstHigh, lastHigh)+hexDigitsPattern(0, 15))
+	}
+	if last != "" {
+		alternatives = append(alternatives, last)
+	}
+	return strings.Join(alternatives, "|")
+}
+
+// hexDigitsPattern returns a regular expression matching a single hexadecimal
+// digit between from and to.
+func hexDigitsPattern(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("%x", from)
+	}
+
+	var ranges []string
+	if from <= 9 {
+		ranges = append(ranges, hexDigitsRange(from, min(to, 9)))
+	}
+	if to >= 10 {
+		ranges = append(ranges, hexDigitsRange(max(from, 10), to))
+	}
+	return "[" + strings.Join(ranges, "") + "]"
+}
+
+func hexDigitsRange(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("%x", from)
+	}
+	return fmt.Sprintf("%x-%x", from, to)
+}
+
+// generateStickyRoutes returns the routes for the requests with a sticky
+// traffic key. For every route of the spec there is a copy matching the
+// requests in the traffic segment of the stack, which hashes the key into a
+// bucket and routes the request again, and a copy matching the requests whose
+// bucket falls into the traffic segment of the stack. The filters and
+// backends of the route only apply to the latter.
+func (sc *StackContainer) generateStickyRoutes(routes []rgv1.RouteGroupRouteSpec) ([]rgv1.RouteGroupRouteSpec, []rgv1.RouteGroupRouteSpec, error) {
+	if sc.stickyTraffic == nil {
+		return nil, nil, nil
+	}
+
+	keyPredicate, hashFilter, err := stickyTrafficKeyPredicate(sc.stickyTraffic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	predicate := stickyTrafficPredicate(sc.segmentLowerLimit, sc.segmentUpperLimit)
+	if predicate == "" {
+		return nil, nil, nil
+	}
+
+	hashRoutes := make([]rgv1.RouteGroupRouteSpec, 0, len(routes))
+	stickyRoutes := make([]rgv1.RouteGroupRouteSpec, 0, len(routes))
+	for _, route := range routes {
+		hashRoute := *route.DeepCopy()
+		hashRoute.Predicates = append(hashRoute.Predicates, sc.trafficSegment(), keyPredicate, hashRouteWeightPredicate)
+		hashRoute.Filters = []string{hashFilter}
+		hashRoute.Backends = []rgv1.RouteGroupBackendReference{
+			{
+				BackendName: stickyTrafficBackend,
+				Weight:      100,
+			},
+		}
+		hashRoutes = append(hashRoutes, hashRoute)
+
+		stickyRoute := *route.DeepCopy()
+		stickyRoute.Predicates = append(stickyRoute.Predicates, predicate, stickyRouteWeightPredicate)
+		stickyRoutes = append(stickyRoutes, stickyRoute)
+	}
+	return hashRoutes, stickyRoutes, nil
+}
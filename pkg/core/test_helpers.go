@@ -71,6 +71,11 @@ func (f *testStackFactory) traffic(desiredTrafficWeight, actualTrafficWeight flo
 	return f
 }
 
+func (f *testStackFactory) mirror(mirrorWeight float64) *testStackFactory {
+	f.container.mirrorWeight = mirrorWeight
+	return f
+}
+
 func (f *testStackFactory) currentActualTrafficWeight(weight float64) *testStackFactory {
 	f.container.currentActualTrafficWeight = weight
 	return f
@@ -93,6 +98,17 @@ func (f *testStackFactory) noTrafficSince(since time.Time) *testStackFactory {
 	return f
 }
 
+func (f *testStackFactory) lastTrafficSwitch(switched time.Time) *testStackFactory {
+	f.container.lastTrafficSwitch = switched
+	return f
+}
+
+func (f *testStackFactory) pinned(minReplicas int32) *testStackFactory {
+	f.container.pinned = true
+	f.container.pinnedMinReplicas = minReplicas
+	return f
+}
+
 func (f *testStackFactory) pendingRemoval() *testStackFactory {
 	f.container.PendingRemoval = true
 	return f
@@ -299,10 +299,22 @@ func (ssc *StackSetContainer) ManageTraffic(currentTimestamp time.Time) error {
 			sc.prescalingActive = false
 			sc.prescalingReplicas = 0
 			sc.prescalingLastTrafficIncrease = time.Time{}
+			sc.trafficRolledBack = false
 		}
 		return nil
 	}
 
+	// Blue/green deployments don't use the traffic weights
+	if ssc.StackSet.Spec.BlueGreen != nil {
+		err := ssc.manageBlueGreenTraffic(currentTimestamp)
+		ssc.updateTrafficTimestamps(currentTimestamp)
+		ssc.updateTrafficMirrors()
+		return err
+	}
+
+	// Apply the scheduled traffic switch which is due. Proceed on errors.
+	scheduleErr := ssc.applyScheduledTraffic(currentTimestamp)
+
 	stacks := make(map[string]*StackContainer)
 	for _, stack := range ssc.StackContainers {
 		stacks[stack.Name()] = stack
@@ -339,6 +351,12 @@ func (ssc *StackSetContainer) ManageTraffic(currentTimestamp time.Time) error {
 		stack.desiredTrafficWeight = desiredWeights[stackName]
 		stack.actualTrafficWeight = actualWeights[stackName]
 		stack.minReadyPercent = minReadyPercent
+
+		// The rollback was overridden by switching traffic to the stack
+		// again.
+		if stack.desiredTrafficWeight > 0 {
+			stack.trafficRolledBack = false
+		}
 	}
 
 	// Run the traffic reconciler which will update the actual weights according to the desired weights. The resulting
@@ -360,16 +378,45 @@ func (ssc *StackSetContainer) ManageTraffic(currentTimestamp time.Time) error {
 	for stackName, stack := range stacks {
 		stack.actualTrafficWeight = actualWeights[stackName]
 	}
+	ssc.completeTrafficRollback()
+
+	ssc.updateTrafficTimestamps(currentTimestamp)
+	ssc.updateTrafficMirrors()
+	if scheduleErr != nil {
+		return scheduleErr
+	}
+	return err
+}
 
-	// update NoTrafficSince
+// completeTrafficRollback resets the rollback of the stacks once the actual
+// traffic of all stacks matches their desired traffic. Until then the traffic
+// isn't rate limited, even if the rollback takes several reconciliations,
+// e.g. because the previous stack isn't ready yet.
+func (ssc *StackSetContainer) completeTrafficRollback() {
+	for _, sc := range ssc.StackContainers {
+		if sc.trafficSwitching() {
+			return
+		}
+	}
+	for _, sc := range ssc.StackContainers {
+		sc.trafficRolledBack = false
+	}
+}
+
+// updateTrafficTimestamps updates LastTrafficSwitch and NoTrafficSince of the
+// stacks.
+func (ssc *StackSetContainer) updateTrafficTimestamps(currentTimestamp time.Time) {
 	for _, stack := range ssc.StackContainers {
+		if stack.actualTrafficWeight != stack.currentActualTrafficWeight {
+			stack.lastTrafficSwitch = currentTimestamp
+		}
+
 		if stack.HasTraffic() {
 			stack.noTrafficSince = time.Time{}
 		} else if stack.noTrafficSince.IsZero() {
 			stack.noTrafficSince = currentTimestamp
 		}
 	}
-	return err
 }
 
 // ComputeTrafficSegments returns the stack segments necessary to fulfill the
@@ -426,22 +473,32 @@ func (ssc *StackSetContainer) ComputeTrafficSegments() ([]types.UID, error) {
 		index += w
 	}
 
-	// Add new stacks, previously with no traffic
-	for id, w := range newWeights {
+	// Add new stacks, previously with no traffic, ordered by their UID to
+	// get the same segments in every reconciliation
+	newStacks := make([]types.UID, 0, len(newWeights))
+	for id := range newWeights {
 		if !existingStacks[id] {
-			s, err := newTrafficSegment(id, ssc.StackContainers[id])
-			if err != nil {
-				return nil, err
-			}
-			err = s.setLimits(index, index+w)
-			if err != nil {
-				return nil, err
-			}
+			newStacks = append(newStacks, id)
+		}
+	}
+	sort.Slice(newStacks, func(i, j int) bool {
+		return newStacks[i] < newStacks[j]
+	})
 
-			weightDiffs[id] = s.weight()
-			changes = append(changes, *s)
-			index += w
+	for _, id := range newStacks {
+		w := newWeights[id]
+		s, err := newTrafficSegment(id, ssc.StackContainers[id])
+		if err != nil {
+			return nil, err
+		}
+		err = s.setLimits(index, index+w)
+		if err != nil {
+			return nil, err
 		}
+
+		weightDiffs[id] = s.weight()
+		changes = append(changes, *s)
+		index += w
 	}
 
 	// Sorts descending by weight diff, to make sure we apply growing segments
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimitedTrafficReconciler is a traffic reconciler that limits how fast
+// the actual traffic weights move towards the desired ones, giving caches and
+// connection pools of the stacks time to warm up. It doesn't check whether the
+// stacks are ready and is meant to be chained after a reconciler which does,
+// e.g. to prescale stacks before switching traffic. Rolling back the traffic
+// of a stack because of a failed analysis isn't rate limited until the
+// rollback is complete.
+type RateLimitedTrafficReconciler struct {
+	// MaxWeightChange is the maximum change of the actual traffic weight
+	// of a stack in percentage points per Interval.
+	MaxWeightChange float64
+	// Interval is the minimum time between two traffic switches.
+	Interval time.Duration
+}
+
+func (r RateLimitedTrafficReconciler) Reconcile(stacks map[string]*StackContainer, currentTimestamp time.Time) error {
+	targetWeights := make(map[string]float64, len(stacks))
+	lastTrafficSwitch := time.Time{}
+	rolledBack := false
+	for stackName, stack := range stacks {
+		targetWeights[stackName] = stack.desiredTrafficWeight
+		if stack.lastTrafficSwitch.After(lastTrafficSwitch) {
+			lastTrafficSwitch = stack.lastTrafficSwitch
+		}
+		rolledBack = rolledBack || stack.trafficRolledBack
+	}
+	normalizeWeights(targetWeights)
+
+	// Move the traffic away from a stack which failed its analysis at once
+	if rolledBack {
+		for stackName, stack := range stacks {
+			stack.actualTrafficWeight = targetWeights[stackName]
+		}
+		return nil
+	}
+
+	// Find the largest change between the actual and the target weights
+	maxChange := 0.0
+	for stackName, stack := range stacks {
+		maxChange = math.Max(maxChange, math.Abs(targetWeights[stackName]-stack.actualTrafficWeight))
+	}
+
+	if maxChange == 0 {
+		return nil
+	}
+
+	// Keep the current weights until the interval has passed since the
+	// last switch
+	if !lastTrafficSwitch.IsZero() && currentTimestamp.Sub(lastTrafficSwitch) < r.Interval {
+		return nil
+	}
+
+	// Move all weights proportionally towards the target weights, so that
+	// no stack changes by more than MaxWeightChange and the weights still
+	// add up to 100. The weights are whole percentages, so the stacks
+	// change by at least one percentage point.
+	factor := 1.0
+	if maxWeightChange := math.Max(math.Floor(r.MaxWeightChange), 1); maxChange > maxWeightChange {
+		factor = maxWeightChange / maxChange
+	}
+
+	newWeights := make(map[string]float64, len(stacks))
+	for stackName, stack := range stacks {
+		current := stack.actualTrafficWeight
+		newWeights[stackName] = current + (targetWeights[stackName]-current)*factor
+	}
+	roundWeights(newWeights)
+
+	for stackName, stack := range stacks {
+		stack.actualTrafficWeight = newWeights[stackName]
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+// applyScheduledTraffic applies the latest scheduled traffic switch which is
+// due at currentTimestamp, replacing the desired traffic of all stacks.
+// Earlier switches are superseded by it. Applied switches are dropped from
+// the spec, so that only the pending ones remain.
+func (ssc *StackSetContainer) applyScheduledTraffic(currentTimestamp time.Time) error {
+	var due time.Time
+	for _, traffic := range ssc.scheduledTraffic {
+		notBefore := traffic.NotBefore.Time
+		if !notBefore.After(currentTimestamp) && notBefore.After(due) {
+			due = notBefore
+		}
+	}
+	if due.IsZero() {
+		return nil
+	}
+
+	weights := make(map[string]float64)
+	mirrorWeights := make(map[string]float64)
+	for _, traffic := range ssc.scheduledTraffic {
+		if traffic.NotBefore.Time.Equal(due) && ssc.stackByName(traffic.StackName) != nil {
+			weights[traffic.StackName] = traffic.Weight
+			mirrorWeights[traffic.StackName] = traffic.Mirror
+		}
+	}
+
+	// Keep the switch, so that it's applied once the stacks exist or the
+	// spec is fixed.
+	if allZero(weights) {
+		return fmt.Errorf("scheduled traffic switch at %s doesn't switch traffic to any existing stack", due.Format(time.RFC3339))
+	}
+
+	for _, sc := range ssc.StackContainers {
+		sc.desiredTrafficWeight = weights[sc.Name()]
+		sc.mirrorWeight = mirrorWeights[sc.Name()]
+	}
+
+	var pending []*zv1.DesiredTraffic
+	for _, traffic := range ssc.scheduledTraffic {
+		if traffic.NotBefore.Time.After(due) {
+			pending = append(pending, traffic)
+		}
+	}
+	ssc.scheduledTraffic = pending
+	return nil
+}
+
+// pendingScheduledTraffic returns the traffic of the scheduled switches which
+// haven't been applied yet, ordered by time and stack name.
+func (ssc *StackSetContainer) pendingScheduledTraffic() []*zv1.DesiredTraffic {
+	var result []*zv1.DesiredTraffic
+	for _, traffic := range ssc.scheduledTraffic {
+		result = append(result, traffic.DeepCopy())
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].NotBefore.Equal(result[j].NotBefore) {
+			return result[i].NotBefore.Before(result[j].NotBefore)
+		}
+		return result[i].StackName < result[j].StackName
+	})
+	return result
+}
@@ -9,15 +9,19 @@ import (
 
 // SimpleTrafficReconciler is the most simple traffic reconciler which
 // implements the default traffic switching supported in the
-// stackset-controller.
-type SimpleTrafficReconciler struct{}
+// stackset-controller: traffic is only switched to stacks which are ready.
+type SimpleTrafficReconciler struct {
+	// MinReadyPercent overrides the minimum percentage of ready pods
+	// configured for the stacks if set.
+	MinReadyPercent int
+}
 
-func (SimpleTrafficReconciler) Reconcile(stacks map[string]*StackContainer, currentTimestamp time.Time) error {
+func (r SimpleTrafficReconciler) Reconcile(stacks map[string]*StackContainer, currentTimestamp time.Time) error {
 	actualWeights := make(map[string]float64, len(stacks))
 
 	var nonReadyStacks []string
 	for stackName, stack := range stacks {
-		if stack.desiredTrafficWeight > stack.actualTrafficWeight && !stack.IsReady() {
+		if stack.desiredTrafficWeight > stack.actualTrafficWeight && !r.isReady(stack) {
 			nonReadyStacks = append(nonReadyStacks, stackName)
 		}
 		actualWeights[stackName] = stack.desiredTrafficWeight
@@ -37,3 +41,12 @@ func (SimpleTrafficReconciler) Reconcile(stacks map[string]*StackContainer, curr
 
 	return nil
 }
+
+// isReady returns true if the stack is ready, taking the MinReadyPercent
+// override into account.
+func (r SimpleTrafficReconciler) isReady(stack *StackContainer) bool {
+	if r.MinReadyPercent == 0 {
+		return stack.IsReady()
+	}
+	return stack.isReadyWith(normalizeMinReadyPercent(r.MinReadyPercent))
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+const (
+	// ReadinessTrafficReconcilerType only switches traffic to ready stacks.
+	ReadinessTrafficReconcilerType = "Readiness"
+	// PrescalingTrafficReconcilerType scales up stacks before switching
+	// traffic to them.
+	PrescalingTrafficReconcilerType = "Prescaling"
+	// RateLimitTrafficReconcilerType limits how fast traffic is switched.
+	RateLimitTrafficReconcilerType = "RateLimit"
+
+	// DefaultResetHPAMinReplicasDelay is the default time after the last
+	// traffic increase when the HPA minReplicas of a prescaled stack is
+	// reset.
+	DefaultResetHPAMinReplicasDelay = 10 * time.Minute
+	// DefaultTrafficRateLimitInterval is the default minimum time between
+	// two rate limited traffic switches.
+	DefaultTrafficRateLimitInterval = time.Minute
+)
+
+// TrafficReconcilerFactory creates a traffic reconciler from its
+// configuration in a traffic strategy.
+type TrafficReconcilerFactory func(spec zv1.TrafficReconcilerSpec) (TrafficReconciler, error)
+
+var (
+	trafficReconcilersMutex sync.RWMutex
+	trafficReconcilers      = map[string]TrafficReconcilerFactory{
+		ReadinessTrafficReconcilerType:  newReadinessTrafficReconciler,
+		PrescalingTrafficReconcilerType: newPrescalingTrafficReconciler,
+		RateLimitTrafficReconcilerType:  newRateLimitedTrafficReconciler,
+	}
+)
+
+// RegisterTrafficReconciler registers a traffic reconciler type which can be
+// used in the traffic strategy of a StackSet. Registering an existing type
+// replaces it.
+func RegisterTrafficReconciler(reconcilerType string, factory TrafficReconcilerFactory) {
+	trafficReconcilersMutex.Lock()
+	defer trafficReconcilersMutex.Unlock()
+	trafficReconcilers[reconcilerType] = factory
+}
+
+// NewTrafficReconciler creates the traffic reconciler for a traffic strategy.
+// Strategies with several reconcilers result in a TrafficReconcilerChain,
+// while strategies without any fall back to the SimpleTrafficReconciler.
+func NewTrafficReconciler(strategy *zv1.TrafficStrategy) (TrafficReconciler, error) {
+	if strategy == nil || len(strategy.Reconcilers) == 0 {
+		return &SimpleTrafficReconciler{}, nil
+	}
+
+	trafficReconcilersMutex.RLock()
+	defer trafficReconcilersMutex.RUnlock()
+
+	chain := make(TrafficReconcilerChain, 0, len(strategy.Reconcilers))
+	for i, spec := range strategy.Reconcilers {
+		factory, ok := trafficReconcilers[spec.Type]
+		if !ok {
+			return nil, fmt.Errorf("traffic reconciler %d: unknown type %q", i, spec.Type)
+		}
+
+		reconciler, err := factory(spec)
+		if err != nil {
+			return nil, fmt.Errorf("traffic reconciler %d (%s): %w", i, spec.Type, err)
+		}
+		chain = append(chain, reconciler)
+	}
+
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+// TrafficReconcilerChain is a traffic reconciler running several reconcilers
+// in order. The actual traffic weights computed by a reconciler are used as
+// the desired weights of the next one, while every reconciler starts from the
+// actual weights the chain started with. The chain stops at the first error.
+//
+// For example prescaling stacks and then rate limiting the switch gradually
+// moves traffic to the prescaled stacks, while rate limiting first prescales
+// the stacks only for the next step of the switch.
+type TrafficReconcilerChain []TrafficReconciler
+
+func (c TrafficReconcilerChain) Reconcile(stacks map[string]*StackContainer, currentTimestamp time.Time) error {
+	desiredWeights := make(map[string]float64, len(stacks))
+	actualWeights := make(map[string]float64, len(stacks))
+	for stackName, stack := range stacks {
+		desiredWeights[stackName] = stack.desiredTrafficWeight
+		actualWeights[stackName] = stack.actualTrafficWeight
+	}
+
+	// Always restore the desired weights, they're part of the StackSet
+	// status.
+	defer func() {
+		for stackName, stack := range stacks {
+			stack.desiredTrafficWeight = desiredWeights[stackName]
+		}
+	}()
+
+	for i, reconciler := range c {
+		err := reconciler.Reconcile(stacks, currentTimestamp)
+		if err != nil {
+			for stackName, stack := range stacks {
+				stack.actualTrafficWeight = actualWeights[stackName]
+			}
+			return err
+		}
+
+		if i == len(c)-1 {
+			break
+		}
+
+		for stackName, stack := range stacks {
+			stack.desiredTrafficWeight = stack.actualTrafficWeight
+			stack.actualTrafficWeight = actualWeights[stackName]
+		}
+	}
+
+	return nil
+}
+
+func newReadinessTrafficReconciler(spec zv1.TrafficReconcilerSpec) (TrafficReconciler, error) {
+	reconciler := &SimpleTrafficReconciler{}
+	if spec.Readiness != nil {
+		if spec.Readiness.MinReadyPercent < 0 || spec.Readiness.MinReadyPercent > 100 {
+			return nil, fmt.Errorf("invalid minReadyPercent %d", spec.Readiness.MinReadyPercent)
+		}
+		reconciler.MinReadyPercent = spec.Readiness.MinReadyPercent
+	}
+	return reconciler, nil
+}
+
+func newPrescalingTrafficReconciler(spec zv1.TrafficReconcilerSpec) (TrafficReconciler, error) {
+	reconciler := &PrescalingTrafficReconciler{
+		ResetHPAMinReplicasTimeout: DefaultResetHPAMinReplicasDelay,
+	}
+	if spec.Prescaling != nil && spec.Prescaling.ResetHPAMinReplicasDelay != nil {
+		reconciler.ResetHPAMinReplicasTimeout = spec.Prescaling.ResetHPAMinReplicasDelay.Duration
+	}
+	return reconciler, nil
+}
+
+func newRateLimitedTrafficReconciler(spec zv1.TrafficReconcilerSpec) (TrafficReconciler, error) {
+	if spec.RateLimit == nil {
+		return nil, errors.New("missing rateLimit configuration")
+	}
+	if spec.RateLimit.MaxWeightChange <= 0 {
+		return nil, fmt.Errorf("invalid maxWeightChange %g", spec.RateLimit.MaxWeightChange)
+	}
+
+	reconciler := &RateLimitedTrafficReconciler{
+		MaxWeightChange: spec.RateLimit.MaxWeightChange,
+		Interval:        DefaultTrafficRateLimitInterval,
+	}
+	if spec.RateLimit.Interval != nil {
+		if spec.RateLimit.Interval.Duration <= 0 {
+			return nil, fmt.Errorf("invalid interval %s", spec.RateLimit.Interval.Duration)
+		}
+		reconciler.Interval = spec.RateLimit.Interval.Duration
+	}
+	return reconciler, nil
+}
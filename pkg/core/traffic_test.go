@@ -2,8 +2,12 @@ package core
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -968,6 +972,555 @@ func TestTrafficSwitchNoTrafficSince(t *testing.T) {
 	}
 }
 
+func TestTrafficSwitchMirror(t *testing.T) {
+	c := StackSetContainer{
+		StackSet: &zv1.StackSet{
+			Spec: zv1.StackSetSpec{
+				RouteGroup: &zv1.RouteGroupSpec{},
+			},
+		},
+		StackContainers: map[types.UID]*StackContainer{
+			"foo-v1": testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+			"foo-v2": testStack("foo-v2").ready(3).mirror(10).stack(),
+			"foo-v3": testStack("foo-v3").ready(3).mirror(5).noTrafficSince(hourAgo).stack(),
+			"foo-v4": testStack("foo-v4").ready(3).traffic(0, 0).stack(),
+			"foo-v5": testStack("foo-v5").ready(3).mirror(5).pendingRemoval().stack(),
+		},
+		TrafficReconciler: SimpleTrafficReconciler{},
+	}
+	for _, sc := range c.StackContainers {
+		sc.Stack.Namespace = "bar"
+		sc.scaledownTTL = 10 * time.Minute
+	}
+
+	switchTimestamp := time.Now()
+	err := c.ManageTraffic(switchTimestamp)
+	require.NoError(t, err)
+
+	require.Equal(t, []trafficMirror{
+		{stackName: "foo-v2", tag: "bar/foo-v2", percent: 10},
+		{stackName: "foo-v3", tag: "bar/foo-v3", percent: 5},
+	}, c.StackContainers["foo-v1"].trafficMirrors)
+	require.Empty(t, c.StackContainers["foo-v2"].trafficMirrors)
+	require.Empty(t, c.StackContainers["foo-v4"].trafficMirrors)
+
+	require.True(t, c.StackContainers["foo-v2"].mirroring())
+	require.Equal(t, switchTimestamp, c.StackContainers["foo-v2"].noTrafficSince, "mirrored stacks don't have traffic")
+	require.True(t, c.StackContainers["foo-v3"].mirroring())
+	require.False(t, c.StackContainers["foo-v3"].ScaledDown(), "mirrored stacks aren't scaled down")
+	require.False(t, c.StackContainers["foo-v5"].mirroring(), "stacks pending removal aren't mirrored to")
+
+	// Switching traffic to a mirrored stack stops mirroring
+	c.StackContainers["foo-v1"].desiredTrafficWeight = 0
+	c.StackContainers["foo-v2"].desiredTrafficWeight = 100
+	err = c.ManageTraffic(switchTimestamp)
+	require.NoError(t, err)
+	require.False(t, c.StackContainers["foo-v2"].mirroring())
+	require.Empty(t, c.StackContainers["foo-v1"].trafficMirrors)
+	require.Equal(t, []trafficMirror{{stackName: "foo-v3", tag: "bar/foo-v3", percent: 5}}, c.StackContainers["foo-v2"].trafficMirrors)
+}
+
+func TestTrafficSwitchScheduled(t *testing.T) {
+	now := time.Now()
+	scheduled := func(stackName string, weight float64, notBefore time.Time) *zv1.DesiredTraffic {
+		return &zv1.DesiredTraffic{
+			StackName: stackName,
+			Weight:    weight,
+			NotBefore: &metav1.Time{Time: notBefore},
+		}
+	}
+
+	for _, tc := range []struct {
+		name             string
+		scheduledTraffic []*zv1.DesiredTraffic
+		expectedDesired  map[string]float64
+		expectedPending  []*zv1.DesiredTraffic
+		expectError      bool
+	}{
+		{
+			name: "switch isn't due yet",
+			scheduledTraffic: []*zv1.DesiredTraffic{
+				scheduled("foo-v2", 100, now.Add(time.Hour)),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 100, "foo-v2": 0, "foo-v3": 0},
+			expectedPending: []*zv1.DesiredTraffic{
+				scheduled("foo-v2", 100, now.Add(time.Hour)),
+			},
+		},
+		{
+			name: "due switch replaces the desired traffic",
+			scheduledTraffic: []*zv1.DesiredTraffic{
+				scheduled("foo-v3", 100, now.Add(time.Hour)),
+				scheduled("foo-v3", 25, now.Add(-time.Minute)),
+				scheduled("foo-v2", 75, now.Add(-time.Minute)),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 75, "foo-v3": 25},
+			expectedPending: []*zv1.DesiredTraffic{
+				scheduled("foo-v3", 100, now.Add(time.Hour)),
+			},
+		},
+		{
+			name: "latest due switch supersedes the earlier ones",
+			scheduledTraffic: []*zv1.DesiredTraffic{
+				scheduled("foo-v2", 100, now.Add(-time.Hour)),
+				scheduled("foo-v3", 100, now),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 0, "foo-v2": 0, "foo-v3": 100},
+		},
+		{
+			name: "switch without existing stacks is kept",
+			scheduledTraffic: []*zv1.DesiredTraffic{
+				scheduled("foo-v4", 100, now.Add(-time.Minute)),
+			},
+			expectedDesired: map[string]float64{"foo-v1": 100, "foo-v2": 0, "foo-v3": 0},
+			expectedPending: []*zv1.DesiredTraffic{
+				scheduled("foo-v4", 100, now.Add(-time.Minute)),
+			},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := StackSetContainer{
+				StackSet: &zv1.StackSet{
+					Spec: zv1.StackSetSpec{
+						Ingress: &zv1.StackSetIngressSpec{},
+					},
+				},
+				StackContainers: map[types.UID]*StackContainer{
+					"foo-v1": testStack("foo-v1").ready(3).traffic(100, 100).stack(),
+					"foo-v2": testStack("foo-v2").ready(3).stack(),
+					"foo-v3": testStack("foo-v3").ready(3).stack(),
+				},
+				TrafficReconciler: SimpleTrafficReconciler{},
+				scheduledTraffic:  tc.scheduledTraffic,
+			}
+
+			err := c.ManageTraffic(now)
+			if tc.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			for name, weight := range tc.expectedDesired {
+				require.Equal(t, weight, c.stackByName(name).desiredTrafficWeight, "desired weight of %s", name)
+			}
+			require.Equal(t, tc.expectedPending, c.GenerateStackSetStatus().ScheduledTraffic)
+		})
+	}
+}
+
+func TestTrafficSwitchLastTrafficSwitch(t *testing.T) {
+	c := StackSetContainer{
+		StackSet: &zv1.StackSet{
+			Spec: zv1.StackSetSpec{
+				Ingress: &zv1.StackSetIngressSpec{},
+			},
+		},
+		StackContainers: map[types.UID]*StackContainer{
+			"foo-v1": testStack("foo-v1").traffic(50, 100).ready(3).lastTrafficSwitch(hourAgo).stack(),
+			"foo-v2": testStack("foo-v2").traffic(50, 0).ready(3).stack(),
+			"foo-v3": testStack("foo-v3").traffic(0, 0).ready(3).lastTrafficSwitch(hourAgo).stack(),
+		},
+		TrafficReconciler: SimpleTrafficReconciler{},
+	}
+
+	switchTimestamp := time.Now()
+	err := c.ManageTraffic(switchTimestamp)
+	require.NoError(t, err)
+
+	require.Equal(t, switchTimestamp, c.StackContainers["foo-v1"].lastTrafficSwitch)
+	require.Equal(t, switchTimestamp, c.StackContainers["foo-v2"].lastTrafficSwitch)
+	require.Equal(t, hourAgo, c.StackContainers["foo-v3"].lastTrafficSwitch)
+}
+
+func TestTrafficSwitchRateLimited(t *testing.T) {
+	now := time.Now()
+	thirtySecondsAgo := now.Add(-30 * time.Second)
+
+	for _, tc := range []struct {
+		name                  string
+		stacks                map[types.UID]*StackContainer
+		reconciler            TrafficReconciler
+		expectedActualWeights map[string]float64
+		expectedError         string
+	}{
+		{
+			name: "small changes are applied directly",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(95, 100).ready(3).lastTrafficSwitch(hourAgo).stack(),
+				"foo-v2": testStack("foo-v2").traffic(5, 0).ready(3).stack(),
+			},
+			reconciler: SimpleTrafficReconciler{},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 95,
+				"foo-v2": 5,
+			},
+		},
+		{
+			name: "large changes are limited",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 100).ready(3).lastTrafficSwitch(hourAgo).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 0).ready(3).stack(),
+			},
+			reconciler: SimpleTrafficReconciler{},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 90,
+				"foo-v2": 10,
+			},
+		},
+		{
+			name: "changes are limited proportionally",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 60).ready(3).stack(),
+				"foo-v2": testStack("foo-v2").traffic(0, 40).ready(3).stack(),
+				"foo-v3": testStack("foo-v3").traffic(100, 0).ready(3).stack(),
+			},
+			reconciler: SimpleTrafficReconciler{},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 54,
+				"foo-v2": 36,
+				"foo-v3": 10,
+			},
+		},
+		{
+			name: "proportional changes are rounded",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 55).ready(3).stack(),
+				"foo-v2": testStack("foo-v2").traffic(0, 45).ready(3).stack(),
+				"foo-v3": testStack("foo-v3").traffic(100, 0).ready(3).stack(),
+			},
+			reconciler: SimpleTrafficReconciler{},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 50,
+				"foo-v2": 40,
+				"foo-v3": 10,
+			},
+		},
+		{
+			name: "no changes within the interval",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 90).ready(3).lastTrafficSwitch(thirtySecondsAgo).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 10).ready(3).lastTrafficSwitch(thirtySecondsAgo).stack(),
+			},
+			reconciler: SimpleTrafficReconciler{},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 90,
+				"foo-v2": 10,
+			},
+		},
+		{
+			name: "errors of the previous reconciler are returned",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 100).ready(3).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 0).stack(),
+			},
+			reconciler: SimpleTrafficReconciler{},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 100,
+				"foo-v2": 0,
+			},
+			expectedError: "stacks not ready: foo-v2",
+		},
+		{
+			name: "composes with prescaling",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 100).ready(3).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 0).ready(3).prescaling(3, 100, thirtySecondsAgo).stack(),
+			},
+			reconciler: PrescalingTrafficReconciler{
+				ResetHPAMinReplicasTimeout: 5 * time.Minute,
+			},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 90,
+				"foo-v2": 10,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					Spec: zv1.StackSetSpec{
+						Ingress: &zv1.StackSetIngressSpec{},
+					},
+				},
+				StackContainers: tc.stacks,
+				TrafficReconciler: TrafficReconcilerChain{
+					tc.reconciler,
+					RateLimitedTrafficReconciler{
+						MaxWeightChange: 10,
+						Interval:        time.Minute,
+					},
+				},
+			}
+
+			err := c.ManageTraffic(now)
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				require.Equal(t, tc.expectedError, err.Error())
+			} else {
+				require.NoError(t, err)
+			}
+
+			actualWeights := map[string]float64{}
+			for name := range tc.expectedActualWeights {
+				actualWeights[name] = c.StackContainers[types.UID(name)].actualTrafficWeight
+			}
+			require.Equal(t, tc.expectedActualWeights, actualWeights)
+		})
+	}
+}
+
+func TestTrafficSwitchReconcilerChain(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		stacks                map[types.UID]*StackContainer
+		reconciler            TrafficReconcilerChain
+		expectedActualWeights map[string]float64
+		expectedError         string
+	}{
+		{
+			name: "readiness override before rate limiting",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 100).ready(4).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 0).partiallyReady(2, 4).stack(),
+			},
+			reconciler: TrafficReconcilerChain{
+				SimpleTrafficReconciler{MinReadyPercent: 50},
+				RateLimitedTrafficReconciler{MaxWeightChange: 10, Interval: time.Minute},
+			},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 90,
+				"foo-v2": 10,
+			},
+		},
+		{
+			name: "rate limiting before readiness",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 100).ready(4).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 0).ready(4).stack(),
+			},
+			reconciler: TrafficReconcilerChain{
+				RateLimitedTrafficReconciler{MaxWeightChange: 20, Interval: time.Minute},
+				SimpleTrafficReconciler{},
+			},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 80,
+				"foo-v2": 20,
+			},
+		},
+		{
+			name: "the chain stops at the first error",
+			stacks: map[types.UID]*StackContainer{
+				"foo-v1": testStack("foo-v1").traffic(0, 100).ready(4).stack(),
+				"foo-v2": testStack("foo-v2").traffic(100, 0).partiallyReady(2, 4).stack(),
+			},
+			reconciler: TrafficReconcilerChain{
+				RateLimitedTrafficReconciler{MaxWeightChange: 20, Interval: time.Minute},
+				SimpleTrafficReconciler{},
+			},
+			expectedActualWeights: map[string]float64{
+				"foo-v1": 100,
+				"foo-v2": 0,
+			},
+			expectedError: "stacks not ready: foo-v2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &StackSetContainer{
+				StackSet: &zv1.StackSet{
+					Spec: zv1.StackSetSpec{
+						Ingress: &zv1.StackSetIngressSpec{},
+					},
+				},
+				StackContainers:   tc.stacks,
+				TrafficReconciler: tc.reconciler,
+			}
+
+			err := c.ManageTraffic(time.Now())
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				require.Equal(t, tc.expectedError, err.Error())
+			} else {
+				require.NoError(t, err)
+			}
+
+			actualWeights := map[string]float64{}
+			desiredWeights := map[string]float64{}
+			for name := range tc.expectedActualWeights {
+				actualWeights[name] = c.StackContainers[types.UID(name)].actualTrafficWeight
+				desiredWeights[name] = c.StackContainers[types.UID(name)].desiredTrafficWeight
+			}
+			require.Equal(t, tc.expectedActualWeights, actualWeights)
+			require.Equal(t, map[string]float64{"foo-v1": 0, "foo-v2": 100}, desiredWeights)
+		})
+	}
+}
+
+func TestNewTrafficReconciler(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		strategy    *zv1.TrafficStrategy
+		expected    TrafficReconciler
+		expectError bool
+	}{
+		{
+			name:     "no strategy",
+			expected: &SimpleTrafficReconciler{},
+		},
+		{
+			name: "single reconciler",
+			strategy: &zv1.TrafficStrategy{
+				Reconcilers: []zv1.TrafficReconcilerSpec{{Type: PrescalingTrafficReconcilerType}},
+			},
+			expected: &PrescalingTrafficReconciler{ResetHPAMinReplicasTimeout: DefaultResetHPAMinReplicasDelay},
+		},
+		{
+			name: "chain",
+			strategy: &zv1.TrafficStrategy{
+				Reconcilers: []zv1.TrafficReconcilerSpec{
+					{
+						Type: PrescalingTrafficReconcilerType,
+						Prescaling: &zv1.PrescalingTrafficReconcilerSpec{
+							ResetHPAMinReplicasDelay: &metav1.Duration{Duration: time.Minute},
+						},
+					},
+					{
+						Type: RateLimitTrafficReconcilerType,
+						RateLimit: &zv1.RateLimitTrafficReconcilerSpec{
+							MaxWeightChange: 10,
+							Interval:        &metav1.Duration{Duration: 30 * time.Second},
+						},
+					},
+				},
+			},
+			expected: TrafficReconcilerChain{
+				&PrescalingTrafficReconciler{ResetHPAMinReplicasTimeout: time.Minute},
+				&RateLimitedTrafficReconciler{MaxWeightChange: 10, Interval: 30 * time.Second},
+			},
+		},
+		{
+			name: "unknown type",
+			strategy: &zv1.TrafficStrategy{
+				Reconcilers: []zv1.TrafficReconcilerSpec{{Type: "Unknown"}},
+			},
+			expectError: true,
+		},
+		{
+			name: "missing rate limit configuration",
+			strategy: &zv1.TrafficStrategy{
+				Reconcilers: []zv1.TrafficReconcilerSpec{{Type: RateLimitTrafficReconcilerType}},
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid min ready percent",
+			strategy: &zv1.TrafficStrategy{
+				Reconcilers: []zv1.TrafficReconcilerSpec{
+					{
+						Type:      ReadinessTrafficReconcilerType,
+						Readiness: &zv1.ReadinessTrafficReconcilerSpec{MinReadyPercent: 120},
+					},
+				},
+			},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reconciler, err := NewTrafficReconciler(tc.strategy)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, reconciler)
+		})
+	}
+}
+
+func TestRegisterTrafficReconciler(t *testing.T) {
+	RegisterTrafficReconciler("Custom", func(spec zv1.TrafficReconcilerSpec) (TrafficReconciler, error) {
+		return SimpleTrafficReconciler{MinReadyPercent: 42}, nil
+	})
+	defer func() {
+		trafficReconcilersMutex.Lock()
+		defer trafficReconcilersMutex.Unlock()
+		delete(trafficReconcilers, "Custom")
+	}()
+
+	reconciler, err := NewTrafficReconciler(&zv1.TrafficStrategy{
+		Reconcilers: []zv1.TrafficReconcilerSpec{{Type: "Custom"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, SimpleTrafficReconciler{MinReadyPercent: 42}, reconciler)
+}
+
+func TestHexBucketsPattern(t *testing.T) {
+	for _, tc := range []struct {
+		from, to int
+		expected string
+	}{
+		{from: 0x00, to: 0xff, expected: "[0-9a-f][0-9a-f]"},
+		{from: 0x05, to: 0x05, expected: "05"},
+		{from: 0x13, to: 0x1a, expected: "1[3-9a]"},
+		{from: 0x13, to: 0x29, expected: "1[3-9a-f]|2[0-9]"},
+		{from: 0x10, to: 0x2f, expected: "[1-2][0-9a-f]"},
+		{from: 0x0a, to: 0x4c, expected: "0[a-f]|[1-3][0-9a-f]|4[0-9a-c]"},
+		{from: 0xe6, to: 0xff, expected: "e[6-9a-f]|f[0-9a-f]"},
+	} {
+		t.Run(tc.expected, func(t *testing.T) {
+			require.Equal(t, tc.expected, hexBucketsPattern(tc.from, tc.to))
+		})
+	}
+}
+
+func TestStickyTrafficKeyPredicate(t *testing.T) {
+	predicate, filter, err := stickyTrafficKeyPredicate(&zv1.StickyTrafficSpec{Header: "X-User-Id"})
+	require.NoError(t, err)
+	require.Equal(t, `HeaderRegexp("X-User-Id", ".")`, predicate)
+	require.Equal(t, fmt.Sprintf("lua(%q, %q)", stickyTrafficHashScript, "header=X-User-Id"), filter)
+
+	predicate, filter, err = stickyTrafficKeyPredicate(&zv1.StickyTrafficSpec{Cookie: "session"})
+	require.NoError(t, err)
+	require.Equal(t, `Cookie("session", ".")`, predicate)
+	require.Equal(t, fmt.Sprintf("lua(%q, %q)", stickyTrafficHashScript, "cookie=session"), filter)
+
+	_, _, err = stickyTrafficKeyPredicate(&zv1.StickyTrafficSpec{})
+	require.Error(t, err)
+
+	_, _, err = stickyTrafficKeyPredicate(&zv1.StickyTrafficSpec{Header: "X-User-Id", Cookie: "session"})
+	require.Error(t, err)
+}
+
+func TestStickyTrafficPredicate(t *testing.T) {
+	require.Equal(t, `HeaderRegexp("X-Stackset-Sticky-Bucket", "^(?:1[a-f]|[2-4][0-9a-f]|5[0-9])$")`, stickyTrafficPredicate(0.1, 0.35))
+	require.Equal(t, `HeaderRegexp("X-Stackset-Sticky-Bucket", "^(?:[0-9a-f][0-9a-f])$")`, stickyTrafficPredicate(0, 1))
+	require.Empty(t, stickyTrafficPredicate(0.5, 0.5))
+
+	// Every bucket is assigned to exactly one of the segments
+	limits := []float64{0, 0.01, 0.1, 0.33, 0.5, 0.99, 1}
+	var patterns []*regexp.Regexp
+	for i := 1; i < len(limits); i++ {
+		predicate := stickyTrafficPredicate(limits[i-1], limits[i])
+		require.NotEmpty(t, predicate)
+
+		pattern, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(predicate, `HeaderRegexp("X-Stackset-Sticky-Bucket", `), ")"))
+		require.NoError(t, err)
+		patterns = append(patterns, regexp.MustCompile(pattern))
+	}
+
+	for bucket := 0; bucket < stickyTrafficBuckets; bucket++ {
+		value := fmt.Sprintf("%02x", bucket)
+		matches := 0
+		for _, pattern := range patterns {
+			if pattern.MatchString(value) {
+				matches++
+			}
+		}
+		require.Equal(t, 1, matches, "bucket %s", value)
+	}
+}
+
 func TestNewTrafficSegment(t *testing.T) {
 	for _, tc := range []struct {
 		stackContainer     *StackContainer
@@ -12,6 +12,8 @@ import (
 	autoscaling "k8s.io/api/autoscaling/v2"
 	v1 "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -20,6 +22,7 @@ const (
 	defaultVersion             = "default"
 	defaultStackLifecycleLimit = 10
 	defaultScaledownTTL        = 300 * time.Second
+	defaultPinnedMinReplicas   = 1
 )
 
 // StackSetContainer is a container for storing the full state of a StackSet
@@ -69,6 +72,28 @@ type StackSetContainer struct {
 	// ingressAnnotationsToSync is a list of ingress annotations that should be
 	// synchronized across all existing stacks.
 	ingressAnnotationsToSync []string
+
+	// rolloutStatus is the state of the rollout computed by ManageRollout.
+	rolloutStatus *zv1.RolloutStatus
+
+	// rolledBackStack is the name of the stack whose traffic was rolled
+	// back by AnalyzeTraffic.
+	rolledBackStack string
+
+	// blueGreenStatus is the state of the blue/green deployment computed
+	// by ManageTraffic.
+	blueGreenStatus *zv1.BlueGreenStatus
+
+	// scheduledTraffic is the traffic of the scheduled switches from the
+	// StackSet spec which haven't been applied yet.
+	scheduledTraffic []*zv1.DesiredTraffic
+
+	// reconcileErrors are the errors of the reconciliation of the
+	// StackSet, reflected in its conditions.
+	reconcileErrors []reconcileError
+
+	// trafficError is the error of the last traffic switch.
+	trafficError error
 }
 
 // StackContainer is a container for storing the full state of a Stack
@@ -98,6 +123,33 @@ type StackContainer struct {
 	// RouteGroup annotations present in the StackSet
 	syncAnnotationsInRouteGroup map[string]string
 
+	// Canary routes of the StackSet targeting the stack
+	canaryRoutes []zv1.CanaryRoute
+
+	// Sticky traffic configuration of the StackSet
+	stickyTraffic *zv1.StickyTrafficSpec
+
+	// Set if the stack is pinned in the lifecycle of the StackSet, with the
+	// number of replicas it's kept at while scaled down
+	pinned            bool
+	pinnedMinReplicas int32
+
+	// Percentage of the live requests mirrored to the stack, from the
+	// StackSet spec
+	mirrorWeight float64
+
+	// Stacks the requests served by this stack are mirrored to
+	trafficMirrors []trafficMirror
+
+	// Set if the stack is the candidate or the previously live stack of a
+	// blue/green deployment
+	blueGreenRetained bool
+
+	// Set if the traffic of the stack was rolled back by AnalyzeTraffic and
+	// the rollback isn't complete yet, which isn't rate limited. It's kept
+	// in the Degraded condition of the stack.
+	trafficRolledBack bool
+
 	// Fields from the stack itself.
 	ingressSpec    *zv1.StackSetIngressSpec
 	routeGroupSpec *zv1.RouteGroupSpec
@@ -132,11 +184,16 @@ type StackContainer struct {
 	actualTrafficWeight            float64
 	desiredTrafficWeight           float64
 	noTrafficSince                 time.Time
+	lastTrafficSwitch              time.Time
 	prescalingActive               bool
 	prescalingReplicas             int32
 	prescalingDesiredTrafficWeight float64
 	prescalingLastTrafficIncrease  time.Time
 	minReadyPercent                float64
+
+	// reconcileErrors are the errors of the reconciliation of the stack
+	// resources, reflected in its conditions.
+	reconcileErrors []reconcileError
 }
 
 // TrafficChange contains information about a traffic change event
@@ -159,8 +216,14 @@ func (sc *StackContainer) HasTraffic() bool {
 }
 
 func (sc *StackContainer) IsReady() bool {
+	return sc.isReadyWith(sc.minReadyPercent)
+}
+
+// isReadyWith returns true if the stack is ready with at least the specified
+// fraction of ready pods.
+func (sc *StackContainer) isReadyWith(minReadyPercent float64) bool {
 	// Calculate minimum required replicas for the Deployment to be considered ready
-	minRequiredReplicas := int32(math.Ceil(float64(sc.deploymentReplicas) * sc.minReadyPercent))
+	minRequiredReplicas := int32(math.Ceil(float64(sc.deploymentReplicas) * minReadyPercent))
 
 	// Stacks are considered ready when all subresources have been updated
 	// and the minimum ready percentage is hit on and replicas
@@ -180,8 +243,20 @@ func (sc *StackContainer) IsAutoscaled() bool {
 	return sc.Stack.Spec.StackSpec.Autoscaler != nil
 }
 
+// ScaledDown returns true if the stack is scaled down because it didn't get
+// any traffic for longer than the scale down TTL. Stacks requests are mirrored
+// to keep running, so that they can serve the mirrored requests.
 func (sc *StackContainer) ScaledDown() bool {
-	if sc.HasTraffic() {
+	return sc.mirrorWeight == 0 && sc.inactive()
+}
+
+// inactive returns true if the stack didn't get any traffic for longer than
+// the scale down TTL. Mirrored requests don't count, so that mirrored stacks
+// are still cleaned up according to the stack lifecycle unless they're
+// pinned.
+func (sc *StackContainer) inactive() bool {
+	// Stacks targeted by canary routes must keep serving their requests
+	if sc.HasTraffic() || len(sc.canaryRoutes) > 0 || sc.blueGreenRetained {
 		return false
 	}
 	return !sc.noTrafficSince.IsZero() && time.Since(sc.noTrafficSince) > sc.scaledownTTL
@@ -239,9 +314,17 @@ func (ssc *StackSetContainer) stackByName(name string) *StackContainer {
 // and populates it to stack containers
 func (ssc *StackSetContainer) updateDesiredTraffic() error {
 	weights := make(map[string]float64)
+	mirrorWeights := make(map[string]float64)
+	ssc.scheduledTraffic = nil
 
 	for _, desiredTraffic := range ssc.StackSet.Spec.Traffic {
+		// scheduled switches are applied by ManageTraffic
+		if desiredTraffic.NotBefore != nil {
+			ssc.scheduledTraffic = append(ssc.scheduledTraffic, desiredTraffic.DeepCopy())
+			continue
+		}
 		weights[desiredTraffic.StackName] = desiredTraffic.Weight
+		mirrorWeights[desiredTraffic.StackName] = desiredTraffic.Mirror
 	}
 
 	// filter stacks and normalize weights
@@ -262,6 +345,7 @@ func (ssc *StackSetContainer) updateDesiredTraffic() error {
 	// save values in stack containers
 	for _, container := range ssc.StackContainers {
 		container.desiredTrafficWeight = weights[container.Name()]
+		container.mirrorWeight = mirrorWeights[container.Name()]
 	}
 
 	return nil
@@ -330,26 +414,42 @@ func (ssc *StackSetContainer) UpdateFromResources() error {
 		)
 	}
 
+	var stickyTraffic *zv1.StickyTrafficSpec
+	canaryRoutes := map[string][]zv1.CanaryRoute{}
+	if ssc.StackSet.Spec.RouteGroup != nil {
+		stickyTraffic = ssc.StackSet.Spec.RouteGroup.StickyTraffic
+		for _, canary := range ssc.StackSet.Spec.RouteGroup.CanaryRoutes {
+			canaryRoutes[canary.StackName] = append(canaryRoutes[canary.StackName], canary)
+		}
+	}
+
 	// if backendPort is not defined from Ingress or Routegroup fallback
 	// to externalIngress if defined
 	if ssc.StackSet.Spec.ExternalIngress != nil {
 		ssc.externalIngressBackendPort = backendPort
 	}
 
-	var scaledownTTL time.Duration
-	if ssc.StackSet.Spec.StackLifecycle.ScaledownTTLSeconds == nil {
-		scaledownTTL = defaultScaledownTTL
-	} else {
-		scaledownTTL = time.Duration(*ssc.StackSet.Spec.StackLifecycle.ScaledownTTLSeconds) * time.Second
+	pinnedMinReplicas := map[string]int32{}
+	for _, pinned := range ssc.StackSet.Spec.StackLifecycle.Pinned {
+		minReplicas := int32(defaultPinnedMinReplicas)
+		if pinned.MinReplicas != nil {
+			minReplicas = *pinned.MinReplicas
+		}
+		pinnedMinReplicas[pinned.StackName] = minReplicas
 	}
 
+	scaledownTTL := stackScaledownTTL(ssc.StackSet.Spec.StackLifecycle)
+
 	for _, sc := range ssc.StackContainers {
 		sc.stacksetName = ssc.StackSet.Name
 		sc.ingressAnnotationsToSync = ssc.ingressAnnotationsToSync
 		sc.syncAnnotationsInIngress = syncAnnotationsInIngress
 		sc.syncAnnotationsInRouteGroup = syncAnnotationsInRouteGroup
+		sc.canaryRoutes = canaryRoutes[sc.Name()]
+		sc.stickyTraffic = stickyTraffic
 		sc.backendPort = backendPort
 		sc.scaledownTTL = scaledownTTL
+		sc.pinnedMinReplicas, sc.pinned = pinnedMinReplicas[sc.Name()]
 		sc.clusterDomains = ssc.clusterDomains
 		err := sc.updateStackResources()
 		if err != nil {
@@ -478,12 +578,22 @@ func (sc *StackContainer) updateFromResources() {
 		ingressSegmentUpdated &&
 		routeGroupSegmentUpdated
 
+	sc.updateFromStatus()
+}
+
+// updateFromStatus restores the traffic state of the stack kept in its
+// status by the previous reconciliation.
+func (sc *StackContainer) updateFromStatus() {
 	status := sc.Stack.Status
 	sc.noTrafficSince = unwrapTime(status.NoTrafficSince)
+	sc.lastTrafficSwitch = unwrapTime(status.LastTrafficSwitch)
 	if status.Prescaling.Active {
 		sc.prescalingActive = true
 		sc.prescalingReplicas = status.Prescaling.Replicas
 		sc.prescalingDesiredTrafficWeight = status.Prescaling.DesiredTrafficWeight
 		sc.prescalingLastTrafficIncrease = unwrapTime(status.Prescaling.LastTrafficIncrease)
 	}
+	if condition := meta.FindStatusCondition(status.Conditions, zv1.ConditionDegraded); condition != nil {
+		sc.trafficRolledBack = condition.Status == metav1.ConditionTrue && condition.Reason == reasonTrafficRolledBack
+	}
 }
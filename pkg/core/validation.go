@@ -0,0 +1,201 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// trafficWeightTolerance is the maximum difference of the sum of the
+	// traffic weights from 100, to allow for rounding errors of normalized
+	// weights.
+	trafficWeightTolerance = 0.01
+)
+
+var (
+	errIngressAndExternalIngress = errors.New("ingress and externalIngress can't be set at the same time")
+	errStickyTrafficAndIngress   = errors.New("routegroup.stickyTraffic can't be set together with ingress, the traffic segments of an Ingress are always assigned randomly")
+)
+
+// ValidateStackSet returns an error if the StackSet is invalid. The old
+// StackSet is the version being updated, or nil if the StackSet is created.
+// Parts of the spec which weren't changed aren't validated again, so that
+// existing StackSets can still be updated. The stacks are the names of the
+// existing stacks of the StackSet.
+func ValidateStackSet(stackset, old *zv1.StackSet, stacks []string) error {
+	var errs []error
+	var oldSpec zv1.StackSetSpec
+	if old != nil {
+		oldSpec = old.Spec
+	}
+	spec := stackset.Spec
+
+	if old == nil || !equality.Semantic.DeepEqual(spec.Traffic, oldSpec.Traffic) ||
+		(spec.RouteGroup == nil) != (oldSpec.RouteGroup == nil) {
+		errs = append(errs, validateTraffic(stackset, oldSpec.Traffic, stacks)...)
+	}
+
+	if old == nil || !equality.Semantic.DeepEqual(spec.Ingress, oldSpec.Ingress) ||
+		!equality.Semantic.DeepEqual(spec.ExternalIngress, oldSpec.ExternalIngress) {
+		if spec.Ingress != nil && spec.ExternalIngress != nil {
+			errs = append(errs, errIngressAndExternalIngress)
+		}
+	}
+
+	if old == nil || !equality.Semantic.DeepEqual(spec.Ingress, oldSpec.Ingress) ||
+		!equality.Semantic.DeepEqual(stickyTraffic(spec), stickyTraffic(oldSpec)) {
+		if spec.Ingress != nil && stickyTraffic(spec) != nil {
+			errs = append(errs, errStickyTrafficAndIngress)
+		}
+	}
+
+	template := spec.StackTemplate.Spec
+	oldTemplate := oldSpec.StackTemplate.Spec
+	if old == nil || !equality.Semantic.DeepEqual(template.Autoscaler, oldTemplate.Autoscaler) {
+		errs = append(errs, validateAutoscaler(template.Autoscaler)...)
+	}
+
+	if old == nil || template.Version != oldTemplate.Version ||
+		!equality.Semantic.DeepEqual(template.ConfigurationResources, oldTemplate.ConfigurationResources) {
+		stackName := generateStackName(stackset, currentStackVersion(stackset))
+		errs = append(errs, validateConfigurationResources(stackName, template.ConfigurationResources)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateStack returns an error if the Stack is invalid. The old Stack is
+// the version being updated, or nil if the Stack is created. Parts of the
+// spec which weren't changed aren't validated again.
+func ValidateStack(stack, old *zv1.Stack) error {
+	var errs []error
+	var oldSpec zv1.StackSpecInternal
+	if old != nil {
+		oldSpec = old.Spec
+	}
+	spec := stack.Spec
+
+	if old == nil || !equality.Semantic.DeepEqual(spec.Ingress, oldSpec.Ingress) ||
+		!equality.Semantic.DeepEqual(spec.ExternalIngress, oldSpec.ExternalIngress) {
+		if spec.Ingress != nil && spec.ExternalIngress != nil {
+			errs = append(errs, errIngressAndExternalIngress)
+		}
+	}
+
+	if old == nil || !equality.Semantic.DeepEqual(spec.StackSpec.Autoscaler, oldSpec.StackSpec.Autoscaler) {
+		errs = append(errs, validateAutoscaler(spec.StackSpec.Autoscaler)...)
+	}
+
+	if old == nil || !equality.Semantic.DeepEqual(spec.StackSpec.ConfigurationResources, oldSpec.StackSpec.ConfigurationResources) {
+		errs = append(errs, validateConfigurationResources(stack.Name, spec.StackSpec.ConfigurationResources)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+func stickyTraffic(spec zv1.StackSetSpec) *zv1.StickyTrafficSpec {
+	if spec.RouteGroup == nil {
+		return nil
+	}
+	return spec.RouteGroup.StickyTraffic
+}
+
+// validateTraffic checks that the traffic only targets existing stacks or the
+// stack created for the current version, and that the weights of the desired
+// traffic and of every scheduled switch add up to 100. Stacks which were
+// already part of the old traffic are accepted even if they don't exist
+// anymore. Requests can only be mirrored with a RouteGroup, the traffic
+// segments of an Ingress can't copy them.
+func validateTraffic(stackset *zv1.StackSet, oldTraffic []*zv1.DesiredTraffic, stacks []string) []error {
+	known := map[string]bool{
+		generateStackName(stackset, currentStackVersion(stackset)): true,
+	}
+	for _, stack := range stacks {
+		known[stack] = true
+	}
+	for _, traffic := range oldTraffic {
+		known[traffic.StackName] = true
+	}
+
+	var errs []error
+	var switches []string
+	sums := make(map[string]float64)
+	for _, traffic := range stackset.Spec.Traffic {
+		if !known[traffic.StackName] {
+			errs = append(errs, fmt.Errorf("traffic: unknown stack %s", traffic.StackName))
+		}
+		if traffic.Mirror > 0 && stackset.Spec.RouteGroup == nil {
+			errs = append(errs, fmt.Errorf("traffic: mirroring requests to stack %s requires a routegroup", traffic.StackName))
+		}
+
+		key := ""
+		if traffic.NotBefore != nil {
+			key = traffic.NotBefore.UTC().Format("2006-01-02T15:04:05Z")
+		}
+		if _, ok := sums[key]; !ok {
+			switches = append(switches, key)
+		}
+		sums[key] += traffic.Weight
+	}
+
+	for _, key := range switches {
+		sum := sums[key]
+		// Traffic without any weight, e.g. only mirroring requests,
+		// keeps the current traffic
+		if key == "" && sum == 0 {
+			continue
+		}
+		if math.Abs(sum-100) > trafficWeightTolerance {
+			if key == "" {
+				errs = append(errs, fmt.Errorf("traffic: weights must add up to 100, got %g", sum))
+			} else {
+				errs = append(errs, fmt.Errorf("traffic: weights of the switch scheduled at %s must add up to 100, got %g", key, sum))
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateAutoscaler checks that every autoscaler metric can be converted to
+// a metric of the HPA, e.g. that the configuration required by its type is
+// specified.
+func validateAutoscaler(autoscaler *zv1.Autoscaler) []error {
+	if autoscaler == nil {
+		return nil
+	}
+
+	var errs []error
+	for i, metric := range autoscaler.Metrics {
+		_, _, err := convertCustomMetrics("", "", "", autoscalerMetricsList{metric}, 0)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("autoscaler: metric %d (%s): %w", i, metric.Type, err))
+		}
+	}
+	return errs
+}
+
+func validateConfigurationResources(stackName string, resources []zv1.ConfigurationResourcesSpec) []error {
+	var errs []error
+	for _, rsc := range resources {
+		err := ValidateConfigurationResourceName(stackName, rsc.GetName())
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// ValidateConfigurationResourceName returns an error if specific resource
+// name is not prefixed by Stack name.
+func ValidateConfigurationResourceName(stack string, rsc string) error {
+	if !strings.HasPrefix(rsc, stack) {
+		return fmt.Errorf("ConfigurationResource name must be prefixed by Stack name. ConfigurationResource: %s, Stack: %s", rsc, stack)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateStackSet(t *testing.T) {
+	average := resource.MustParse("10")
+	scheduled := metav1.NewTime(hourAgo)
+
+	validStackSet := func() *zv1.StackSet {
+		return &zv1.StackSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "foo",
+				Namespace: "default",
+			},
+			Spec: zv1.StackSetSpec{
+				Ingress: &zv1.StackSetIngressSpec{},
+				Traffic: []*zv1.DesiredTraffic{
+					{StackName: "foo-v1", Weight: 60},
+					{StackName: "foo-v2", Weight: 40},
+				},
+				StackTemplate: zv1.StackTemplate{
+					Spec: zv1.StackSpecTemplate{
+						Version: "v2",
+						StackSpec: zv1.StackSpec{
+							Autoscaler: &zv1.Autoscaler{
+								MaxReplicas: 3,
+								Metrics: []zv1.AutoscalerMetrics{
+									{Type: zv1.ZMONAutoscalerMetric, Average: &average, ZMON: &zv1.MetricsZMON{CheckID: "1", Key: "key"}},
+								},
+							},
+							ConfigurationResources: []zv1.ConfigurationResourcesSpec{
+								{ConfigMapRef: &v1.LocalObjectReference{Name: "foo-v2-config"}},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		update        func(stackset *zv1.StackSet)
+		old           func(stackset *zv1.StackSet)
+		stacks        []string
+		expectedError string
+	}{
+		{
+			name:   "valid stackset",
+			stacks: []string{"foo-v1"},
+		},
+		{
+			name: "traffic without weights",
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.RouteGroup = &zv1.RouteGroupSpec{}
+				stackset.Spec.Traffic = []*zv1.DesiredTraffic{{StackName: "foo-v2", Mirror: 10}}
+			},
+		},
+		{
+			name: "mirroring without a routegroup",
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Traffic = []*zv1.DesiredTraffic{{StackName: "foo-v2", Mirror: 10}}
+			},
+			expectedError: "traffic: mirroring requests to stack foo-v2 requires a routegroup",
+		},
+		{
+			name: "routegroup of a mirrored stack removed",
+			old: func(stackset *zv1.StackSet) {
+				stackset.Spec.RouteGroup = &zv1.RouteGroupSpec{}
+				stackset.Spec.Traffic = []*zv1.DesiredTraffic{{StackName: "foo-v2", Mirror: 10}}
+			},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Traffic = []*zv1.DesiredTraffic{{StackName: "foo-v2", Mirror: 10}}
+			},
+			expectedError: "traffic: mirroring requests to stack foo-v2 requires a routegroup",
+		},
+		{
+			name:   "weights not adding up to 100",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Traffic[1].Weight = 50
+			},
+			expectedError: "traffic: weights must add up to 100, got 110",
+		},
+		{
+			name:   "weights of a scheduled switch not adding up to 100",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Traffic = append(stackset.Spec.Traffic, &zv1.DesiredTraffic{StackName: "foo-v2", Weight: 50, NotBefore: &scheduled})
+			},
+			expectedError: "traffic: weights of the switch scheduled at " + scheduled.UTC().Format("2006-01-02T15:04:05Z") + " must add up to 100, got 50",
+		},
+		{
+			name: "unknown stack",
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Traffic[0].StackName = "foo-v0"
+			},
+			expectedError: "traffic: unknown stack foo-v0",
+		},
+		{
+			name: "deleted stack which already had traffic",
+			old:  func(stackset *zv1.StackSet) {},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Traffic[0].Weight = 50
+				stackset.Spec.Traffic[1].Weight = 50
+			},
+		},
+		{
+			name:   "metric without its configuration",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.StackTemplate.Spec.Autoscaler.Metrics[0].ZMON = nil
+			},
+			expectedError: "autoscaler: metric 0 (ZMON): " + errMissingZMONDefinition.Error(),
+		},
+		{
+			name:   "unchanged invalid metric",
+			stacks: []string{"foo-v1"},
+			old: func(stackset *zv1.StackSet) {
+				stackset.Spec.StackTemplate.Spec.Autoscaler.Metrics[0].ZMON = nil
+			},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.StackTemplate.Spec.Autoscaler.Metrics[0].ZMON = nil
+			},
+		},
+		{
+			name:   "configuration resource not prefixed with the stack name",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.StackTemplate.Spec.Version = "v3"
+				stackset.Spec.Traffic[1].StackName = "foo-v3"
+			},
+			expectedError: "ConfigurationResource name must be prefixed by Stack name. ConfigurationResource: foo-v2-config, Stack: foo-v3",
+		},
+		{
+			name:   "ingress and external ingress",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.ExternalIngress = &zv1.StackSetExternalIngressSpec{}
+			},
+			expectedError: errIngressAndExternalIngress.Error(),
+		},
+		{
+			name:   "sticky traffic with an ingress",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.RouteGroup = &zv1.RouteGroupSpec{StickyTraffic: &zv1.StickyTrafficSpec{Header: "X-User-Id"}}
+			},
+			expectedError: errStickyTrafficAndIngress.Error(),
+		},
+		{
+			name:   "sticky traffic without an ingress",
+			stacks: []string{"foo-v1"},
+			update: func(stackset *zv1.StackSet) {
+				stackset.Spec.Ingress = nil
+				stackset.Spec.RouteGroup = &zv1.RouteGroupSpec{StickyTraffic: &zv1.StickyTrafficSpec{Header: "X-User-Id"}}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stackset := validStackSet()
+			if tc.update != nil {
+				tc.update(stackset)
+			}
+
+			var old *zv1.StackSet
+			if tc.old != nil {
+				old = validStackSet()
+				tc.old(old)
+			}
+
+			err := ValidateStackSet(stackset, old, tc.stacks)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateStack(t *testing.T) {
+	stack := &zv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo-v1",
+		},
+		Spec: zv1.StackSpecInternal{
+			Ingress:         &zv1.StackSetIngressSpec{},
+			ExternalIngress: &zv1.StackSetExternalIngressSpec{},
+			StackSpec: zv1.StackSpec{
+				Autoscaler: &zv1.Autoscaler{
+					Metrics: []zv1.AutoscalerMetrics{{Type: zv1.CPUAutoscalerMetric}},
+				},
+				ConfigurationResources: []zv1.ConfigurationResourcesSpec{
+					{SecretRef: &v1.LocalObjectReference{Name: "foo-secret"}},
+				},
+			},
+		},
+	}
+
+	err := ValidateStack(stack, nil)
+	require.EqualError(t, err, errIngressAndExternalIngress.Error()+"\n"+
+		"autoscaler: metric 0 (CPU): utilization is not specified\n"+
+		"ConfigurationResource name must be prefixed by Stack name. ConfigurationResource: foo-secret, Stack: foo-v1")
+
+	require.NoError(t, ValidateStack(stack, stack.DeepCopy()))
+}
@@ -22,3 +22,10 @@ func CreateEventRecorder(kubeClient clientset.Interface) kube_record.EventRecord
 	}
 	return eventBroadcaster.NewRecorder(scheme.Scheme, clientv1.EventSource{Component: "stackset-controller"})
 }
+
+// CreateLoggingEventRecorder creates an event recorder which only logs the events without sending them to Kubernetes
+func CreateLoggingEventRecorder() kube_record.EventRecorder {
+	eventBroadcaster := kube_record.NewBroadcaster()
+	eventBroadcaster.StartLogging(logrus.Infof)
+	return eventBroadcaster.NewRecorder(scheme.Scheme, clientv1.EventSource{Component: "stackset-controller"})
+}
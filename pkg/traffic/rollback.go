@@ -0,0 +1,235 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// RollbackAnnotationKey is set on the StackSet to the name of the
+	// stack traffic was rolled back to.
+	RollbackAnnotationKey = "stackset-controller.zalando.org/rollback-stack"
+)
+
+// ErrNoRollbackStack is returned by Rollback if none of the stacks besides
+// the newest one has or had traffic.
+var ErrNoRollbackStack = errors.New("no stack to roll back to")
+
+// Rollback switches all desired traffic of the StackSet away from its newest
+// stack, to the stack with the most traffic besides it or else the one which
+// most recently had traffic, and returns its name. The controller scales the
+// stack up if it was scaled down and only switches the actual traffic once
+// it's ready, which Rollback waits for up to the timeout. A timeout of zero
+// doesn't wait.
+//
+// Repeating a rollback keeps the traffic on the same stack, as long as the
+// traffic wasn't switched in the meantime.
+func (t *Switcher) Rollback(ctx context.Context, stackset, namespace string, timeout time.Duration) (string, error) {
+	var target string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ss, err := t.client.ZalandoV1().StackSets(namespace).Get(ctx, stackset, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		stacks, err := t.listStacks(ctx, stackset, namespace)
+		if err != nil {
+			return err
+		}
+
+		target = ss.Annotations[RollbackAnnotationKey]
+		if target != "" && isRolledBack(ss, target) {
+			return nil
+		}
+
+		target = rollbackStack(ss, stacks)
+		if target == "" {
+			return ErrNoRollbackStack
+		}
+
+		updated := ss.DeepCopy()
+		setRollbackTraffic(updated, target)
+		_, err = t.client.ZalandoV1().StackSets(namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if timeout <= 0 {
+		return target, nil
+	}
+
+	// Both waits share the timeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err = t.WaitForObservedGeneration(ctx, stackset, namespace, timeout)
+	if err != nil {
+		return target, err
+	}
+
+	err = t.WaitForTraffic(ctx, stackset, namespace, timeout)
+	if err != nil {
+		return target, fmt.Errorf("traffic wasn't switched to stack %s: %w", target, err)
+	}
+	return target, nil
+}
+
+// isOnlyDesiredStack returns true if the stack is the only one with desired
+// traffic.
+func isOnlyDesiredStack(stackset *zv1.StackSet, stack string) bool {
+	if stackset.Spec.BlueGreen != nil {
+		return stackset.Spec.BlueGreen.Promote == stack
+	}
+
+	found := false
+	for _, traffic := range stackset.Spec.Traffic {
+		if traffic.NotBefore != nil || traffic.Weight == 0 {
+			continue
+		}
+		if traffic.StackName != stack {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// rollbackStack returns the name of the stack to roll back to. The newest
+// stack is the one being rolled back, of the other stacks the one with the
+// most actual or desired traffic is picked. If none of them has traffic, the
+// stack which most recently had traffic is picked, like the controller picks a
+// fallback stack.
+func rollbackStack(stackset *zv1.StackSet, stacks []zv1.Stack) string {
+	traffic := stackTraffic(stackset)
+	newest := newestStack(stacks)
+
+	var candidates []zv1.Stack
+	for _, stack := range stacks {
+		if stack.Name == newest {
+			continue
+		}
+		if traffic[stack.Name] > 0 || stack.Status.NoTrafficSince != nil {
+			candidates = append(candidates, stack)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		wi, wj := traffic[candidates[i].Name], traffic[candidates[j].Name]
+		if wi != wj {
+			return wi > wj
+		}
+		ti, tj := candidates[i].Status.NoTrafficSince, candidates[j].Status.NoTrafficSince
+		if ti != nil && tj != nil && !ti.Equal(tj) {
+			return tj.Before(ti)
+		}
+		if (ti == nil) != (tj == nil) {
+			return ti == nil
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates[0].Name
+}
+
+// stackTraffic returns the traffic of the stacks of the StackSet, which is the
+// higher one of their actual and desired traffic weight.
+func stackTraffic(stackset *zv1.StackSet) map[string]float64 {
+	traffic := make(map[string]float64)
+	for _, t := range stackset.Spec.Traffic {
+		if t.NotBefore == nil {
+			traffic[t.StackName] = t.Weight
+		}
+	}
+	if stackset.Spec.BlueGreen != nil && stackset.Spec.BlueGreen.Promote != "" {
+		traffic[stackset.Spec.BlueGreen.Promote] = 100
+	}
+	for _, t := range stackset.Status.Traffic {
+		traffic[t.StackName] = math.Max(traffic[t.StackName], t.Weight)
+	}
+	return traffic
+}
+
+// newestStack returns the name of the most recently created stack. Stacks
+// created at the same time are ordered by their name.
+func newestStack(stacks []zv1.Stack) string {
+	var newest *zv1.Stack
+	for i, stack := range stacks {
+		if newest == nil {
+			newest = &stacks[i]
+			continue
+		}
+
+		created, newestCreated := stack.CreationTimestamp.Time, newest.CreationTimestamp.Time
+		if created.After(newestCreated) || (created.Equal(newestCreated) && stack.Name > newest.Name) {
+			newest = &stacks[i]
+		}
+	}
+	if newest == nil {
+		return ""
+	}
+	return newest.Name
+}
+
+// isRolledBack returns true if the StackSet was rolled back to the stack and
+// the traffic wasn't switched since, i.e. the stack is the only one with
+// desired and actual traffic.
+func isRolledBack(stackset *zv1.StackSet, stack string) bool {
+	if stackset.Annotations[RollbackAnnotationKey] != stack || !isOnlyDesiredStack(stackset, stack) {
+		return false
+	}
+	for _, t := range stackset.Status.Traffic {
+		if t.StackName != stack && t.Weight > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setRollbackTraffic moves all desired traffic of the StackSet to the stack.
+// Pending scheduled traffic switches are dropped and a rollout in progress is
+// aborted, so that the traffic isn't switched away from the stack again.
+func setRollbackTraffic(stackset *zv1.StackSet, stack string) {
+	if stackset.Annotations == nil {
+		stackset.Annotations = map[string]string{}
+	}
+	stackset.Annotations[RollbackAnnotationKey] = stack
+
+	if stackset.Spec.BlueGreen != nil {
+		stackset.Spec.BlueGreen.Promote = stack
+		return
+	}
+
+	traffic := []*zv1.DesiredTraffic{
+		{
+			StackName: stack,
+			Weight:    100,
+		},
+	}
+	for _, t := range stackset.Spec.Traffic {
+		if t.NotBefore == nil && t.Mirror > 0 && t.StackName != stack {
+			traffic = append(traffic, &zv1.DesiredTraffic{
+				StackName: t.StackName,
+				Mirror:    t.Mirror,
+			})
+		}
+	}
+	stackset.Spec.Traffic = traffic
+
+	rollout := stackset.Status.Rollout
+	if stackset.Spec.Rollout != nil && rollout != nil && rollout.StackName != stack &&
+		(rollout.Phase == zv1.RolloutPhaseProgressing || rollout.Phase == zv1.RolloutPhasePaused) {
+		stackset.Spec.Rollout.Aborted = true
+	}
+}
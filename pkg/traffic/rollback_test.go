@@ -0,0 +1,379 @@
+package traffic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRollbackStack(t *testing.T) {
+	now := time.Now()
+	scheduled := metav1.NewTime(now.Add(time.Hour))
+
+	for _, tc := range []struct {
+		name      string
+		stackset  *zv1.StackSet
+		actual    []*zv1.ActualTraffic
+		stacks    []*zv1.Stack
+		blueGreen *zv1.BlueGreenStatus
+		expected  string
+	}{
+		{
+			name:     "no stack had traffic before",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			stacks: []*zv1.Stack{
+				testStack("foo-v1", time.Time{}),
+				testStack("foo-v2", time.Time{}),
+			},
+		},
+		{
+			name:     "only the stack with desired traffic had traffic before",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			stacks: []*zv1.Stack{
+				testStack("foo-v2", now.Add(-time.Hour)),
+			},
+		},
+		{
+			name:     "stack which most recently had traffic",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 100}),
+			stacks: []*zv1.Stack{
+				testStack("foo-v1", now.Add(-2*time.Hour)),
+				testStack("foo-v2", now.Add(-time.Hour)),
+				testStack("foo-v3", time.Time{}),
+			},
+			expected: "foo-v2",
+		},
+		{
+			name:     "stacks without traffic for the same time are sorted by name",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 100}),
+			stacks: []*zv1.Stack{
+				testStack("foo-v2", now.Add(-time.Hour)),
+				testStack("foo-v1", now.Add(-time.Hour)),
+			},
+			expected: "foo-v1",
+		},
+		{
+			name: "scheduled and mirrored traffic isn't desired traffic",
+			stackset: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 100},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Mirror: 10},
+				&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100, NotBefore: &scheduled},
+			),
+			stacks: []*zv1.Stack{
+				testStack("foo-v1", now.Add(-2*time.Hour)),
+				testStack("foo-v2", now.Add(-time.Hour)),
+				testStack("foo-v3", time.Time{}),
+			},
+			expected: "foo-v2",
+		},
+		{
+			name: "stack with the most traffic during a canary",
+			stackset: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 90},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 10},
+			),
+			stacks: []*zv1.Stack{
+				testStack("foo-v0", now.Add(-time.Hour)),
+				testStack("foo-v1", time.Time{}),
+				testStack("foo-v2", time.Time{}),
+			},
+			expected: "foo-v1",
+		},
+		{
+			name:     "stack with actual traffic",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			actual: []*zv1.ActualTraffic{
+				{StackName: "foo-v1", Weight: 40},
+				{StackName: "foo-v2", Weight: 60},
+			},
+			stacks: []*zv1.Stack{
+				testStack("foo-v0", now.Add(-time.Minute)),
+				testStack("foo-v1", time.Time{}),
+				testStack("foo-v2", time.Time{}),
+			},
+			expected: "foo-v1",
+		},
+		{
+			name:     "newest stack is the most recently created one",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+			stacks: []*zv1.Stack{
+				createdAt(testStack("foo-v1", time.Time{}), now),
+				createdAt(testStack("foo-v2", now.Add(-time.Hour)), now.Add(-2*time.Hour)),
+			},
+			expected: "foo-v2",
+		},
+		{
+			name:     "live stack of a blue/green stackset",
+			stackset: testStackSet(),
+			blueGreen: &zv1.BlueGreenStatus{
+				LiveStack: "foo-v2",
+			},
+			stacks: []*zv1.Stack{
+				testStack("foo-v1", now.Add(-2*time.Hour)),
+				testStack("foo-v2", now.Add(-time.Hour)),
+			},
+			expected: "foo-v1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.stackset.Status.Traffic = tc.actual
+			if tc.blueGreen != nil {
+				tc.stackset.Spec.BlueGreen = &zv1.BlueGreenSpec{}
+				tc.stackset.Status.BlueGreen = tc.blueGreen
+			}
+
+			var stacks []zv1.Stack
+			for _, stack := range tc.stacks {
+				stacks = append(stacks, *stack)
+			}
+			require.Equal(t, tc.expected, rollbackStack(tc.stackset, stacks))
+		})
+	}
+}
+
+func TestIsOnlyDesiredStack(t *testing.T) {
+	scheduled := metav1.NewTime(time.Now().Add(time.Hour))
+
+	for _, tc := range []struct {
+		name     string
+		stackset *zv1.StackSet
+		promote  string
+		expected bool
+	}{
+		{
+			name:     "no desired traffic",
+			stackset: testStackSet(),
+		},
+		{
+			name:     "only desired stack",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+			expected: true,
+		},
+		{
+			name: "traffic split between stacks",
+			stackset: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 50},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 50},
+			),
+		},
+		{
+			name: "other stacks are only mirrored to or scheduled",
+			stackset: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Mirror: 10},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100, NotBefore: &scheduled},
+			),
+			expected: true,
+		},
+		{
+			name:     "promoted blue/green stack",
+			stackset: testStackSet(),
+			promote:  "foo-v1",
+			expected: true,
+		},
+		{
+			name:     "other blue/green stack promoted",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+			promote:  "foo-v2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.promote != "" {
+				tc.stackset.Spec.BlueGreen = &zv1.BlueGreenSpec{Promote: tc.promote}
+			}
+			require.Equal(t, tc.expected, isOnlyDesiredStack(tc.stackset, "foo-v1"))
+		})
+	}
+}
+
+func TestSetRollbackTraffic(t *testing.T) {
+	scheduled := metav1.NewTime(time.Now().Add(time.Hour))
+
+	for _, tc := range []struct {
+		name            string
+		stackset        *zv1.StackSet
+		blueGreen       bool
+		rollout         *zv1.RolloutStatus
+		expectedTraffic []*zv1.DesiredTraffic
+		expectedPromote string
+		expectedAborted bool
+	}{
+		{
+			name: "all traffic is moved to the stack",
+			stackset: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 70},
+				&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 30},
+				&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 100, NotBefore: &scheduled},
+			),
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 100},
+			},
+		},
+		{
+			name: "mirrors are preserved",
+			stackset: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v1", Mirror: 20},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100},
+				&zv1.DesiredTraffic{StackName: "foo-v3", Mirror: 10},
+			),
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 100},
+				{StackName: "foo-v3", Mirror: 10},
+			},
+		},
+		{
+			name:            "blue/green stack is promoted",
+			stackset:        testStackSet(),
+			blueGreen:       true,
+			expectedPromote: "foo-v1",
+		},
+		{
+			name:     "rollout in progress is aborted",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			rollout: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseProgressing,
+			},
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 100},
+			},
+			expectedAborted: true,
+		},
+		{
+			name:     "completed rollout isn't aborted",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			rollout: &zv1.RolloutStatus{
+				StackName: "foo-v2",
+				Phase:     zv1.RolloutPhaseCompleted,
+			},
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 100},
+			},
+		},
+		{
+			name:     "rollout of the stack isn't aborted",
+			stackset: testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			rollout: &zv1.RolloutStatus{
+				StackName: "foo-v1",
+				Phase:     zv1.RolloutPhasePaused,
+			},
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 100},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.blueGreen {
+				tc.stackset.Spec.BlueGreen = &zv1.BlueGreenSpec{}
+			}
+			if tc.rollout != nil {
+				tc.stackset.Spec.Rollout = &zv1.RolloutSpec{}
+				tc.stackset.Status.Rollout = tc.rollout
+			}
+
+			setRollbackTraffic(tc.stackset, "foo-v1")
+			require.Equal(t, "foo-v1", tc.stackset.Annotations[RollbackAnnotationKey])
+			if tc.blueGreen {
+				require.Equal(t, tc.expectedPromote, tc.stackset.Spec.BlueGreen.Promote)
+				return
+			}
+			require.Equal(t, tc.expectedTraffic, tc.stackset.Spec.Traffic)
+			if tc.rollout != nil {
+				require.Equal(t, tc.expectedAborted, tc.stackset.Spec.Rollout.Aborted)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	now := time.Now()
+
+	t.Run("no stack to roll back to", func(t *testing.T) {
+		client := newTestClient(
+			testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+			testStack("foo-v1", time.Time{}),
+		)
+
+		_, err := NewSwitcher(client).Rollback(context.Background(), "foo", "default", 0)
+		require.ErrorIs(t, err, ErrNoRollbackStack)
+		require.Empty(t, client.updatedStackSets())
+	})
+
+	t.Run("repeated rollback keeps the traffic on the same stack", func(t *testing.T) {
+		client := newTestClient(
+			testStackSet(&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 100}),
+			testStack("foo-v1", now.Add(-2*time.Hour)),
+			testStack("foo-v2", now.Add(-time.Hour)),
+			testStack("foo-v3", time.Time{}),
+		)
+		switcher := NewSwitcher(client)
+
+		target, err := switcher.Rollback(context.Background(), "foo", "default", 0)
+		require.NoError(t, err)
+		require.Equal(t, "foo-v2", target)
+
+		// foo-v3 had traffic most recently now, but the rollback is kept
+		stack, err := client.ZalandoV1().Stacks("default").Get(context.Background(), "foo-v3", metav1.GetOptions{})
+		require.NoError(t, err)
+		stack.Status.NoTrafficSince = &metav1.Time{Time: now}
+		_, err = client.ZalandoV1().Stacks("default").Update(context.Background(), stack, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		target, err = switcher.Rollback(context.Background(), "foo", "default", 0)
+		require.NoError(t, err)
+		require.Equal(t, "foo-v2", target)
+
+		updated := client.updatedStackSets()
+		require.Len(t, updated, 1)
+		require.Equal(t, "foo-v2", updated[0].Annotations[RollbackAnnotationKey])
+		require.Equal(t, []*zv1.DesiredTraffic{{StackName: "foo-v2", Weight: 100}}, updated[0].Spec.Traffic)
+	})
+
+	t.Run("traffic switched since the rollback", func(t *testing.T) {
+		stackset := testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100})
+		stackset.Annotations = map[string]string{RollbackAnnotationKey: "foo-v1"}
+		stackset.Status.Traffic = []*zv1.ActualTraffic{
+			{StackName: "foo-v1", Weight: 80},
+			{StackName: "foo-v3", Weight: 20},
+		}
+		client := newTestClient(
+			stackset,
+			testStack("foo-v1", time.Time{}),
+			testStack("foo-v2", now.Add(-time.Hour)),
+			testStack("foo-v3", time.Time{}),
+		)
+
+		target, err := NewSwitcher(client).Rollback(context.Background(), "foo", "default", 0)
+		require.NoError(t, err)
+		require.Equal(t, "foo-v1", target)
+
+		updated := client.updatedStackSets()
+		require.Len(t, updated, 1)
+		require.Equal(t, []*zv1.DesiredTraffic{{StackName: "foo-v1", Weight: 100}}, updated[0].Spec.Traffic)
+	})
+
+	t.Run("waiting for the traffic times out", func(t *testing.T) {
+		client := newTestClient(
+			testStackSet(&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 100}),
+			testStack("foo-v1", now.Add(-time.Hour)),
+			testStack("foo-v2", time.Time{}),
+		)
+
+		target, err := NewSwitcher(client).Rollback(context.Background(), "foo", "default", 10*time.Millisecond)
+		require.Equal(t, "foo-v1", target)
+
+		var notReady *StacksNotReadyError
+		require.ErrorAs(t, err, &notReady)
+		require.Equal(t, []string{"foo-v1"}, notReady.Stacks)
+	})
+}
+
+// createdAt sets the creation timestamp of the stack.
+func createdAt(stack *zv1.Stack, created time.Time) *zv1.Stack {
+	stack.CreationTimestamp = metav1.Time{Time: created}
+	return stack
+}
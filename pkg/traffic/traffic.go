@@ -2,160 +2,278 @@ package traffic
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
 
 	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
 	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
 	stacksetHeritageLabelKey           = "stackset"
 	StackTrafficWeightsAnnotationKey   = "zalando.org/stack-traffic-weights"
 	DefaultBackendWeightsAnnotationKey = "zalando.org/backend-weights"
+
+	// weightTolerance is the maximum difference between two traffic
+	// weights which are considered equal, to ignore rounding errors of
+	// normalized weights.
+	weightTolerance = 0.01
 )
 
 // Switcher is able to switch traffic between stacks.
 type Switcher struct {
-	client                      clientset.Interface
-	backendWeightsAnnotationKey string
+	client clientset.Interface
 }
 
 // NewSwitcher initializes a new traffic switcher.
-func NewSwitcher(client clientset.Interface, backendWeightsAnnotationKey string) *Switcher {
+func NewSwitcher(client clientset.Interface) *Switcher {
 	return &Switcher{
-		client:                      client,
-		backendWeightsAnnotationKey: backendWeightsAnnotationKey,
+		client: client,
 	}
 }
 
-// Switch changes traffic weight for a stack.
+// Switch changes traffic weight for a stack. The weights of the other stacks
+// are adjusted relatively.
 func (t *Switcher) Switch(ctx context.Context, stackset, stack, namespace string, weight float64) ([]StackTrafficWeight, error) {
-	stacks, err := t.getStacks(ctx, stackset, namespace)
-	if err != nil {
-		return nil, err
-	}
+	return t.updateTraffic(ctx, stackset, namespace, func(stacks []StackTrafficWeight) ([]StackTrafficWeight, error) {
+		return setWeightForStacks(normalizeWeights(stacks), stack, weight)
+	})
+}
 
-	normalized := normalizeWeights(stacks)
-	newWeights, err := setWeightForStacks(normalized, stack, weight)
-	if err != nil {
-		return nil, err
+// SwitchWeights sets the traffic weights of all stacks at once. Stacks can be
+// referred to by their name or their version, stacks which aren't specified
+// don't get any traffic. The weights must add up to 100.
+func (t *Switcher) SwitchWeights(ctx context.Context, stackset, namespace string, weights map[string]float64) ([]StackTrafficWeight, error) {
+	sum := float64(0)
+	for stack, weight := range weights {
+		if weight < 0 || weight > 100 {
+			return nil, fmt.Errorf("traffic weight %.1f of stack %s must be between 0 and 100", weight, stack)
+		}
+		sum += weight
+	}
+	if math.Abs(sum-100) > weightTolerance {
+		return nil, fmt.Errorf("traffic weights must add up to 100, got %.1f", sum)
 	}
 
-	changeNeeded := false
-	stackWeights := make(map[string]float64, len(newWeights))
-	for i, stack := range newWeights {
-		if stack.Weight != stacks[i].Weight {
-			changeNeeded = true
+	return t.updateTraffic(ctx, stackset, namespace, func(stacks []StackTrafficWeight) ([]StackTrafficWeight, error) {
+		stackWeights := make(map[string]float64, len(weights))
+		for name, weight := range weights {
+			stack, ok := findStack(stacks, stackset, name)
+			if !ok {
+				return nil, fmt.Errorf("stack %s not found in stackset %s/%s", name, namespace, stackset)
+			}
+			stackWeights[stack] = weight
 		}
-		stackWeights[stack.Name] = stack.Weight
-	}
 
-	if changeNeeded {
-		stackWeightsData, err := json.Marshal(&stackWeights)
+		newWeights := make([]StackTrafficWeight, len(stacks))
+		for i, stack := range stacks {
+			stack.Weight = stackWeights[stack.Name]
+			newWeights[i] = stack
+		}
+		return newWeights, nil
+	})
+}
+
+// updateTraffic computes the new traffic weights of the stacks and sets them
+// as the desired traffic of the StackSet in a single update, retrying it on
+// conflicts.
+func (t *Switcher) updateTraffic(ctx context.Context, stackset, namespace string, update func([]StackTrafficWeight) ([]StackTrafficWeight, error)) ([]StackTrafficWeight, error) {
+	var newWeights []StackTrafficWeight
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ss, stacks, err := t.getStacks(ctx, stackset, namespace)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		annotation := map[string]map[string]map[string]string{
-			"metadata": map[string]map[string]string{
-				"annotations": map[string]string{
-					StackTrafficWeightsAnnotationKey: string(stackWeightsData),
-				},
-			},
+		if ss.Spec.BlueGreen != nil {
+			return fmt.Errorf("stackset %s/%s uses blue/green deployments, promote a stack instead", namespace, stackset)
 		}
 
-		annotationData, err := json.Marshal(&annotation)
+		newWeights, err = update(stacks)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		_, err = t.client.NetworkingV1().Ingresses(namespace).Patch(ctx, stackset, types.StrategicMergePatchType, annotationData, metav1.PatchOptions{})
-		if err != nil {
-			return nil, err
+		changeNeeded := false
+		for i, stack := range newWeights {
+			if stack.Weight != stacks[i].Weight {
+				changeNeeded = true
+			}
+		}
+		if !changeNeeded {
+			return nil
 		}
-	}
 
+		updated := ss.DeepCopy()
+		setDesiredTraffic(updated, newWeights)
+		_, err = t.client.ZalandoV1().StackSets(namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 	return newWeights, nil
 }
 
+// StackTrafficWeight is the traffic weight and status of a stack.
 type StackTrafficWeight struct {
-	Name         string
-	Weight       float64
-	ActualWeight float64
+	Name            string               `json:"name"`
+	Weight          float64              `json:"desiredWeight"`
+	ActualWeight    float64              `json:"actualWeight"`
+	Replicas        int32                `json:"replicas"`
+	ReadyReplicas   int32                `json:"readyReplicas"`
+	UpdatedReplicas int32                `json:"updatedReplicas"`
+	DesiredReplicas int32                `json:"desiredReplicas"`
+	Prescaling      zv1.PrescalingStatus `json:"prescalingStatus"`
+	NoTrafficSince  *metav1.Time         `json:"noTrafficSince,omitempty"`
+	Pinned          bool                 `json:"pinned,omitempty"`
+}
+
+// Converged returns true if the actual traffic weights of all stacks are
+// equal to the desired ones.
+func Converged(stacks []StackTrafficWeight) bool {
+	return len(NotConverged(stacks)) == 0
+}
+
+// NotConverged returns the names of the stacks whose actual traffic weight
+// isn't equal to the desired one.
+func NotConverged(stacks []StackTrafficWeight) []string {
+	var names []string
+	for _, stack := range stacks {
+		if math.Abs(stack.Weight-stack.ActualWeight) > weightTolerance {
+			names = append(names, stack.Name)
+		}
+	}
+	return names
 }
 
 // TrafficWeights returns a list of stacks with their current traffic weight.
 func (t *Switcher) TrafficWeights(ctx context.Context, stackset, namespace string) ([]StackTrafficWeight, error) {
-	stacks, err := t.getStacks(ctx, stackset, namespace)
+	_, stacks, err := t.getStacks(ctx, stackset, namespace)
 	if err != nil {
 		return nil, err
 	}
 	return normalizeWeights(stacks), nil
 }
 
-// getStacks returns the stacks of the stackset.
-func (t *Switcher) getStacks(ctx context.Context, stackset, namespace string) ([]StackTrafficWeight, error) {
-	heritageLabels := map[string]string{
-		stacksetHeritageLabelKey: stackset,
-	}
-	opts := metav1.ListOptions{
-		LabelSelector: labels.Set(heritageLabels).String(),
+// getStacks returns the stackset and the traffic of its stacks. The desired
+// traffic is read from the spec of the stackset and the actual traffic from
+// its status.
+func (t *Switcher) getStacks(ctx context.Context, stackset, namespace string) (*zv1.StackSet, []StackTrafficWeight, error) {
+	ss, err := t.client.ZalandoV1().StackSets(namespace).Get(ctx, stackset, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	stacks, err := t.client.ZalandoV1().Stacks(namespace).List(ctx, opts)
+	stacks, err := t.listStacks(ctx, stackset, namespace)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list stacks of stackset %s/%s: %v", namespace, stackset, err)
+		return nil, nil, err
 	}
 
-	desired, actual, err := t.getIngressTraffic(ctx, stackset, namespace, stacks.Items)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Ingress traffic for StackSet %s/%s: %v", namespace, stackset, err)
+	desired := make(map[string]float64, len(ss.Spec.Traffic))
+	switch {
+	case ss.Spec.BlueGreen != nil && ss.Spec.BlueGreen.Promote != "":
+		// Blue/green StackSets switch all traffic to the promoted stack
+		desired[ss.Spec.BlueGreen.Promote] = 100
+	case ss.Spec.BlueGreen != nil && ss.Status.BlueGreen != nil:
+		desired[ss.Status.BlueGreen.LiveStack] = 100
+	default:
+		for _, traffic := range ss.Spec.Traffic {
+			if traffic.NotBefore == nil {
+				desired[traffic.StackName] = traffic.Weight
+			}
+		}
+	}
+
+	actual := make(map[string]float64, len(ss.Status.Traffic))
+	for _, traffic := range ss.Status.Traffic {
+		actual[traffic.StackName] = traffic.Weight
 	}
 
-	stackWeights := make([]StackTrafficWeight, 0, len(stacks.Items))
-	for _, stack := range stacks.Items {
+	stackWeights := make([]StackTrafficWeight, 0, len(stacks))
+	for _, stack := range stacks {
 		stackWeight := StackTrafficWeight{
 			Name:         stack.Name,
 			Weight:       desired[stack.Name],
 			ActualWeight: actual[stack.Name],
+
+			Replicas:        stack.Status.Replicas,
+			ReadyReplicas:   stack.Status.ReadyReplicas,
+			UpdatedReplicas: stack.Status.UpdatedReplicas,
+			DesiredReplicas: stack.Status.DesiredReplicas,
+			Prescaling:      stack.Status.Prescaling,
+			NoTrafficSince:  stack.Status.NoTrafficSince,
+			Pinned:          stack.Status.Pinned,
 		}
 
 		stackWeights = append(stackWeights, stackWeight)
 	}
-	return stackWeights, nil
+	return ss, stackWeights, nil
 }
 
-func (t *Switcher) getIngressTraffic(ctx context.Context, name, namespace string, stacks []zv1.Stack) (map[string]float64, map[string]float64, error) {
-	if len(stacks) == 0 {
-		return map[string]float64{}, map[string]float64{}, nil
+// listStacks returns the stacks of the stackset.
+func (t *Switcher) listStacks(ctx context.Context, stackset, namespace string) ([]zv1.Stack, error) {
+	heritageLabels := map[string]string{
+		stacksetHeritageLabelKey: stackset,
+	}
+	opts := metav1.ListOptions{
+		LabelSelector: labels.Set(heritageLabels).String(),
 	}
 
-	ingress, err := t.client.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+	stacks, err := t.client.ZalandoV1().Stacks(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("failed to list stacks of stackset %s/%s: %v", namespace, stackset, err)
 	}
+	return stacks.Items, nil
+}
 
-	desiredTraffic := make(map[string]float64, len(stacks))
-	if weights, ok := ingress.Annotations[StackTrafficWeightsAnnotationKey]; ok {
-		err := json.Unmarshal([]byte(weights), &desiredTraffic)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to get current desired Stack traffic weights: %v", err)
+// findStack returns the name of the stack referred to by its name or its
+// version.
+func findStack(stacks []StackTrafficWeight, stackset, name string) (string, bool) {
+	for _, stack := range stacks {
+		if stack.Name == name || stack.Name == stackset+"-"+name {
+			return stack.Name, true
 		}
 	}
+	return "", false
+}
 
-	actualTraffic := make(map[string]float64, len(stacks))
-	if weights, ok := ingress.Annotations[t.backendWeightsAnnotationKey]; ok {
-		err := json.Unmarshal([]byte(weights), &actualTraffic)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to get current actual Stack traffic weights: %v", err)
+// setDesiredTraffic sets the desired traffic of the stackset to the weights
+// of the stacks. Mirrored traffic and scheduled traffic switches are kept,
+// a previous rollback is forgotten.
+func setDesiredTraffic(stackset *zv1.StackSet, stacks []StackTrafficWeight) {
+	delete(stackset.Annotations, RollbackAnnotationKey)
+
+	mirror := make(map[string]float64)
+	var scheduled []*zv1.DesiredTraffic
+	for _, traffic := range stackset.Spec.Traffic {
+		if traffic.NotBefore != nil {
+			scheduled = append(scheduled, traffic)
+			continue
+		}
+		mirror[traffic.StackName] = traffic.Mirror
+	}
+
+	traffic := make([]*zv1.DesiredTraffic, 0, len(stacks)+len(scheduled))
+	for _, stack := range stacks {
+		if stack.Weight == 0 && mirror[stack.Name] == 0 {
+			continue
 		}
+		traffic = append(traffic, &zv1.DesiredTraffic{
+			StackName: stack.Name,
+			Weight:    stack.Weight,
+			Mirror:    mirror[stack.Name],
+		})
 	}
+	sort.Slice(traffic, func(i, j int) bool {
+		return traffic[i].StackName < traffic[j].StackName
+	})
 
-	return desiredTraffic, actualTraffic, nil
+	stackset.Spec.Traffic = append(traffic, scheduled...)
 }
 
 // setWeightForStacks sets new traffic weight for the specified stack and adjusts
@@ -0,0 +1,226 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	rginterface "github.com/szuecs/routegroup-client/client/clientset/versioned"
+	rgfake "github.com/szuecs/routegroup-client/client/clientset/versioned/fake"
+	rgi "github.com/szuecs/routegroup-client/client/clientset/versioned/typed/zalando.org/v1"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	ssfake "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned/fake"
+	zi "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned/typed/zalando.org/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type testClient struct {
+	kubernetes.Interface
+	ssClient *ssfake.Clientset
+	rgClient rginterface.Interface
+}
+
+func (c *testClient) ZalandoV1() zi.ZalandoV1Interface {
+	return c.ssClient.ZalandoV1()
+}
+
+func (c *testClient) RouteGroupV1() rgi.ZalandoV1Interface {
+	return c.rgClient.ZalandoV1()
+}
+
+func newTestClient(objects ...runtime.Object) *testClient {
+	return &testClient{
+		Interface: fake.NewSimpleClientset(),
+		ssClient:  ssfake.NewSimpleClientset(objects...),
+		rgClient:  rgfake.NewSimpleClientset(),
+	}
+}
+
+func testStackSet(traffic ...*zv1.DesiredTraffic) *zv1.StackSet {
+	return &zv1.StackSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+		},
+		Spec: zv1.StackSetSpec{
+			Traffic: traffic,
+		},
+	}
+}
+
+// testStack returns a stack of the stackset foo, which last had traffic at
+// noTrafficSince unless it's zero.
+func testStack(name string, noTrafficSince time.Time) *zv1.Stack {
+	stack := &zv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{stacksetHeritageLabelKey: "foo"},
+		},
+	}
+	if !noTrafficSince.IsZero() {
+		stack.Status.NoTrafficSince = &metav1.Time{Time: noTrafficSince}
+	}
+	return stack
+}
+
+// updatedStackSets returns the stacksets updated through the client.
+func (c *testClient) updatedStackSets() []*zv1.StackSet {
+	var result []*zv1.StackSet
+	for _, action := range c.ssClient.Actions() {
+		if action.Matches("update", "stacksets") && action.GetSubresource() == "" {
+			result = append(result, action.(k8stesting.UpdateAction).GetObject().(*zv1.StackSet))
+		}
+	}
+	return result
+}
+
+func TestSwitchWeights(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		weights         map[string]float64
+		expectedError   string
+		expectedTraffic []*zv1.DesiredTraffic
+	}{
+		{
+			name:    "weights of all stacks",
+			weights: map[string]float64{"foo-v1": 30, "v2": 70},
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v1", Weight: 30},
+				{StackName: "foo-v2", Weight: 70},
+				{StackName: "foo-v3", Mirror: 10},
+			},
+		},
+		{
+			name:    "stacks which aren't specified don't get traffic",
+			weights: map[string]float64{"foo-v2": 100},
+			expectedTraffic: []*zv1.DesiredTraffic{
+				{StackName: "foo-v2", Weight: 100},
+				{StackName: "foo-v3", Mirror: 10},
+			},
+		},
+		{
+			name:          "weights not adding up to 100",
+			weights:       map[string]float64{"foo-v1": 30, "foo-v2": 60},
+			expectedError: "traffic weights must add up to 100, got 90.0",
+		},
+		{
+			name:          "weight out of range",
+			weights:       map[string]float64{"foo-v1": 110, "foo-v2": -10},
+			expectedError: "must be between 0 and 100",
+		},
+		{
+			name:          "unknown stack",
+			weights:       map[string]float64{"foo-v1": 50, "foo-v4": 50},
+			expectedError: "stack foo-v4 not found in stackset default/foo",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stackset := testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100},
+				&zv1.DesiredTraffic{StackName: "foo-v3", Mirror: 10},
+			)
+			stackset.Annotations = map[string]string{RollbackAnnotationKey: "foo-v1"}
+			client := newTestClient(
+				stackset,
+				testStack("foo-v1", time.Time{}),
+				testStack("foo-v2", time.Time{}),
+				testStack("foo-v3", time.Time{}),
+			)
+
+			_, err := NewSwitcher(client).SwitchWeights(context.Background(), "foo", "default", tc.weights)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+				require.Empty(t, client.updatedStackSets())
+				return
+			}
+			require.NoError(t, err)
+
+			updated := client.updatedStackSets()
+			require.Len(t, updated, 1)
+			require.Equal(t, tc.expectedTraffic, updated[0].Spec.Traffic)
+			require.NotContains(t, updated[0].Annotations, RollbackAnnotationKey)
+		})
+	}
+}
+
+func TestSwitchWeightsRetriesOnConflict(t *testing.T) {
+	client := newTestClient(
+		testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+		testStack("foo-v1", time.Time{}),
+		testStack("foo-v2", time.Time{}),
+	)
+
+	// The first update conflicts with a concurrent change of the traffic
+	conflicts := 0
+	client.ssClient.PrependReactor("update", "stacksets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+
+		stackset := testStackSet(
+			&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 50},
+			&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 50},
+		)
+		err := client.ssClient.Tracker().Update(zv1.SchemeGroupVersion.WithResource("stacksets"), stackset, "default")
+		if err != nil {
+			return true, nil, err
+		}
+		return true, nil, apierrors.NewConflict(zv1.Resource("stacksets"), "foo", errors.New("the object has been modified"))
+	})
+
+	weights, err := NewSwitcher(client).SwitchWeights(context.Background(), "foo", "default", map[string]float64{"foo-v2": 100})
+	require.NoError(t, err)
+	require.Equal(t, 1, conflicts)
+	require.Equal(t, []float64{0, 100}, []float64{weights[0].Weight, weights[1].Weight})
+
+	updated := client.updatedStackSets()
+	require.Len(t, updated, 2)
+	require.Equal(t, []*zv1.DesiredTraffic{{StackName: "foo-v2", Weight: 100}}, updated[1].Spec.Traffic)
+
+	stackset, err := client.ZalandoV1().StackSets("default").Get(context.Background(), "foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []*zv1.DesiredTraffic{{StackName: "foo-v2", Weight: 100}}, stackset.Spec.Traffic)
+}
+
+func TestConverged(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		stacks               []StackTrafficWeight
+		expectedNotConverged []string
+	}{
+		{
+			name: "no stacks",
+		},
+		{
+			name: "actual traffic matches the desired traffic",
+			stacks: []StackTrafficWeight{
+				{Name: "foo-v1", Weight: 33.333, ActualWeight: 33.33},
+				{Name: "foo-v2", Weight: 66.667, ActualWeight: 66.67},
+				{Name: "foo-v3"},
+			},
+		},
+		{
+			name: "actual traffic differs from the desired traffic",
+			stacks: []StackTrafficWeight{
+				{Name: "foo-v1", Weight: 0, ActualWeight: 10},
+				{Name: "foo-v2", Weight: 50, ActualWeight: 50},
+				{Name: "foo-v3", Weight: 50, ActualWeight: 40},
+			},
+			expectedNotConverged: []string{"foo-v1", "foo-v3"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedNotConverged, NotConverged(tc.stacks))
+			require.Equal(t, len(tc.expectedNotConverged) == 0, Converged(tc.stacks))
+		})
+	}
+}
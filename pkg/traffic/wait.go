@@ -0,0 +1,98 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	trafficPollInterval = 2 * time.Second
+)
+
+// StacksNotReadyError is returned by WaitForTraffic if the actual traffic of
+// some stacks didn't reach the desired traffic in time.
+type StacksNotReadyError struct {
+	// Stacks are the names of the stacks which don't get their desired
+	// traffic.
+	Stacks []string
+}
+
+func (e *StacksNotReadyError) Error() string {
+	return fmt.Sprintf("stacks not ready: %s", strings.Join(e.Stacks, ", "))
+}
+
+// WaitForTraffic blocks until the actual traffic weights of all stacks of the
+// stackset are equal to the desired ones. The progress is logged whenever the
+// actual traffic changes. If the traffic doesn't converge within the timeout,
+// a *StacksNotReadyError listing the stacks without their desired traffic is
+// returned.
+func (t *Switcher) WaitForTraffic(ctx context.Context, stackset, namespace string, timeout time.Duration) error {
+	var notReady []string
+	var last []StackTrafficWeight
+	err := wait.PollUntilContextTimeout(ctx, trafficPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		stacks, err := t.TrafficWeights(ctx, stackset, namespace)
+		if err != nil {
+			return false, err
+		}
+
+		notReady = nil
+		for i, stack := range stacks {
+			if math.Abs(stack.Weight-stack.ActualWeight) <= weightTolerance {
+				continue
+			}
+			notReady = append(notReady, stack.Name)
+
+			if i >= len(last) || last[i].Name != stack.Name || last[i].ActualWeight != stack.ActualWeight {
+				log.Infof("Waiting for traffic of stack %s/%s: %.1f%% of %.1f%% switched, %d/%d replicas ready.",
+					namespace, stack.Name, stack.ActualWeight, stack.Weight, stack.ReadyReplicas, stack.Replicas)
+			}
+		}
+		last = stacks
+
+		return len(notReady) == 0, nil
+	})
+	if err != nil {
+		if len(notReady) > 0 && wait.Interrupted(err) && !errors.Is(ctx.Err(), context.Canceled) {
+			return &StacksNotReadyError{Stacks: notReady}
+		}
+		return err
+	}
+	return nil
+}
+
+// WaitForObservedGeneration blocks until the controller reconciled the
+// current generation of the stackset, so that its status reflects the latest
+// changes of the spec. It doesn't wait for controllers which don't record the
+// observed generation.
+func (t *Switcher) WaitForObservedGeneration(ctx context.Context, stackset, namespace string, timeout time.Duration) error {
+	var generation, observed int64
+	err := wait.PollUntilContextTimeout(ctx, trafficPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		ss, err := t.client.ZalandoV1().StackSets(namespace).Get(ctx, stackset, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		// Only wait for the generation which was current when starting,
+		// other clients may keep updating the stackset.
+		if generation == 0 {
+			generation = ss.Generation
+		}
+		observed = ss.Status.ObservedGeneration
+		return observed == 0 || observed >= generation, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && !errors.Is(ctx.Err(), context.Canceled) {
+			return fmt.Errorf("stackset %s/%s wasn't reconciled in time: observed generation %d, expected %d", namespace, stackset, observed, generation)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package traffic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+)
+
+func TestWaitForTraffic(t *testing.T) {
+	stackset := testStackSet(
+		&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 20},
+		&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 30},
+		&zv1.DesiredTraffic{StackName: "foo-v3", Weight: 50},
+	)
+	stackset.Status.Traffic = []*zv1.ActualTraffic{
+		{StackName: "foo-v1", Weight: 20},
+		{StackName: "foo-v2", Weight: 80},
+	}
+
+	client := newTestClient(
+		stackset,
+		testStack("foo-v1", time.Time{}),
+		testStack("foo-v2", time.Time{}),
+		testStack("foo-v3", time.Time{}),
+	)
+	switcher := NewSwitcher(client)
+
+	t.Run("stacks without their desired traffic", func(t *testing.T) {
+		err := switcher.WaitForTraffic(context.Background(), "foo", "default", 10*time.Millisecond)
+
+		var notReady *StacksNotReadyError
+		require.ErrorAs(t, err, &notReady)
+		require.Equal(t, []string{"foo-v2", "foo-v3"}, notReady.Stacks)
+		require.EqualError(t, err, "stacks not ready: foo-v2, foo-v3")
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := switcher.WaitForTraffic(ctx, "foo", "default", time.Minute)
+		require.Equal(t, ctx.Err(), err)
+	})
+
+	t.Run("converged traffic", func(t *testing.T) {
+		stackset := testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100})
+		stackset.Status.Traffic = []*zv1.ActualTraffic{{StackName: "foo-v1", Weight: 100}}
+		client := newTestClient(
+			stackset,
+			testStack("foo-v1", time.Time{}),
+			testStack("foo-v2", time.Time{}),
+		)
+
+		err := NewSwitcher(client).WaitForTraffic(context.Background(), "foo", "default", time.Minute)
+		require.NoError(t, err)
+	})
+}
+
+func TestWaitForObservedGeneration(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		generation    int64
+		observed      int64
+		expectedError string
+	}{
+		{
+			name:       "current generation reconciled",
+			generation: 3,
+			observed:   3,
+		},
+		{
+			name:       "controller not recording the observed generation",
+			generation: 3,
+		},
+		{
+			name:          "current generation not reconciled",
+			generation:    3,
+			observed:      2,
+			expectedError: "stackset default/foo wasn't reconciled in time: observed generation 2, expected 3",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stackset := testStackSet()
+			stackset.Generation = tc.generation
+			stackset.Status.ObservedGeneration = tc.observed
+
+			err := NewSwitcher(newTestClient(stackset)).WaitForObservedGeneration(context.Background(), "foo", "default", 10*time.Millisecond)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("cancelled context", func(t *testing.T) {
+		stackset := testStackSet()
+		stackset.Generation = 3
+		stackset.Status.ObservedGeneration = 2
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := NewSwitcher(newTestClient(stackset)).WaitForObservedGeneration(ctx, "foo", "default", time.Minute)
+		require.Equal(t, ctx.Err(), err)
+	})
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// patchOperation is a JSON patch (RFC 6902) operation. The value is always
+// encoded, as add and replace operations require it even if it's null.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// createPatch returns the JSON patch turning the raw object of an admission
+// request into the mutated object. Both the decoded and the mutated object are
+// compared in their encoded form, so fields the encoding adds to the raw
+// object, e.g. empty structs, aren't part of the patch unless they changed.
+func createPatch(raw []byte, decoded, mutated interface{}) ([]patchOperation, error) {
+	var rawDoc interface{}
+	err := json.Unmarshal(raw, &rawDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := toJSONValue(decoded)
+	if err != nil {
+		return nil, err
+	}
+	after, err := toJSONValue(mutated)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch []patchOperation
+	diffValues(&patch, "", rawDoc, true, before, after)
+	return patch, nil
+}
+
+// diffValues appends the operations for the changes between before and after
+// at path, which exists in the raw object if rawExists is set.
+func diffValues(patch *[]patchOperation, path string, raw interface{}, rawExists bool, before, after interface{}) {
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+
+	if !rawExists {
+		*patch = append(*patch, patchOperation{Op: "add", Path: path, Value: pruneEmpty(after)})
+		return
+	}
+
+	rawMap, rawIsMap := raw.(map[string]interface{})
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if !rawIsMap || !beforeIsMap || !afterIsMap {
+		*patch = append(*patch, patchOperation{Op: "replace", Path: path, Value: after})
+		return
+	}
+
+	keys := make([]string, 0, len(beforeMap)+len(afterMap))
+	for key := range beforeMap {
+		keys = append(keys, key)
+	}
+	for key := range afterMap {
+		if _, ok := beforeMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "/" + escapePointer(key)
+		rawValue, rawHasKey := rawMap[key]
+		afterValue, afterHasKey := afterMap[key]
+		if !afterHasKey {
+			if rawHasKey {
+				*patch = append(*patch, patchOperation{Op: "remove", Path: childPath})
+			}
+			continue
+		}
+		diffValues(patch, childPath, rawValue, rawHasKey, beforeMap[key], afterValue)
+	}
+}
+
+// pruneEmpty removes null values and empty objects added by the encoding.
+func pruneEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			child = pruneEmpty(child)
+			if child == nil {
+				continue
+			}
+			if m, ok := child.(map[string]interface{}); ok && len(m) == 0 {
+				continue
+			}
+			result[key] = child
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, child := range v {
+			result = append(result, pruneEmpty(child))
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// escapePointer escapes a key for use in a JSON pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func toJSONValue(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+)
+
+func TestCreatePatch(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		raw      string
+		mutated  string
+		expected string
+	}{
+		{
+			name:     "unchanged object",
+			raw:      `{"spec":{"replicas":1}}`,
+			mutated:  `{"spec":{"replicas":1}}`,
+			expected: `{"spec":{"replicas":1}}`,
+		},
+		{
+			name:     "zero values are added",
+			raw:      `{"spec":{}}`,
+			mutated:  `{"spec":{"replicas":0,"paused":false,"version":"","ports":[]}}`,
+			expected: `{"spec":{"replicas":0,"paused":false,"version":"","ports":[]}}`,
+		},
+		{
+			name:     "values are replaced by zero values",
+			raw:      `{"spec":{"replicas":3,"paused":true,"version":"v1","ports":[80]}}`,
+			mutated:  `{"spec":{"replicas":0,"paused":false,"version":"","ports":[]}}`,
+			expected: `{"spec":{"replicas":0,"paused":false,"version":"","ports":[]}}`,
+		},
+		{
+			name:     "values are replaced by null",
+			raw:      `{"spec":{"replicas":3}}`,
+			mutated:  `{"spec":{"replicas":null}}`,
+			expected: `{"spec":{"replicas":null}}`,
+		},
+		{
+			name:     "objects added by the encoding are pruned",
+			raw:      `{"spec":{}}`,
+			mutated:  `{"spec":{"lifecycle":{"limit":10,"template":{},"selector":null}}}`,
+			expected: `{"spec":{"lifecycle":{"limit":10}}}`,
+		},
+		{
+			name:     "removed values",
+			raw:      `{"spec":{"replicas":3,"a/b":"c"}}`,
+			mutated:  `{"spec":{}}`,
+			expected: `{"spec":{}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var decoded, mutated interface{}
+			require.NoError(t, json.Unmarshal([]byte(tc.raw), &decoded))
+			require.NoError(t, json.Unmarshal([]byte(tc.mutated), &mutated))
+
+			operations, err := createPatch([]byte(tc.raw), decoded, mutated)
+			require.NoError(t, err)
+			data, err := json.Marshal(operations)
+			require.NoError(t, err)
+
+			// add and replace operations must have a value (RFC 6902)
+			var encoded []map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &encoded))
+			for _, operation := range encoded {
+				if operation["op"] != "remove" {
+					require.Contains(t, operation, "value")
+				}
+			}
+
+			patch, err := jsonpatch.DecodePatch(data)
+			require.NoError(t, err)
+			patched, err := patch.Apply([]byte(tc.raw))
+			require.NoError(t, err)
+			require.JSONEq(t, tc.expected, string(patched))
+		})
+	}
+}
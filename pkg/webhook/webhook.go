@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/clientset"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ValidatePath is the path of the validating admission webhook.
+	ValidatePath = "/validate"
+	// MutatePath is the path of the mutating admission webhook.
+	MutatePath = "/mutate"
+
+	maxRequestSize = 3 * 1024 * 1024
+)
+
+// Webhook is an admission webhook for StackSets and Stacks, rejecting
+// invalid resources before they're stored instead of failing to reconcile
+// them later, and setting the defaults of StackSets.
+type Webhook struct {
+	client clientset.Interface
+}
+
+// admitFunc reviews an admission request.
+type admitFunc func(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error)
+
+// New initializes a new admission webhook.
+func New(client clientset.Interface) *Webhook {
+	return &Webhook{
+		client: client,
+	}
+}
+
+// Handler returns the HTTP handler serving the admission webhook.
+func (w *Webhook) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(ValidatePath, serveAdmission(w.validate))
+	mux.Handle(MutatePath, serveAdmission(w.mutate))
+	return mux
+}
+
+// serveAdmission decodes the AdmissionReview of the request, reviews it and
+// writes the response.
+func serveAdmission(admit admitFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var review admissionv1.AdmissionReview
+		err = json.Unmarshal(body, &review)
+		if err != nil || review.Request == nil {
+			http.Error(rw, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		response, err := admit(r.Context(), review.Request)
+		if err != nil {
+			log.Errorf("Failed to review %s %s/%s: %v", review.Request.Kind.Kind, review.Request.Namespace, review.Request.Name, err)
+			response = &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: err.Error(),
+					Reason:  metav1.StatusReasonInternalError,
+					Code:    http.StatusInternalServerError,
+				},
+			}
+		}
+		response.UID = review.Request.UID
+
+		data, err := json.Marshal(&admissionv1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: response,
+		})
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write(data)
+	}
+}
+
+// validate rejects invalid StackSets and Stacks.
+func (w *Webhook) validate(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return allowed(), nil
+	}
+
+	var err error
+	switch request.Kind.Kind {
+	case core.KindStackSet:
+		var stackset, old *zv1.StackSet
+		stackset, old, err = decodeObjects[zv1.StackSet](request)
+		if err != nil {
+			return nil, err
+		}
+		if stackset.Namespace == "" {
+			stackset.Namespace = request.Namespace
+		}
+
+		stacks, listErr := w.stackNames(ctx, stackset)
+		if listErr != nil {
+			return nil, listErr
+		}
+		err = core.ValidateStackSet(stackset, old, stacks)
+	case core.KindStack:
+		var stack, old *zv1.Stack
+		stack, old, err = decodeObjects[zv1.Stack](request)
+		if err != nil {
+			return nil, err
+		}
+		err = core.ValidateStack(stack, old)
+	default:
+		return allowed(), nil
+	}
+
+	if err != nil {
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: fmt.Sprintf("invalid %s: %v", request.Kind.Kind, err),
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+			},
+		}, nil
+	}
+	return allowed(), nil
+}
+
+// mutate sets the defaults of StackSets.
+func (w *Webhook) mutate(_ context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	if request.Kind.Kind != core.KindStackSet || (request.Operation != admissionv1.Create && request.Operation != admissionv1.Update) {
+		return allowed(), nil
+	}
+
+	stackset, old, err := decodeObjects[zv1.StackSet](request)
+	if err != nil {
+		return nil, err
+	}
+
+	defaulted := stackset.DeepCopy()
+	core.DefaultStackSet(defaulted, old)
+
+	patch, err := createPatch(request.Object.Raw, stackset, defaulted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch for %s: %v", request.Kind.Kind, err)
+	}
+
+	response := allowed()
+	if len(patch) == 0 {
+		return response, nil
+	}
+
+	response.Patch, err = json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.PatchType = &patchType
+	return response, nil
+}
+
+// stackNames returns the names of the existing stacks of the StackSet.
+func (w *Webhook) stackNames(ctx context.Context, stackset *zv1.StackSet) ([]string, error) {
+	heritageLabels := map[string]string{
+		core.StacksetHeritageLabelKey: stackset.Name,
+	}
+	stacks, err := w.client.ZalandoV1().Stacks(stackset.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(heritageLabels).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stacks of stackset %s/%s: %v", stackset.Namespace, stackset.Name, err)
+	}
+
+	names := make([]string, 0, len(stacks.Items))
+	for _, stack := range stacks.Items {
+		names = append(names, stack.Name)
+	}
+	return names, nil
+}
+
+// decodeObjects decodes the object of the admission request and, for
+// updates, the old object.
+func decodeObjects[T any](request *admissionv1.AdmissionRequest) (*T, *T, error) {
+	obj := new(T)
+	err := json.Unmarshal(request.Object.Raw, obj)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s: %v", request.Kind.Kind, err)
+	}
+
+	if request.Operation != admissionv1.Update || len(request.OldObject.Raw) == 0 {
+		return obj, nil, nil
+	}
+
+	old := new(T)
+	err = json.Unmarshal(request.OldObject.Raw, old)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode old %s: %v", request.Kind.Kind, err)
+	}
+	return obj, old, nil
+}
+
+func allowed() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+	}
+}
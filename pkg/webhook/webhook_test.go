@@ -0,0 +1,268 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	rginterface "github.com/szuecs/routegroup-client/client/clientset/versioned"
+	rgfake "github.com/szuecs/routegroup-client/client/clientset/versioned/fake"
+	rgi "github.com/szuecs/routegroup-client/client/clientset/versioned/typed/zalando.org/v1"
+	zv1 "github.com/zalando-incubator/stackset-controller/pkg/apis/zalando.org/v1"
+	ssinterface "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned"
+	ssfake "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned/fake"
+	zi "github.com/zalando-incubator/stackset-controller/pkg/client/clientset/versioned/typed/zalando.org/v1"
+	"github.com/zalando-incubator/stackset-controller/pkg/core"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testClient struct {
+	kubernetes.Interface
+	ssClient ssinterface.Interface
+	rgClient rginterface.Interface
+}
+
+func (c *testClient) ZalandoV1() zi.ZalandoV1Interface {
+	return c.ssClient.ZalandoV1()
+}
+
+func (c *testClient) RouteGroupV1() rgi.ZalandoV1Interface {
+	return c.rgClient.ZalandoV1()
+}
+
+func newTestClient(objects ...runtime.Object) *testClient {
+	return &testClient{
+		Interface: fake.NewSimpleClientset(),
+		ssClient:  ssfake.NewSimpleClientset(objects...),
+		rgClient:  rgfake.NewSimpleClientset(),
+	}
+}
+
+func testStackSet(traffic ...*zv1.DesiredTraffic) *zv1.StackSet {
+	return &zv1.StackSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+		},
+		Spec: zv1.StackSetSpec{
+			Ingress: &zv1.StackSetIngressSpec{},
+			Traffic: traffic,
+			StackTemplate: zv1.StackTemplate{
+				Spec: zv1.StackSpecTemplate{
+					Version: "v2",
+				},
+			},
+		},
+	}
+}
+
+func review(t *testing.T, handler http.Handler, path string, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admission.k8s.io/v1",
+			Kind:       "AdmissionReview",
+		},
+		Request: request,
+	})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var result admissionv1.AdmissionReview
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.Equal(t, "AdmissionReview", result.Kind)
+	require.NotNil(t, result.Response)
+	require.Equal(t, request.UID, result.Response.UID)
+	return result.Response
+}
+
+func rawObject(t *testing.T, obj interface{}) runtime.RawExtension {
+	data, err := json.Marshal(obj)
+	require.NoError(t, err)
+	return runtime.RawExtension{Raw: data}
+}
+
+func TestValidate(t *testing.T) {
+	existingStack := &zv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo-v1",
+			Namespace: "default",
+			Labels:    map[string]string{core.StacksetHeritageLabelKey: "foo"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		operation       admissionv1.Operation
+		kind            string
+		object          interface{}
+		oldObject       interface{}
+		expectedAllowed bool
+		expectedMessage string
+	}{
+		{
+			name:            "valid stackset",
+			operation:       admissionv1.Create,
+			kind:            core.KindStackSet,
+			object:          testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+			expectedAllowed: true,
+		},
+		{
+			name:            "traffic to an unknown stack",
+			operation:       admissionv1.Create,
+			kind:            core.KindStackSet,
+			object:          testStackSet(&zv1.DesiredTraffic{StackName: "foo-v0", Weight: 100}),
+			expectedMessage: "invalid StackSet: traffic: unknown stack foo-v0",
+		},
+		{
+			name:      "traffic not adding up to 100",
+			operation: admissionv1.Update,
+			kind:      core.KindStackSet,
+			object: testStackSet(
+				&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 50},
+				&zv1.DesiredTraffic{StackName: "foo-v2", Weight: 40},
+			),
+			oldObject:       testStackSet(&zv1.DesiredTraffic{StackName: "foo-v1", Weight: 100}),
+			expectedMessage: "invalid StackSet: traffic: weights must add up to 100, got 90",
+		},
+		{
+			name:      "invalid stack",
+			operation: admissionv1.Create,
+			kind:      core.KindStack,
+			object: &zv1.Stack{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo-v2", Namespace: "default"},
+				Spec: zv1.StackSpecInternal{
+					StackSpec: zv1.StackSpec{
+						ConfigurationResources: []zv1.ConfigurationResourcesSpec{
+							{ConfigMapRef: &v1.LocalObjectReference{Name: "bar"}},
+						},
+					},
+				},
+			},
+			expectedMessage: "invalid Stack: ConfigurationResource name must be prefixed by Stack name. ConfigurationResource: bar, Stack: foo-v2",
+		},
+		{
+			name:            "deletion",
+			operation:       admissionv1.Delete,
+			kind:            core.KindStackSet,
+			expectedAllowed: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := &admissionv1.AdmissionRequest{
+				UID:       "9ba7a1a0-2e02-4b16-a4c1-5a8f1e0e7c1d",
+				Kind:      metav1.GroupVersionKind{Group: "zalando.org", Version: "v1", Kind: tc.kind},
+				Namespace: "default",
+				Operation: tc.operation,
+			}
+			if tc.object != nil {
+				request.Object = rawObject(t, tc.object)
+			}
+			if tc.oldObject != nil {
+				request.OldObject = rawObject(t, tc.oldObject)
+			}
+
+			response := review(t, New(newTestClient(existingStack)).Handler(), ValidatePath, request)
+			require.Equal(t, tc.expectedAllowed, response.Allowed)
+			if !tc.expectedAllowed {
+				require.Equal(t, tc.expectedMessage, response.Result.Message)
+				require.EqualValues(t, http.StatusUnprocessableEntity, response.Result.Code)
+			}
+		})
+	}
+}
+
+func TestServeAdmissionErrors(t *testing.T) {
+	handler := New(newTestClient()).Handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ValidatePath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader([]byte("{}"))))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	response := review(t, handler, ValidatePath, &admissionv1.AdmissionRequest{
+		UID:       "2b0c9e0a-3c8e-4f4b-9d55-0c1f4d3c1a2e",
+		Kind:      metav1.GroupVersionKind{Group: "zalando.org", Version: "v1", Kind: core.KindStackSet},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"spec": "invalid"}`)},
+	})
+	require.False(t, response.Allowed)
+	require.EqualValues(t, http.StatusInternalServerError, response.Result.Code)
+}
+
+func TestMutate(t *testing.T) {
+	stackset := `{
+  "apiVersion": "zalando.org/v1",
+  "kind": "StackSet",
+  "metadata": {"name": "foo", "namespace": "default"},
+  "spec": {
+    "ingress": {"hosts": ["foo.example.org"], "backendPort": 80},
+    "stackTemplate": {
+      "spec": {
+        "version": "v1",
+        "podTemplate": {
+          "spec": {
+            "containers": [{"name": "foo", "image": "foo", "ports": [{"containerPort": 8080}]}]
+          }
+        }
+      }
+    }
+  }
+}`
+
+	handler := New(newTestClient()).Handler()
+	response := review(t, handler, MutatePath, &admissionv1.AdmissionRequest{
+		UID:       "7c7d0d4e-2f34-4a8f-9f5a-2b0f0d6e8a31",
+		Kind:      metav1.GroupVersionKind{Group: "zalando.org", Version: "v1", Kind: core.KindStackSet},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(stackset)},
+	})
+	require.True(t, response.Allowed)
+	require.Equal(t, admissionv1.PatchTypeJSONPatch, *response.PatchType)
+
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	require.NoError(t, err)
+	patched, err := patch.Apply([]byte(stackset))
+	require.NoError(t, err)
+
+	var result zv1.StackSet
+	require.NoError(t, json.Unmarshal(patched, &result))
+	require.EqualValues(t, 10, *result.Spec.StackLifecycle.Limit)
+	require.EqualValues(t, 300, *result.Spec.StackLifecycle.ScaledownTTLSeconds)
+	require.Equal(t, []v1.ServicePort{
+		{Name: "port-0-0", Protocol: v1.ProtocolTCP, Port: 8080, TargetPort: intstr.FromInt(8080)},
+	}, result.Spec.StackTemplate.Spec.Service.Ports)
+
+	// the patch only contains the defaults
+	var unstructured map[string]interface{}
+	require.NoError(t, json.Unmarshal(patched, &unstructured))
+	template := unstructured["spec"].(map[string]interface{})["stackTemplate"].(map[string]interface{})
+	require.NotContains(t, template, "metadata")
+
+	// defaulted StackSets aren't changed again
+	response = review(t, handler, MutatePath, &admissionv1.AdmissionRequest{
+		UID:       "0f3b1c2e-5d6a-4b7c-8e9f-a0b1c2d3e4f5",
+		Kind:      metav1.GroupVersionKind{Group: "zalando.org", Version: "v1", Kind: core.KindStackSet},
+		Operation: admissionv1.Update,
+		Object:    runtime.RawExtension{Raw: patched},
+		OldObject: runtime.RawExtension{Raw: patched},
+	})
+	require.True(t, response.Allowed)
+	require.Nil(t, response.Patch)
+	require.Nil(t, response.PatchType)
+}